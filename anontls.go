@@ -0,0 +1,600 @@
+/*
+Implementation of TLS with anonymous Diffie-Hellman key exchange.
+
+Servers of the TLS security type, and of the TLS sub-types of VeNCrypt, have
+no certificate, and only offer the anonymous Diffie-Hellman cipher suites,
+which crypto/tls does not implement. anonTLSConn is a minimal client for them:
+TLS 1.0 to 1.2 with the TLS_DH_anon AES-CBC cipher suites, without session
+resumption or renegotiation. Anonymous key exchange does not authenticate the
+server, so the connection is only protected from passive eavesdroppers.
+
+See RFC 5246 (TLS 1.2), RFC 4346 (TLS 1.1) and RFC 2246 (TLS 1.0).
+https://tools.ietf.org/html/rfc5246
+*/
+package vnc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net"
+	"slices"
+	"sync"
+)
+
+// TLS record content types.
+const (
+	tlsRecordChangeCipherSpec = uint8(20)
+	tlsRecordAlert            = uint8(21)
+	tlsRecordHandshake        = uint8(22)
+	tlsRecordApplicationData  = uint8(23)
+)
+
+// TLS handshake message types.
+const (
+	tlsClientHello       = uint8(1)
+	tlsServerHello       = uint8(2)
+	tlsServerKeyExchange = uint8(12)
+	tlsServerHelloDone   = uint8(14)
+	tlsClientKeyExchange = uint8(16)
+	tlsFinished          = uint8(20)
+)
+
+// TLS protocol versions.
+const (
+	tlsVersion10 = uint16(0x0301)
+	tlsVersion11 = uint16(0x0302)
+	tlsVersion12 = uint16(0x0303)
+)
+
+// TLS alert level and description of close_notify.
+const (
+	tlsAlertWarning     = uint8(1)
+	tlsAlertCloseNotify = uint8(0)
+)
+
+// Limits of TLS records and handshake messages, in bytes.
+const (
+	tlsMaxPlaintext    = 1 << 14
+	tlsMaxRecord       = tlsMaxPlaintext + 2048
+	tlsMaxHandshake    = 1 << 18
+	tlsFinishedSize    = 12
+	tlsMasterSize      = 48
+	tlsMinDHPrimeBits  = 1024
+	tlsMaxDHPrimeBytes = 1024
+)
+
+// anonCipherSuite describes a TLS_DH_anon cipher suite with AES-CBC.
+type anonCipherSuite struct {
+	id     uint16
+	keyLen int              // Length of the AES key, in bytes.
+	mac    func() hash.Hash // Hash of the record MAC.
+	tls12  bool             // Whether the suite requires TLS 1.2.
+}
+
+// anonCipherSuites are offered by the client, in order of preference.
+var anonCipherSuites = []anonCipherSuite{
+	{0x006d, 32, sha256.New, true}, // TLS_DH_anon_WITH_AES_256_CBC_SHA256
+	{0x006c, 16, sha256.New, true}, // TLS_DH_anon_WITH_AES_128_CBC_SHA256
+	{0x003a, 32, sha1.New, false},  // TLS_DH_anon_WITH_AES_256_CBC_SHA
+	{0x0034, 16, sha1.New, false},  // TLS_DH_anon_WITH_AES_128_CBC_SHA
+}
+
+// tlsEmptyRenegotiationInfoSCSV signals that renegotiation is not supported.
+const tlsEmptyRenegotiationInfoSCSV = uint16(0x00ff)
+
+// tlsKeys holds the keys of the records sent in one direction.
+type tlsKeys struct {
+	mac, key, iv []byte
+}
+
+// tlsHalfConn protects the records of one direction of an anonTLSConn.
+type tlsHalfConn struct {
+	version uint16
+	seq     uint64
+	block   cipher.Block     // Nil until ChangeCipherSpec.
+	cbc     cipher.BlockMode // Chains the IV across records in TLS 1.0.
+	mac     hash.Hash
+}
+
+// setCipher protects the later records with suite and keys. The keys encrypt
+// records if encrypt is true, and decrypt them otherwise.
+func (hc *tlsHalfConn) setCipher(suite *anonCipherSuite, keys tlsKeys, encrypt bool) error {
+	block, err := aes.NewCipher(keys.key)
+	if err != nil {
+		return err
+	}
+	hc.block = block
+	hc.mac = hmac.New(suite.mac, keys.mac)
+	hc.seq = 0
+	if encrypt {
+		hc.cbc = cipher.NewCBCEncrypter(block, keys.iv)
+	} else {
+		hc.cbc = cipher.NewCBCDecrypter(block, keys.iv)
+	}
+	return nil
+}
+
+// recordMAC returns the MAC of a record, and increments the sequence number.
+func (hc *tlsHalfConn) recordMAC(typ uint8, payload []byte) []byte {
+	var header [13]byte
+	binary.BigEndian.PutUint64(header[:8], hc.seq)
+	header[8] = typ
+	binary.BigEndian.PutUint16(header[9:], hc.version)
+	binary.BigEndian.PutUint16(header[11:], uint16(len(payload)))
+	hc.seq++
+
+	hc.mac.Reset()
+	hc.mac.Write(header[:])
+	hc.mac.Write(payload)
+	return hc.mac.Sum(nil)
+}
+
+// seal returns the record of payload, which is at most tlsMaxPlaintext long.
+func (hc *tlsHalfConn) seal(typ uint8, payload []byte, rand io.Reader) ([]byte, error) {
+	record := []byte{typ, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(record[1:], hc.version)
+	if hc.block == nil {
+		binary.BigEndian.PutUint16(record[3:], uint16(len(payload)))
+		return append(record, payload...), nil
+	}
+
+	// Records are MAC-then-encrypt, padded to the block size.
+	bs := hc.block.BlockSize()
+	data := append(slices.Clone(payload), hc.recordMAC(typ, payload)...)
+	padLen := bs - len(data)%bs
+	for range padLen {
+		data = append(data, uint8(padLen-1))
+	}
+	cbc := hc.cbc
+	if hc.version >= tlsVersion11 {
+		// Each record starts with an explicit IV.
+		iv := make([]byte, bs)
+		if _, err := io.ReadFull(rand, iv); err != nil {
+			return nil, err
+		}
+		record = append(record, iv...)
+		cbc = cipher.NewCBCEncrypter(hc.block, iv)
+	}
+	cbc.CryptBlocks(data, data)
+	record = append(record, data...)
+	binary.BigEndian.PutUint16(record[3:], uint16(len(record)-5))
+	return record, nil
+}
+
+// open returns the payload of the data of a record.
+func (hc *tlsHalfConn) open(typ uint8, data []byte) ([]byte, error) {
+	if hc.block == nil {
+		return data, nil
+	}
+
+	bs := hc.block.BlockSize()
+	cbc := hc.cbc
+	if hc.version >= tlsVersion11 {
+		if len(data) < bs {
+			return nil, errTLSBadRecord
+		}
+		cbc = cipher.NewCBCDecrypter(hc.block, data[:bs])
+		data = data[bs:]
+	}
+	macSize := hc.mac.Size()
+	if len(data)%bs != 0 || len(data) < macSize+1 {
+		return nil, errTLSBadRecord
+	}
+	cbc.CryptBlocks(data, data)
+
+	padLen := int(data[len(data)-1]) + 1
+	if padLen > len(data)-macSize {
+		return nil, errTLSBadRecord
+	}
+	for _, b := range data[len(data)-padLen:] {
+		if int(b) != padLen-1 {
+			return nil, errTLSBadRecord
+		}
+	}
+	data = data[:len(data)-padLen]
+	payload, mac := data[:len(data)-macSize], data[len(data)-macSize:]
+	if !hmac.Equal(mac, hc.recordMAC(typ, payload)) {
+		return nil, errTLSBadRecord
+	}
+	return payload, nil
+}
+
+var errTLSBadRecord = errors.New("TLS record failed to decrypt")
+
+// anonTLSConn is a TLS connection with anonymous Diffie-Hellman key exchange.
+type anonTLSConn struct {
+	net.Conn
+	rand io.Reader
+
+	version    uint16
+	suite      *anonCipherSuite
+	master     []byte
+	clientKeys tlsKeys
+	serverKeys tlsKeys
+	transcript bytes.Buffer // Handshake messages, for the Finished messages.
+
+	in        tlsHalfConn
+	handshake []byte // Handshake data not read yet.
+	buf       []byte // Application data not read yet.
+
+	mu  sync.Mutex // Guards out.
+	out tlsHalfConn
+}
+
+// Verify that interfaces are honored.
+var _ net.Conn = (*anonTLSConn)(nil)
+
+// newAnonTLSConn returns a TLS connection over c, whose randomness is read
+// from rand. The handshake is not performed yet.
+func newAnonTLSConn(c net.Conn, rand io.Reader) *anonTLSConn {
+	return &anonTLSConn{
+		Conn: c,
+		rand: rand,
+		in:   tlsHalfConn{version: tlsVersion10},
+		out:  tlsHalfConn{version: tlsVersion10},
+	}
+}
+
+// clientHandshake performs the handshake of the client.
+func (c *anonTLSConn) clientHandshake() error {
+	clientRandom := make([]byte, 32)
+	if _, err := io.ReadFull(c.rand, clientRandom); err != nil {
+		return err
+	}
+	hello := binary.BigEndian.AppendUint16(nil, tlsVersion12)
+	hello = append(hello, clientRandom...)
+	hello = append(hello, 0) // session_id
+	hello = binary.BigEndian.AppendUint16(hello, uint16(2*len(anonCipherSuites)+2))
+	for _, suite := range anonCipherSuites {
+		hello = binary.BigEndian.AppendUint16(hello, suite.id)
+	}
+	hello = binary.BigEndian.AppendUint16(hello, tlsEmptyRenegotiationInfoSCSV)
+	hello = append(hello, 1, 0) // compression_methods: null
+	if err := c.writeHandshake(tlsClientHello, hello); err != nil {
+		return err
+	}
+
+	msg, err := c.readHandshake(tlsServerHello)
+	if err != nil {
+		return err
+	}
+	serverRandom, err := c.readServerHello(msg)
+	if err != nil {
+		return err
+	}
+
+	msg, err = c.readHandshake(tlsServerKeyExchange)
+	if err != nil {
+		return err
+	}
+	p, g, serverPublic, err := readDHParams(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := c.readHandshake(tlsServerHelloDone); err != nil {
+		return err
+	}
+
+	// Agree on the premaster secret.
+	x, err := rand.Int(c.rand, new(big.Int).Sub(p, big.NewInt(3)))
+	if err != nil {
+		return err
+	}
+	x.Add(x, big.NewInt(2))
+	public := new(big.Int).Exp(g, x, p).Bytes()
+	kx := binary.BigEndian.AppendUint16(nil, uint16(len(public)))
+	if err := c.writeHandshake(tlsClientKeyExchange, append(kx, public...)); err != nil {
+		return err
+	}
+	premaster := new(big.Int).Exp(serverPublic, x, p).Bytes()
+	c.establishKeys(premaster, clientRandom, serverRandom)
+
+	if err := c.changeWriteCipher(c.clientKeys); err != nil {
+		return err
+	}
+	if err := c.writeHandshake(tlsFinished, c.finishedData("client finished")); err != nil {
+		return err
+	}
+	if err := c.readChangeCipherSpec(c.serverKeys); err != nil {
+		return err
+	}
+	return c.readFinished("server finished")
+}
+
+// readServerHello reads the version and cipher suite chosen by the server, and
+// returns the server random.
+func (c *anonTLSConn) readServerHello(msg []byte) ([]byte, error) {
+	if len(msg) < 35 || len(msg) < 38+int(msg[34]) {
+		return nil, fmt.Errorf("TLS ServerHello of %d bytes is too short", len(msg))
+	}
+	version := binary.BigEndian.Uint16(msg)
+	serverRandom := msg[2:34]
+	rest := msg[35+int(msg[34]):]
+	id, compression := binary.BigEndian.Uint16(rest), rest[2]
+
+	if version < tlsVersion10 || version > tlsVersion12 {
+		return nil, fmt.Errorf("unsupported TLS version %#04x", version)
+	}
+	for i := range anonCipherSuites {
+		if s := &anonCipherSuites[i]; s.id == id && (!s.tls12 || version == tlsVersion12) {
+			c.suite = s
+		}
+	}
+	if c.suite == nil {
+		return nil, fmt.Errorf("unsupported TLS cipher suite %#04x", id)
+	}
+	if compression != 0 {
+		return nil, fmt.Errorf("unsupported TLS compression method %d", compression)
+	}
+	c.version = version
+	c.in.version, c.out.version = version, version
+	return serverRandom, nil
+}
+
+// readDHParams reads the Diffie-Hellman parameters of a ServerKeyExchange
+// message: the prime, generator and public value of the server.
+func readDHParams(msg []byte) (p, g, public *big.Int, err error) {
+	var params [3]*big.Int
+	for i := range params {
+		if len(msg) < 2 || len(msg) < 2+int(binary.BigEndian.Uint16(msg)) {
+			return nil, nil, nil, fmt.Errorf("TLS ServerKeyExchange of %d bytes is too short", len(msg))
+		}
+		n := int(binary.BigEndian.Uint16(msg))
+		params[i] = new(big.Int).SetBytes(msg[2 : 2+n])
+		msg = msg[2+n:]
+	}
+	p, g, public = params[0], params[1], params[2]
+
+	pMinus1 := new(big.Int).Sub(p, big.NewInt(1))
+	switch {
+	case p.BitLen() < tlsMinDHPrimeBits || p.BitLen() > 8*tlsMaxDHPrimeBytes:
+		return nil, nil, nil, fmt.Errorf("unsupported TLS Diffie-Hellman prime of %d bits", p.BitLen())
+	case g.Cmp(big.NewInt(1)) <= 0 || g.Cmp(pMinus1) >= 0:
+		return nil, nil, nil, fmt.Errorf("invalid TLS Diffie-Hellman generator")
+	case public.Cmp(big.NewInt(1)) <= 0 || public.Cmp(pMinus1) >= 0:
+		return nil, nil, nil, fmt.Errorf("invalid TLS Diffie-Hellman public value")
+	}
+	return p, g, public, nil
+}
+
+// establishKeys derives the master secret and the keys of both directions from
+// the premaster secret.
+func (c *anonTLSConn) establishKeys(premaster, clientRandom, serverRandom []byte) {
+	c.master = tlsPRF(c.version, premaster, "master secret", slices.Concat(clientRandom, serverRandom), tlsMasterSize)
+
+	macLen, keyLen := c.suite.mac().Size(), c.suite.keyLen
+	block := tlsPRF(c.version, c.master, "key expansion", slices.Concat(serverRandom, clientRandom),
+		2*macLen+2*keyLen+2*aes.BlockSize)
+	next := func(n int) []byte {
+		b := block[:n]
+		block = block[n:]
+		return b
+	}
+	c.clientKeys.mac, c.serverKeys.mac = next(macLen), next(macLen)
+	c.clientKeys.key, c.serverKeys.key = next(keyLen), next(keyLen)
+	c.clientKeys.iv, c.serverKeys.iv = next(aes.BlockSize), next(aes.BlockSize)
+}
+
+// finishedData returns the verify_data of a Finished message with label,
+// computed over the handshake messages so far.
+func (c *anonTLSConn) finishedData(label string) []byte {
+	var seed []byte
+	if c.version >= tlsVersion12 {
+		sum := sha256.Sum256(c.transcript.Bytes())
+		seed = sum[:]
+	} else {
+		md5Sum, sha1Sum := md5.Sum(c.transcript.Bytes()), sha1.Sum(c.transcript.Bytes())
+		seed = slices.Concat(md5Sum[:], sha1Sum[:])
+	}
+	return tlsPRF(c.version, c.master, label, seed, tlsFinishedSize)
+}
+
+// changeWriteCipher sends a ChangeCipherSpec message, and protects the later
+// records written with keys.
+func (c *anonTLSConn) changeWriteCipher(keys tlsKeys) error {
+	if err := c.writeRecord(tlsRecordChangeCipherSpec, []byte{1}); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.out.setCipher(c.suite, keys, true)
+}
+
+// readChangeCipherSpec reads a ChangeCipherSpec message, and protects the
+// later records read with keys.
+func (c *anonTLSConn) readChangeCipherSpec(keys tlsKeys) error {
+	typ, payload, err := c.readRecord()
+	if err != nil {
+		return err
+	}
+	if typ != tlsRecordChangeCipherSpec || !bytes.Equal(payload, []byte{1}) {
+		return fmt.Errorf("unexpected TLS record of type %d, want ChangeCipherSpec", typ)
+	}
+	return c.in.setCipher(c.suite, keys, false)
+}
+
+// readFinished reads a Finished message, and verifies its verify_data for
+// label.
+func (c *anonTLSConn) readFinished(label string) error {
+	want := c.finishedData(label)
+	msg, err := c.readHandshake(tlsFinished)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(msg, want) {
+		return fmt.Errorf("TLS Finished message verification failed")
+	}
+	return nil
+}
+
+// readRecord reads a record, and returns its type and payload. Alerts are
+// returned as errors, io.EOF for close_notify.
+func (c *anonTLSConn) readRecord() (uint8, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+		return 0, nil, err
+	}
+	typ, length := header[0], int(binary.BigEndian.Uint16(header[3:]))
+	if length > tlsMaxRecord {
+		return 0, nil, fmt.Errorf("TLS record of %d bytes is too long", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.Conn, data); err != nil {
+		return 0, nil, err
+	}
+	payload, err := c.in.open(typ, data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(payload) > tlsMaxPlaintext {
+		return 0, nil, fmt.Errorf("TLS record of %d bytes is too long", len(payload))
+	}
+
+	if typ == tlsRecordAlert {
+		if len(payload) != 2 {
+			return 0, nil, fmt.Errorf("TLS alert of %d bytes is invalid", len(payload))
+		}
+		if payload[1] == tlsAlertCloseNotify {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, fmt.Errorf("TLS alert %d received from server", payload[1])
+	}
+	return typ, payload, nil
+}
+
+// readHandshake reads a handshake message of type want, and returns its body.
+func (c *anonTLSConn) readHandshake(want uint8) ([]byte, error) {
+	for {
+		if len(c.handshake) >= 4 {
+			n := int(binary.BigEndian.Uint32(c.handshake) & 0xffffff)
+			if n > tlsMaxHandshake {
+				return nil, fmt.Errorf("TLS handshake message of %d bytes is too long", n)
+			}
+			if len(c.handshake) >= 4+n {
+				break
+			}
+		}
+		typ, payload, err := c.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		if typ != tlsRecordHandshake {
+			return nil, fmt.Errorf("unexpected TLS record of type %d, want handshake", typ)
+		}
+		c.handshake = append(c.handshake, payload...)
+	}
+
+	n := int(binary.BigEndian.Uint32(c.handshake) & 0xffffff)
+	msg := c.handshake[:4+n]
+	c.handshake = c.handshake[4+n:]
+	if msg[0] != want {
+		return nil, fmt.Errorf("unexpected TLS handshake message of type %d, want %d", msg[0], want)
+	}
+	c.transcript.Write(msg)
+	return msg[4:], nil
+}
+
+// writeHandshake writes a handshake message of type typ.
+func (c *anonTLSConn) writeHandshake(typ uint8, body []byte) error {
+	msg := binary.BigEndian.AppendUint32(nil, uint32(typ)<<24|uint32(len(body)))
+	msg = append(msg, body...)
+	c.transcript.Write(msg)
+	return c.writeRecord(tlsRecordHandshake, msg)
+}
+
+// writeRecord writes payload in records of type typ.
+func (c *anonTLSConn) writeRecord(typ uint8, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var records []byte
+	for len(payload) > 0 || records == nil {
+		n := min(len(payload), tlsMaxPlaintext)
+		record, err := c.out.seal(typ, payload[:n], c.rand)
+		if err != nil {
+			return err
+		}
+		records = append(records, record...)
+		payload = payload[n:]
+	}
+	_, err := c.Conn.Write(records)
+	return err
+}
+
+// Read implements the net.Conn interface.
+func (c *anonTLSConn) Read(b []byte) (int, error) {
+	for len(c.buf) == 0 {
+		typ, payload, err := c.readRecord()
+		if err != nil {
+			return 0, err
+		}
+		if typ != tlsRecordApplicationData {
+			return 0, fmt.Errorf("unexpected TLS record of type %d, want application data", typ)
+		}
+		c.buf = payload
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Write implements the net.Conn interface.
+func (c *anonTLSConn) Write(b []byte) (int, error) {
+	if err := c.writeRecord(tlsRecordApplicationData, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close implements the net.Conn interface. A close_notify alert is sent
+// before closing the connection.
+func (c *anonTLSConn) Close() error {
+	c.writeRecord(tlsRecordAlert, []byte{tlsAlertWarning, tlsAlertCloseNotify})
+	return c.Conn.Close()
+}
+
+// tlsPRF returns n bytes of the pseudorandom function of version.
+func tlsPRF(version uint16, secret []byte, label string, seed []byte, n int) []byte {
+	labelSeed := append([]byte(label), seed...)
+	if version >= tlsVersion12 {
+		return tlsPHash(sha256.New, secret, labelSeed, n)
+	}
+	// TLS 1.0 and 1.1 combine MD5 and SHA-1, each over half of the secret.
+	half := (len(secret) + 1) / 2
+	out := tlsPHash(md5.New, secret[:half], labelSeed, n)
+	for i, b := range tlsPHash(sha1.New, secret[len(secret)-half:], labelSeed, n) {
+		out[i] ^= b
+	}
+	return out
+}
+
+// tlsPHash returns n bytes of the data expansion function P_hash.
+func tlsPHash(h func() hash.Hash, secret, seed []byte, n int) []byte {
+	mac := hmac.New(h, secret)
+	mac.Write(seed)
+	a := mac.Sum(nil)
+	var out []byte
+	for len(out) < n {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(seed)
+		out = mac.Sum(out)
+
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+	}
+	return out[:n]
+}
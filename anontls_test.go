@@ -0,0 +1,336 @@
+package vnc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	mrand "math/rand"
+	"net"
+	"testing"
+)
+
+// Sessions of anonTLSConn with OpenSSL 3.0 (openssl s_server -nocert -rev),
+// captured with the randomness of the client read from math/rand seeded with
+// 1. The client sends "hello world\n", reads the reversed line back, and
+// closes the connection.
+const (
+	// TLS 1.2, TLS_DH_anon_WITH_AES_128_CBC_SHA256.
+	opensslTLS12Client = "" +
+		"160301003501000031030352fdfc072182654f163f5f0f9a621d729566c74d10" +
+		"037c4d7bbb0407d1e2c64900000a006d006c003a003400ff0100160303008610" +
+		"0000820080bd0d8d7cbdbc0a943949ce35395496acbb30e4203249b10abdfc22" +
+		"633e12952049c27411c2e405918d147ef13fb6be1a49fbbeab3d6dddbae80767" +
+		"f427126bb0ba153970b54aee192632227b4f993d8141cd2abf8ad0d7ec2d1744" +
+		"09364cee6f3f7a35340b4addfcb6ef8ae703ad3467bcc00d309b5f2b9fda3065" +
+		"363f74949414030300010116030300500bf5059875921e668a5bdf2c7fc48445" +
+		"1bde637ffb55403643991a97122bafb1f0e63d6fa3e541368b0145fafc59616d" +
+		"1a173644edc7af1e51536b1b23aebae1a95eb4412d32aa8671dd3809038be757" +
+		"170303004092d2572bcd0668d2d6c52f5054e2d0836985c09cfdcf2333da989e" +
+		"f2152205fec93abdee79cbae078c06314389fa961cbd7b96fcf7a33133c2c0a7" +
+		"298e5a6a5015030300406bf84c7174cb7476364cc3dbd968b0f7811d3e543cca" +
+		"e1527b54872881381c4045561a3ba501f294ff745b5951b98a0a3c1473126815" +
+		"930492bc97c9635a6250"
+	opensslTLS12Server = "" +
+		"16030300510200004d03032514bf2570879f781ec6576ed13db6df6ad7b442ea" +
+		"c922550b2de3bba3e7a9fd20943ebd5ac86ba4e2a8821e9b50225d0b1e93b843" +
+		"6da6db30351bd5c2a7395df4006c000005ff01000100160303010b0c00010700" +
+		"80c0cf9aedda275f3fc5a083b8eb1e6cba6f62ae9ccf86c703fd4d019a6e82f4" +
+		"216bd26f2d431fb071209f68d61e566bb0e0003f7060e903da3c4162c03f0c4b" +
+		"48f020408bd62eba9d0f1bf2eea8c7865860e139f30f246a05150fae567f641d" +
+		"c9d7cc71c2e5cc18e016f1b1832667db5f0541dcec391ed5fa333efccfd95dd8" +
+		"a7000102008091e7a6361c5f44a763a90378d5bf828ea9e599c4d0b1d4285b00" +
+		"165140704fa92a0512f3ef1787b841a5fba9b4f229e34db7635eb5cef119c967" +
+		"1cd3782926420f2cb9616403dda7a13baac35a4cdbefcdf1a4488e114dfbcca1" +
+		"01e680a8d08d0834762885f7ee0dc6af5b48a6911c74d4c12387cb825510d0df" +
+		"1f073c699b3c16030300040e00000014030300010116030300508766a60dbcf4" +
+		"c0d81dcb3dfd300f3dac3ec5c6f658d6a0b7e203fb9db811d81f9f42769ffc4f" +
+		"4cb46f68598aa221a7c1d79fa3cbe3b6c894090ff160dcd3a85b0b46cbb5a927" +
+		"1f899ef43a32fea1551f170303004019c507f810bbecca866ddab08899678bea" +
+		"570ea1db0bab2997846b72d2b8d6dcf84ef7f2ead6a3d4bf1665b5f1c6d226ec" +
+		"ae212bdc3daf4ff075a6434de7021b"
+
+	// TLS 1.0, TLS_DH_anon_WITH_AES_128_CBC_SHA.
+	opensslTLS10Client = "" +
+		"160301003501000031030352fdfc072182654f163f5f0f9a621d729566c74d10" +
+		"037c4d7bbb0407d1e2c64900000a006d006c003a003400ff0100160301008610" +
+		"0000820080bd0d8d7cbdbc0a943949ce35395496acbb30e4203249b10abdfc22" +
+		"633e12952049c27411c2e405918d147ef13fb6be1a49fbbeab3d6dddbae80767" +
+		"f427126bb0ba153970b54aee192632227b4f993d8141cd2abf8ad0d7ec2d1744" +
+		"09364cee6f3f7a35340b4addfcb6ef8ae703ad3467bcc00d309b5f2b9fda3065" +
+		"363f74949414030100010116030100304f181682243b5b937abc8ac3b35603d6" +
+		"cd4b9a7b4bf104215fb05b3f90a10b46a33f4ba1a915c98ca8be04779d08ad71" +
+		"170301003035c9547baeba11e1b71375167994fb55bcc9e08451bf191a5d70e0" +
+		"c9818b12bda5d3e76586852afa97362e70f3d6197115030100203d6ef221176d" +
+		"a73a94aabc3540fc92d6b689f609b52718bd3d1ee7724a88c54d"
+	opensslTLS10Server = "" +
+		"16030100510200004d03015b170f88323bb6980eb8ef82ac2648d18abf712eeb" +
+		"80445253ecd624b8613376209eadfe433ac899477252fa544ec41ac5ec159e1d" +
+		"489e9ae3ebae211098db06520034000005ff01000100160301010b0c00010700" +
+		"80c0cf9aedda275f3fc5a083b8eb1e6cba6f62ae9ccf86c703fd4d019a6e82f4" +
+		"216bd26f2d431fb071209f68d61e566bb0e0003f7060e903da3c4162c03f0c4b" +
+		"48f020408bd62eba9d0f1bf2eea8c7865860e139f30f246a05150fae567f641d" +
+		"c9d7cc71c2e5cc18e016f1b1832667db5f0541dcec391ed5fa333efccfd95dd8" +
+		"a700010200806d46dda150408a32a8ff1dd3971a63d42052b634dc1b5edc4a55" +
+		"cf4958c8db8852fad1fc5e7fd8c158366dbc75c6d3f3d3d219459c65578b5ad6" +
+		"035f3a992437e7a4a988d2131b71eb79205b61ffce92533842742aa002db1cc0" +
+		"7e0c99da8d043d8216ea272d798256f51e598c237aea173c68df1359f53d0a54" +
+		"902ea71def7316030100040e0000001403010001011603010030cb23ff5d2594" +
+		"a3f60d9e1a9d9c10cc7fd8cff4905a33ac97b34ec747a663f001e1ff0b8a3a1d" +
+		"ec4bf98a25a2d971e1ea17030100206bf8ed0bbe40ac2cc4bf812b6acb6aa54a" +
+		"77f790c167205e18d44617b13d7373170301003083e9dfcda21538a16838e15e" +
+		"fd656322067a94db23fb98b12dff219139df54a8a2ade0b3cc15c4b7a34c7ac7" +
+		"abd7d8e3"
+)
+
+// replayConn replays the bytes sent by a server, and records the bytes written
+// by the client.
+type replayConn struct {
+	net.Conn // Unused; only Read, Write and Close are implemented.
+	r        io.Reader
+	w        bytes.Buffer
+}
+
+func (c *replayConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *replayConn) Write(b []byte) (int, error) { return c.w.Write(b) }
+func (c *replayConn) Close() error                { return nil }
+
+func TestAnonTLSConn_OpenSSL(t *testing.T) {
+	for _, tt := range []struct {
+		desc           string
+		client, server string
+		version, suite uint16
+	}{
+		{"TLS 1.2", opensslTLS12Client, opensslTLS12Server, tlsVersion12, 0x006c},
+		{"TLS 1.0", opensslTLS10Client, opensslTLS10Server, tlsVersion10, 0x0034},
+	} {
+		client, _ := hex.DecodeString(tt.client)
+		server, _ := hex.DecodeString(tt.server)
+		rc := &replayConn{r: bytes.NewReader(server)}
+		c := newAnonTLSConn(rc, mrand.New(mrand.NewSource(1)))
+
+		if err := c.clientHandshake(); err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if got, want := c.version, tt.version; got != want {
+			t.Errorf("%s: incorrect version; got = %#04x, want = %#04x", tt.desc, got, want)
+		}
+		if got, want := c.suite.id, tt.suite; got != want {
+			t.Errorf("%s: incorrect cipher suite; got = %#04x, want = %#04x", tt.desc, got, want)
+		}
+		if _, err := c.Write([]byte("hello world\n")); err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		buf := make([]byte, 12)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if got, want := string(buf), "dlrow olleh\n"; got != want {
+			t.Errorf("%s: incorrect data; got = %q, want = %q", tt.desc, got, want)
+		}
+		c.Close()
+		if got, want := rc.w.Bytes(), client; !bytes.Equal(got, want) {
+			t.Errorf("%s: incorrect client bytes;\n got = %x\nwant = %x", tt.desc, got, want)
+		}
+	}
+}
+
+func TestAnonTLSConn_Tampered(t *testing.T) {
+	server, _ := hex.DecodeString(opensslTLS12Server)
+	for _, tt := range []struct {
+		desc      string
+		offset    int  // Offset of the byte changed in the server bytes.
+		handshake bool // Whether the handshake succeeds.
+	}{
+		{"server key exchange", 200, false},
+		{"finished", 420, false},
+		{"application data", len(server) - 1, true},
+	} {
+		b := bytes.Clone(server)
+		b[tt.offset] ^= 0x01
+		c := newAnonTLSConn(&replayConn{r: bytes.NewReader(b)}, mrand.New(mrand.NewSource(1)))
+
+		err := c.clientHandshake()
+		if got, want := err == nil, tt.handshake; got != want {
+			t.Errorf("%s: incorrect handshake result; got = %v, want success = %v", tt.desc, err, want)
+			continue
+		}
+		if !tt.handshake {
+			continue
+		}
+		if _, err := c.Read(make([]byte, 12)); err != errTLSBadRecord {
+			t.Errorf("%s: incorrect error; got = %v, want = %v", tt.desc, err, errTLSBadRecord)
+		}
+	}
+}
+
+// oakley2 is the 1024-bit MODP group of RFC 2409, with generator 2.
+var oakley2, _ = new(big.Int).SetString(""+
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74"+
+	"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437"+
+	"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+	"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381FFFFFFFFFFFFFFFF", 16)
+
+// dhParams returns the ServerKeyExchange body of Diffie-Hellman parameters.
+func dhParams(p, g, public *big.Int) []byte {
+	var msg []byte
+	for _, v := range []*big.Int{p, g, public} {
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(v.Bytes())))
+		msg = append(msg, v.Bytes()...)
+	}
+	return msg
+}
+
+// serveAnonTLS performs the handshake of a TLS server with anonymous
+// Diffie-Hellman over c, choosing version and the cipher suite id.
+func serveAnonTLS(c net.Conn, version, id uint16) (*anonTLSConn, error) {
+	s := newAnonTLSConn(c, rand.Reader)
+	hello, err := s.readHandshake(tlsClientHello)
+	if err != nil {
+		return nil, err
+	}
+	if len(hello) < 34 {
+		return nil, fmt.Errorf("ClientHello of %d bytes is too short", len(hello))
+	}
+	clientRandom := hello[2:34]
+
+	serverRandom := make([]byte, 32)
+	rand.Read(serverRandom)
+	msg := binary.BigEndian.AppendUint16(nil, version)
+	msg = append(msg, serverRandom...)
+	msg = append(msg, 0) // session_id
+	msg = binary.BigEndian.AppendUint16(msg, id)
+	msg = append(msg, 0) // compression_method
+	if err := s.writeHandshake(tlsServerHello, msg); err != nil {
+		return nil, err
+	}
+	s.version, s.in.version, s.out.version = version, version, version
+	for i := range anonCipherSuites {
+		if anonCipherSuites[i].id == id {
+			s.suite = &anonCipherSuites[i]
+		}
+	}
+
+	y, err := rand.Int(rand.Reader, new(big.Int).Sub(oakley2, big.NewInt(3)))
+	if err != nil {
+		return nil, err
+	}
+	y.Add(y, big.NewInt(2))
+	g := big.NewInt(2)
+	if err := s.writeHandshake(tlsServerKeyExchange, dhParams(oakley2, g, new(big.Int).Exp(g, y, oakley2))); err != nil {
+		return nil, err
+	}
+	if err := s.writeHandshake(tlsServerHelloDone, nil); err != nil {
+		return nil, err
+	}
+
+	kx, err := s.readHandshake(tlsClientKeyExchange)
+	if err != nil {
+		return nil, err
+	}
+	if len(kx) < 2 {
+		return nil, fmt.Errorf("ClientKeyExchange of %d bytes is too short", len(kx))
+	}
+	clientPublic := new(big.Int).SetBytes(kx[2:])
+	s.establishKeys(new(big.Int).Exp(clientPublic, y, oakley2).Bytes(), clientRandom, serverRandom)
+
+	if err := s.readChangeCipherSpec(s.clientKeys); err != nil {
+		return nil, err
+	}
+	if err := s.readFinished("client finished"); err != nil {
+		return nil, err
+	}
+	if err := s.changeWriteCipher(s.serverKeys); err != nil {
+		return nil, err
+	}
+	if err := s.writeHandshake(tlsFinished, s.finishedData("server finished")); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func TestAnonTLSConn_Versions(t *testing.T) {
+	// More than one record of application data.
+	data := make([]byte, 3*tlsMaxPlaintext/2)
+	rand.Read(data)
+
+	for _, tt := range []struct {
+		version, suite uint16
+	}{
+		{tlsVersion12, 0x006d},
+		{tlsVersion12, 0x006c},
+		{tlsVersion12, 0x003a},
+		{tlsVersion11, 0x003a},
+		{tlsVersion11, 0x0034},
+		{tlsVersion10, 0x0034},
+	} {
+		desc := fmt.Sprintf("version %#04x suite %#04x", tt.version, tt.suite)
+		sc, cc := tcpConnPair(t)
+		served := make(chan error, 1)
+		go func() {
+			s, err := serveAnonTLS(sc, tt.version, tt.suite)
+			if err != nil {
+				served <- err
+				return
+			}
+			// Echo the data.
+			_, err = io.CopyN(s, s, int64(len(data)))
+			served <- err
+		}()
+
+		c := newAnonTLSConn(cc, rand.Reader)
+		if err := c.clientHandshake(); err != nil {
+			t.Errorf("%s: unexpected error: %s", desc, err)
+			sc.Close()
+			cc.Close()
+			<-served
+			continue
+		}
+		if _, err := c.Write(data); err != nil {
+			t.Errorf("%s: unexpected error: %s", desc, err)
+		}
+		got := make([]byte, len(data))
+		if _, err := io.ReadFull(c, got); err != nil {
+			t.Errorf("%s: unexpected error: %s", desc, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: incorrect data echoed", desc)
+		}
+		if err := <-served; err != nil {
+			t.Errorf("%s: unexpected server error: %s", desc, err)
+		}
+		c.Close()
+		sc.Close()
+	}
+}
+
+func TestReadDHParams(t *testing.T) {
+	g, public := big.NewInt(2), big.NewInt(12345)
+	pMinus1 := new(big.Int).Sub(oakley2, big.NewInt(1))
+	valid := dhParams(oakley2, g, public)
+
+	for _, tt := range []struct {
+		desc string
+		msg  []byte
+		ok   bool
+	}{
+		{"valid", valid, true},
+		{"truncated", valid[:len(valid)-1], false},
+		{"empty", nil, false},
+		{"small prime", dhParams(big.NewInt(23), g, big.NewInt(5)), false},
+		{"generator 1", dhParams(oakley2, big.NewInt(1), public), false},
+		{"public 1", dhParams(oakley2, g, big.NewInt(1)), false},
+		{"public p-1", dhParams(oakley2, g, pMinus1), false},
+	} {
+		_, _, _, err := readDHParams(tt.msg)
+		if got, want := err == nil, tt.ok; got != want {
+			t.Errorf("%s: incorrect result; got = %v, want success = %v", tt.desc, err, want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Implementation of the Apple Remote Desktop security type.
+
+Apple Remote Desktop (Diffie-Hellman) authentication is not part of RFC 6143,
+and is documented by the community maintained RFB protocol specification.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#diffie-hellman-authentication
+*/
+package vnc
+
+import (
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"github.com/kward/go-vnc/logging"
+)
+
+// ardCredentialSize is the size of the username, and of the password, in the
+// encrypted credentials. Each is NUL terminated.
+const ardCredentialSize = 64
+
+// ClientAuthARD is the Apple Remote Desktop authentication, which sends the
+// Username and Password of the ClientConfig encrypted with a key agreed on by
+// Diffie-Hellman key exchange. It is used by the Screen Sharing of macOS.
+type ClientAuthARD struct{}
+
+// Verify that interfaces are honored.
+var _ ClientAuth = (*ClientAuthARD)(nil)
+
+func (*ClientAuthARD) SecurityType() uint8 {
+	return secTypeARD
+}
+
+func (*ClientAuthARD) Handshake(conn *ClientConn) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientAuthARD.%s", logging.FnName())
+	}
+
+	if conn.config.Username == "" {
+		return NewVNCError("Security Handshake failed; no username provided for ARD")
+	}
+
+	// Read the Diffie-Hellman parameters, and public key of the server.
+	var params struct {
+		Generator uint16
+		KeyLength uint16
+	}
+	if err := conn.receive(&params); err != nil {
+		return err
+	}
+	if params.KeyLength == 0 {
+		return NewVNCError("Security Handshake failed; invalid ARD key length 0")
+	}
+	var modulus, serverKey []byte
+	if err := conn.receiveN(&modulus, int(params.KeyLength)); err != nil {
+		return err
+	}
+	if err := conn.receiveN(&serverKey, int(params.KeyLength)); err != nil {
+		return err
+	}
+
+	publicKey, secret, err := ardKeyExchange(big.NewInt(int64(params.Generator)), new(big.Int).SetBytes(modulus), new(big.Int).SetBytes(serverKey), int(params.KeyLength))
+	if err != nil {
+		return err
+	}
+	credentials, err := ardCredentials(conn.config.Username, conn.config.Password)
+	if err != nil {
+		return err
+	}
+	if err := ardEncrypt(secret, credentials); err != nil {
+		return err
+	}
+
+	buf := NewBuffer(nil)
+	if err := buf.Write(credentials); err != nil {
+		return err
+	}
+	if err := buf.Write(publicKey); err != nil {
+		return err
+	}
+	return conn.send(buf.Bytes())
+}
+
+// ardKeyExchange returns the public key of the client, and the secret shared
+// with the server, each of keyLength bytes.
+func ardKeyExchange(generator, modulus, serverKey *big.Int, keyLength int) (publicKey, secret []byte, err error) {
+	one := big.NewInt(1)
+	if modulus.Cmp(big.NewInt(3)) < 0 || modulus.BitLen() > keyLength*8 {
+		return nil, nil, NewVNCError("Security Handshake failed; invalid ARD modulus")
+	}
+	if serverKey.Cmp(one) <= 0 || serverKey.Cmp(new(big.Int).Sub(modulus, one)) >= 0 {
+		return nil, nil, NewVNCError("Security Handshake failed; invalid ARD server key")
+	}
+
+	// The private key is in [1, modulus-1).
+	private, err := rand.Int(rand.Reader, new(big.Int).Sub(modulus, big.NewInt(2)))
+	if err != nil {
+		return nil, nil, err
+	}
+	private.Add(private, one)
+
+	publicKey = new(big.Int).Exp(generator, private, modulus).FillBytes(make([]byte, keyLength))
+	secret = new(big.Int).Exp(serverKey, private, modulus).FillBytes(make([]byte, keyLength))
+	return publicKey, secret, nil
+}
+
+// ardCredentials returns the credentials block of the username and password.
+// Each is truncated to fit with its NUL terminator, and the rest of the block
+// is random.
+func ardCredentials(username, password string) ([]byte, error) {
+	b := make([]byte, 2*ardCredentialSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	for i, s := range []string{username, password} {
+		field := b[i*ardCredentialSize : (i+1)*ardCredentialSize]
+		n := copy(field[:ardCredentialSize-1], s)
+		field[n] = 0
+	}
+	return b, nil
+}
+
+// ardEncrypt encrypts the credentials in place with AES-128 in ECB mode, with
+// the MD5 digest of the shared secret as the key.
+func ardEncrypt(secret, credentials []byte) error {
+	key := md5.Sum(secret)
+	cipher, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+	if len(credentials)%cipher.BlockSize() != 0 {
+		return NewVNCError(fmt.Sprintf("invalid ARD credentials size %d", len(credentials)))
+	}
+	for i := 0; i < len(credentials); i += cipher.BlockSize() {
+		cipher.Encrypt(credentials[i:i+cipher.BlockSize()], credentials[i:i+cipher.BlockSize()])
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package vnc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+)
+
+// serveARD performs the server side of the ARD authentication over c, with a
+// modulus of keyLength bytes. It returns the credentials sent by the client.
+func serveARD(c net.Conn, keyLength int) (string, string, error) {
+	modulus, err := rand.Prime(rand.Reader, keyLength*8)
+	if err != nil {
+		return "", "", err
+	}
+	generator := big.NewInt(2)
+	private, err := rand.Int(rand.Reader, modulus)
+	if err != nil {
+		return "", "", err
+	}
+	public := new(big.Int).Exp(generator, private, modulus)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, [2]uint16{uint16(generator.Int64()), uint16(keyLength)})
+	buf.Write(modulus.FillBytes(make([]byte, keyLength)))
+	buf.Write(public.FillBytes(make([]byte, keyLength)))
+	if _, err := c.Write(buf.Bytes()); err != nil {
+		return "", "", err
+	}
+
+	credentials := make([]byte, 2*ardCredentialSize)
+	clientKey := make([]byte, keyLength)
+	if _, err := io.ReadFull(c, credentials); err != nil {
+		return "", "", err
+	}
+	if _, err := io.ReadFull(c, clientKey); err != nil {
+		return "", "", err
+	}
+	secret := new(big.Int).Exp(new(big.Int).SetBytes(clientKey), private, modulus).FillBytes(make([]byte, keyLength))
+	key := md5.Sum(secret)
+	cipher, err := aes.NewCipher(key[:])
+	if err != nil {
+		return "", "", err
+	}
+	for i := 0; i < len(credentials); i += cipher.BlockSize() {
+		cipher.Decrypt(credentials[i:i+cipher.BlockSize()], credentials[i:i+cipher.BlockSize()])
+	}
+	field := func(b []byte) string { return string(b[:bytes.IndexByte(b, 0)]) }
+	return field(credentials[:ardCredentialSize]), field(credentials[ardCredentialSize:]), nil
+}
+
+func TestClientAuthARD_Handshake(t *testing.T) {
+	long := string(bytes.Repeat([]byte("x"), 100))
+	for _, tt := range []struct {
+		desc               string
+		username, password string
+		keyLength          int
+		wantUser, wantPass string
+	}{
+		{"credentials", "admin", "secret", 128, "admin", "secret"},
+		{"short key", "admin", "secret", 16, "admin", "secret"},
+		{"empty password", "admin", "", 64, "admin", ""},
+		{"truncated", long, long, 64, long[:ardCredentialSize-1], long[:ardCredentialSize-1]},
+	} {
+		sc, cc := net.Pipe()
+		type result struct {
+			username, password string
+			err                error
+		}
+		served := make(chan result, 1)
+		go func() {
+			username, password, err := serveARD(sc, tt.keyLength)
+			served <- result{username, password, err}
+			sc.Close()
+		}()
+
+		conn := NewClientConn(cc, &ClientConfig{Username: tt.username, Password: tt.password})
+		if err := (&ClientAuthARD{}).Handshake(conn); err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+		}
+		res := <-served
+		cc.Close()
+		if res.err != nil {
+			t.Errorf("%s: unexpected server error: %s", tt.desc, res.err)
+			continue
+		}
+		if got, want := res.username, tt.wantUser; got != want {
+			t.Errorf("%s: incorrect username; got = %q, want = %q", tt.desc, got, want)
+		}
+		if got, want := res.password, tt.wantPass; got != want {
+			t.Errorf("%s: incorrect password; got = %q, want = %q", tt.desc, got, want)
+		}
+	}
+}
+
+func TestClientAuthARD_HandshakeErrors(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	if err := (&ClientAuthARD{}).Handshake(conn); err == nil {
+		t.Error("expected error without username")
+	}
+
+	conn.config.Username = "admin"
+	for _, tt := range []struct {
+		desc      string
+		modulus   int64
+		serverKey int64
+	}{
+		{"small modulus", 2, 1},
+		{"key zero", 23, 0},
+		{"key one", 23, 1},
+		{"key modulus-1", 23, 22},
+		{"key modulus", 23, 23},
+	} {
+		mockConn.Reset()
+		conn.send([2]uint16{5, 1})
+		conn.send([]uint8{uint8(tt.modulus), uint8(tt.serverKey)})
+		if err := (&ClientAuthARD{}).Handshake(conn); err == nil {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+	}
+}
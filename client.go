@@ -4,6 +4,7 @@ package vnc
 
 import (
 	"fmt"
+	"io"
 	"strings"
 	"unicode"
 
@@ -48,6 +49,27 @@ func (c *ClientConn) SetPixelFormat(pf PixelFormat) error {
 	return nil
 }
 
+// readSetPixelFormat reads a SetPixelFormat message, sans message-type. Color
+// map pixel formats are unsupported, and return false.
+func (s *ServerConn) readSetPixelFormat() (PixelFormat, bool, error) {
+	var msg struct {
+		_  [3]byte     // padding
+		PF PixelFormat // pixel-format
+	}
+	if err := s.receive(&msg); err != nil {
+		return PixelFormat{}, false, err
+	}
+	switch msg.PF.BPP {
+	case 8, 16, 32:
+	default:
+		return PixelFormat{}, false, fmt.Errorf("invalid bits-per-pixel %d", msg.PF.BPP)
+	}
+	if !rfbflags.IsTrueColor(msg.PF.TrueColor) {
+		return PixelFormat{}, false, nil
+	}
+	return msg.PF, true, nil
+}
+
 // SetEncodingsMessage holds the wire format message, sans encoding-type field.
 type SetEncodingsMessage struct {
 	Msg     messages.ClientMessage // message-type
@@ -107,6 +129,22 @@ func (c *ClientConn) SetEncodings(encs Encodings) error {
 	return nil
 }
 
+// readSetEncodings reads a SetEncodings message, sans message-type.
+func (s *ServerConn) readSetEncodings() ([]encodings.Encoding, error) {
+	var msg struct {
+		_       [1]byte // padding
+		NumEncs uint16  // number-of-encodings
+	}
+	if err := s.receive(&msg); err != nil {
+		return nil, err
+	}
+	encs := make([]encodings.Encoding, msg.NumEncs)
+	if err := s.receive(encs); err != nil {
+		return nil, err
+	}
+	return encs, nil
+}
+
 // FramebufferUpdateRequestMessage holds the wire format message.
 type FramebufferUpdateRequestMessage struct {
 	Msg           messages.ClientMessage // message-type
@@ -124,6 +162,20 @@ func (c *ClientConn) FramebufferUpdateRequest(inc rfbflags.RFBFlag, x, y, w, h u
 	return c.send(&msg)
 }
 
+// readFramebufferUpdateRequest reads a FramebufferUpdateRequest message, sans
+// message-type.
+func (s *ServerConn) readFramebufferUpdateRequest() (*FramebufferUpdateRequestMessage, error) {
+	var msg struct {
+		Inc           rfbflags.RFBFlag // incremental
+		X, Y          uint16           // x-, y-position
+		Width, Height uint16           // width, height
+	}
+	if err := s.receive(&msg); err != nil {
+		return nil, err
+	}
+	return &FramebufferUpdateRequestMessage{messages.FramebufferUpdateRequest, msg.Inc, msg.X, msg.Y, msg.Width, msg.Height}, nil
+}
+
 // KeyEventMessage holds the wire format message.
 type KeyEventMessage struct {
 	Msg      messages.ClientMessage // message-type
@@ -157,6 +209,19 @@ func (c *ClientConn) KeyEvent(key keys.Key, down bool) error {
 	return nil
 }
 
+// readKeyEvent reads a KeyEvent message, sans message-type.
+func (s *ServerConn) readKeyEvent() (*KeyEventMessage, error) {
+	var msg struct {
+		DownFlag rfbflags.RFBFlag // down-flag
+		_        [2]byte          // padding
+		Key      keys.Key         // key
+	}
+	if err := s.receive(&msg); err != nil {
+		return nil, err
+	}
+	return &KeyEventMessage{Msg: messages.KeyEvent, DownFlag: msg.DownFlag, Key: msg.Key}, nil
+}
+
 // PointerEventMessage holds the wire format message.
 type PointerEventMessage struct {
 	Msg  messages.ClientMessage // message-type
@@ -185,6 +250,18 @@ func (c *ClientConn) PointerEvent(button buttons.Button, x, y uint16) error {
 	return nil
 }
 
+// readPointerEvent reads a PointerEvent message, sans message-type.
+func (s *ServerConn) readPointerEvent() (*PointerEventMessage, error) {
+	var msg struct {
+		Mask uint8  // button-mask
+		X, Y uint16 // x-, y-position
+	}
+	if err := s.receive(&msg); err != nil {
+		return nil, err
+	}
+	return &PointerEventMessage{messages.PointerEvent, msg.Mask, msg.X, msg.Y}, nil
+}
+
 // ClientCutTextMessage holds the wire format message, sans the text field.
 type ClientCutTextMessage struct {
 	Msg    messages.ClientMessage // message-type
@@ -197,12 +274,23 @@ type ClientCutTextMessage struct {
 // is compatible with Go's native string format, but can only use up to
 // unicode.MaxLatin1 values.
 //
+// Once the server has sent its capabilities for the ExtendedClipboard
+// extension, the text may contain any character, and is sent as UTF-8.
+//
 // See RFC 6143 Section 7.5.6
 func (c *ClientConn) ClientCutText(text string) error {
 	if logging.V(logging.FnDeclLevel) {
 		logging.Infof("%s", logging.FnNameWithArgs("%s", text))
 	}
 
+	if caps := c.ClipboardCaps(); caps != nil {
+		if err := c.clientCutTextExtended(caps, text); err != nil {
+			return err
+		}
+		settleUI()
+		return nil
+	}
+
 	for _, char := range text {
 		if char > unicode.MaxLatin1 {
 			return NewVNCError(fmt.Sprintf("Character %q is not valid Latin-1", char))
@@ -214,17 +302,371 @@ func (c *ClientConn) ClientCutText(text string) error {
 	// alone. No carriage-return (0x0d) is used."
 	text = strings.Join(strings.Split(text, "\r"), "")
 
+	// Encode the text as Latin-1, one byte per character.
+	latin1 := make([]byte, 0, len(text))
+	for _, char := range text {
+		latin1 = append(latin1, byte(char))
+	}
+
+	buf := NewBuffer(nil)
 	msg := ClientCutTextMessage{
 		Msg:    messages.ClientCutText,
-		Length: uint32(len(text)),
+		Length: uint32(len(latin1)),
 	}
-	if err := c.send(msg); err != nil {
+	if err := buf.Write(msg); err != nil {
+		return err
+	}
+	if err := buf.Write(latin1); err != nil {
+		return err
+	}
+	if err := c.send(buf.Bytes()); err != nil {
+		return err
+	}
+
+	settleUI()
+	return nil
+}
+
+// readClientCutText reads a ClientCutText message, sans message-type, and
+// returns the Latin-1 text. Extended ClientCutText messages are discarded, and
+// return false.
+func (s *ServerConn) readClientCutText() (string, bool, error) {
+	var msg struct {
+		_      [3]byte // padding
+		Length int32   // length
+	}
+	if err := s.receive(&msg); err != nil {
+		return "", false, err
+	}
+	if msg.Length < 0 {
+		if _, err := io.CopyN(io.Discard, s.c, -int64(msg.Length)); err != nil {
+			return "", false, err
+		}
+		return "", false, nil
+	}
+
+	if msg.Length > clipboardMaxSize {
+		return "", false, fmt.Errorf("cut text of %d bytes is too long", msg.Length)
+	}
+
+	textBytes := make([]uint8, msg.Length)
+	if err := s.receive(textBytes); err != nil {
+		return "", false, err
+	}
+	text := make([]rune, len(textBytes))
+	for i, b := range textBytes {
+		text[i] = rune(b)
+	}
+	return string(text), true, nil
+}
+
+// SetDesktopSizeMessage holds the wire format message, sans the screens field.
+type SetDesktopSizeMessage struct {
+	Msg           messages.ClientMessage // message-type
+	_             [1]byte                // padding
+	Width, Height uint16                 // width, height
+	NumScreens    uint8                  // number-of-screens
+	_             [1]byte                // padding
+}
+
+// SetDesktopSize requests that the server change the size of the framebuffer,
+// and the layout of its screens. The server reports the result with an
+// ExtendedDesktopSizePseudoEncoding rectangle, which is returned by the
+// DesktopSizeResults method of the FramebufferUpdate carrying it. The Err
+// method of the result reports a rejected request. The request is only valid once the server has sent an
+// ExtendedDesktopSize rectangle.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#setdesktopsize
+func (c *ClientConn) SetDesktopSize(width, height uint16, screens []Screen) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("%s", logging.FnNameWithArgs("%d, %d, %v", width, height, screens))
+	}
+
+	if len(screens) == 0 || len(screens) > 255 {
+		return NewVNCError(fmt.Sprintf("Invalid number of screens %d", len(screens)))
+	}
+
+	buf := NewBuffer(nil)
+	msg := SetDesktopSizeMessage{
+		Msg:        messages.SetDesktopSize,
+		Width:      width,
+		Height:     height,
+		NumScreens: uint8(len(screens)),
+	}
+	if err := buf.Write(msg); err != nil {
+		return err
+	}
+	if err := buf.Write(screens); err != nil {
+		return err
+	}
+	return c.send(buf.Bytes())
+}
+
+// EnableContinuousUpdatesMessage holds the wire format message.
+type EnableContinuousUpdatesMessage struct {
+	Msg           messages.ClientMessage // message-type
+	Enable        rfbflags.RFBFlag       // enable-flag
+	X, Y          uint16                 // x-position, y-position
+	Width, Height uint16                 // width, height
+}
+
+// EnableContinuousUpdates enables or disables continuous updates of an area
+// of the framebuffer. While enabled, the server sends a FramebufferUpdate
+// whenever the area changes, without waiting for a FramebufferUpdateRequest.
+// When disabled, the server replies with an EndOfContinuousUpdates message.
+// The request is only valid once the server has sent an EndOfContinuousUpdates
+// message, in response to ContinuousUpdatesPseudoEncoding.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#enablecontinuousupdates
+func (c *ClientConn) EnableContinuousUpdates(enable rfbflags.RFBFlag, x, y, w, h uint16) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("%s", logging.FnNameWithArgs("%v, %d, %d, %d, %d", enable, x, y, w, h))
+	}
+	msg := EnableContinuousUpdatesMessage{messages.EnableContinuousUpdates, enable, x, y, w, h}
+	return c.send(&msg)
+}
+
+// FenceFlags describe how a fence synchronizes the message streams.
+type FenceFlags uint32
+
+// FenceFlags values.
+const (
+	// FenceBlockBefore requires that all messages preceding the fence are
+	// processed before the fence is handled.
+	FenceBlockBefore FenceFlags = 1 << 0
+	// FenceBlockAfter requires that no messages following the fence are
+	// processed until the fence response has been sent.
+	FenceBlockAfter FenceFlags = 1 << 1
+	// FenceSyncNext requires that the fence response is delayed until the
+	// message following the fence has been processed.
+	FenceSyncNext FenceFlags = 1 << 2
+	// FenceRequest marks a fence as a request, which must be answered with a
+	// fence without this flag set.
+	FenceRequest FenceFlags = 1 << 31
+)
+
+// fenceMaxPayload is the maximum length of a fence payload.
+const fenceMaxPayload = 64
+
+// ClientFenceMessage holds the wire format message, sans the payload field.
+type ClientFenceMessage struct {
+	Msg    messages.ClientMessage // message-type
+	_      [3]byte                // padding
+	Flags  FenceFlags             // flags
+	Length uint8                  // length
+}
+
+// Fence sends a fence to the server. A fence with FenceRequest set is answered
+// with a ServerFence message carrying the same payload, once the server has
+// handled the fence as requested by the other flags. Sending a request with
+// FenceBlockBefore after input events therefore signals when the server has
+// processed those events. The request is only valid once the server has sent
+// a ServerFence message, in response to FencePseudoEncoding.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#clientfence
+func (c *ClientConn) Fence(flags FenceFlags, payload []byte) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("%s", logging.FnNameWithArgs("%#x, %v", flags, payload))
+	}
+
+	if len(payload) > fenceMaxPayload {
+		return NewVNCError(fmt.Sprintf("Fence payload of %d bytes is too long", len(payload)))
+	}
+
+	buf := NewBuffer(nil)
+	msg := ClientFenceMessage{
+		Msg:    messages.ClientFence,
+		Flags:  flags,
+		Length: uint8(len(payload)),
+	}
+	if err := buf.Write(msg); err != nil {
 		return err
 	}
-	if err := c.send([]byte(text)); err != nil {
+	if err := buf.Write(payload); err != nil {
+		return err
+	}
+	return c.send(buf.Bytes())
+}
+
+// xvpVersion is the version of the XVP extension implemented by ClientConn.
+const xvpVersion uint8 = 1
+
+// XVPCode is the message-code of an XVP message.
+type XVPCode uint8
+
+// XVPCode values. XVPFail and XVPInit are sent by the server, and the others
+// by the client.
+const (
+	XVPFail     XVPCode = iota // The last request failed.
+	XVPInit                    // The server supports XVP.
+	XVPShutdown                // Shut down the machine.
+	XVPReboot                  // Reboot the machine.
+	XVPReset                   // Reset the machine.
+)
+
+// String implements the fmt.Stringer interface.
+func (c XVPCode) String() string {
+	switch c {
+	case XVPFail:
+		return "fail"
+	case XVPInit:
+		return "init"
+	case XVPShutdown:
+		return "shutdown"
+	case XVPReboot:
+		return "reboot"
+	case XVPReset:
+		return "reset"
+	}
+	return fmt.Sprintf("unknown code %d", uint8(c))
+}
+
+// XVPMessage holds the wire format message.
+type XVPMessage struct {
+	Msg     messages.ClientMessage // message-type
+	_       [1]byte                // padding
+	Version uint8                  // xvp-extension-version
+	Code    XVPCode                // xvp-message-code
+}
+
+// XVP asks the server to shut down, reboot or reset the machine behind the
+// desktop. A failed request is answered with a ServerXVP message with the
+// XVPFail code. The request is only valid once the server has sent a ServerXVP
+// message with the XVPInit code, in response to XVPPseudoEncoding.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#xvp-client-message
+func (c *ClientConn) XVP(action XVPCode) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientConn.%s", logging.FnNameWithArgs("%s", action))
+	}
+
+	switch action {
+	case XVPShutdown, XVPReboot, XVPReset:
+	default:
+		return NewVNCError(fmt.Sprintf("Invalid XVP action %s", action))
+	}
+	return c.send(XVPMessage{Msg: messages.ClientXVP, Version: xvpVersion, Code: action})
+}
+
+// QEMU client message sub-types.
+const (
+	qemuExtendedKeyEvent uint8 = 0
+	qemuAudio            uint8 = 1
+)
+
+// QEMUExtendedKeyEventMessage holds the wire format message.
+type QEMUExtendedKeyEventMessage struct {
+	Msg      messages.ClientMessage // message-type
+	SubType  uint8                  // submessage-type
+	DownFlag uint16                 // down-flag
+	Key      keys.Key               // keysym
+	Keycode  uint32                 // keycode
+}
+
+// ExtendedKeyEvent indicates a key press or release, identified by both its
+// keysym and the XT scan code of the physical key, as returned by
+// keys.XTScancode. Servers use the scan code where the keysym alone is
+// ambiguous, for example for guests without a keyboard layout. The event is
+// only valid once the server has acknowledged
+// QEMUExtendedKeyEventPseudoEncoding.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#qemu-extended-key-event-message
+func (c *ClientConn) ExtendedKeyEvent(keysym keys.Key, scancode uint32, down bool) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientConn.%s", logging.FnNameWithArgs("%s, %#x, %t", keysym, scancode, down))
+	}
+
+	// Extended scan codes (0xE0 prefixed) are sent with the high bit of the
+	// low byte set.
+	keycode := scancode
+	if scancode>>8 == 0xe0 {
+		keycode = 0x80 | scancode&0x7f
+	}
+
+	msg := QEMUExtendedKeyEventMessage{
+		Msg:      messages.ClientQEMU,
+		SubType:  qemuExtendedKeyEvent,
+		DownFlag: uint16(rfbflags.BoolToRFBFlag(down)),
+		Key:      keysym,
+		Keycode:  keycode,
+	}
+	if err := c.send(msg); err != nil {
 		return err
 	}
 
 	settleUI()
 	return nil
 }
+
+// QEMU audio client operations.
+const (
+	qemuAudioEnable    uint16 = 0
+	qemuAudioDisable   uint16 = 1
+	qemuAudioSetFormat uint16 = 2
+)
+
+// QEMUAudioSampleFormat is the format of a single audio sample.
+type QEMUAudioSampleFormat uint8
+
+// QEMUAudioSampleFormat values.
+const (
+	QEMUAudioU8 QEMUAudioSampleFormat = iota
+	QEMUAudioS8
+	QEMUAudioU16
+	QEMUAudioS16
+	QEMUAudioU32
+	QEMUAudioS32
+)
+
+// QEMUAudioMessage holds the wire format message, for the enable and disable
+// operations.
+type QEMUAudioMessage struct {
+	Msg       messages.ClientMessage // message-type
+	SubType   uint8                  // submessage-type
+	Operation uint16                 // operation
+}
+
+// QEMUAudioSetFormatMessage holds the wire format message, for the set format
+// operation.
+type QEMUAudioSetFormatMessage struct {
+	QEMUAudioMessage
+	SampleFormat QEMUAudioSampleFormat // sample-format
+	Channels     uint8                 // number-of-channels
+	Frequency    uint32                // frequency
+}
+
+// EnableQEMUAudio starts or stops the audio stream of the server. Once
+// started, the server sends QEMUAudio messages. The format should be set with
+// SetQEMUAudioFormat before the stream is started. The request is only valid
+// once the server has acknowledged QEMUAudioPseudoEncoding.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#qemu-audio-client-message
+func (c *ClientConn) EnableQEMUAudio(enable bool) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientConn.%s", logging.FnNameWithArgs("%t", enable))
+	}
+	op := qemuAudioDisable
+	if enable {
+		op = qemuAudioEnable
+	}
+	return c.send(QEMUAudioMessage{messages.ClientQEMU, qemuAudio, op})
+}
+
+// SetQEMUAudioFormat sets the format of the audio stream of the server.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#qemu-audio-client-message
+func (c *ClientConn) SetQEMUAudioFormat(format QEMUAudioSampleFormat, channels uint8, frequency uint32) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientConn.%s", logging.FnNameWithArgs("%d, %d, %d", format, channels, frequency))
+	}
+	if format > QEMUAudioS32 {
+		return NewVNCError(fmt.Sprintf("Invalid audio sample format %d", format))
+	}
+	msg := QEMUAudioSetFormatMessage{
+		QEMUAudioMessage: QEMUAudioMessage{messages.ClientQEMU, qemuAudio, qemuAudioSetFormat},
+		SampleFormat:     format,
+		Channels:         channels,
+		Frequency:        frequency,
+	}
+	return c.send(msg)
+}
@@ -327,6 +327,7 @@ func TestClientCutText(t *testing.T) {
 		{"abc123", []byte("abc123"), true},
 		{"foo\r\nbar", []byte("foo\nbar"), true},
 		{"", []byte{}, true},
+		{"café", []byte{'c', 'a', 'f', 0xe9}, true},
 		{"ɹɐqooɟ", []byte{}, false},
 	}
 
@@ -375,3 +376,206 @@ func TestClientCutText(t *testing.T) {
 		}
 	}
 }
+
+func TestSetDesktopSize(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	screens := []Screen{
+		{ID: 1, X: 0, Y: 0, Width: 1024, Height: 768},
+		{ID: 2, X: 1024, Y: 0, Width: 800, Height: 600, Flags: 0},
+	}
+	if err := conn.SetDesktopSize(1824, 768, screens); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := mockConn.writes, 1; got != want {
+		t.Errorf("incorrect number of writes; got = %d, want = %d", got, want)
+	}
+
+	var req SetDesktopSizeMessage
+	if err := conn.receive(&req); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.Msg, messages.SetDesktopSize; got != want {
+		t.Errorf("incorrect message-type; got = %v, want = %v", got, want)
+	}
+	if got, want := [2]uint16{req.Width, req.Height}, [2]uint16{1824, 768}; got != want {
+		t.Errorf("incorrect size; got = %v, want = %v", got, want)
+	}
+	got := make([]Screen, req.NumScreens)
+	if err := conn.receive(got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, screens) {
+		t.Errorf("incorrect screens; got = %v, want = %v", got, screens)
+	}
+	if got, want := mockConn.b.Len(), 0; got != want {
+		t.Errorf("%d bytes unread", got)
+	}
+
+	if err := conn.SetDesktopSize(100, 100, nil); err == nil {
+		t.Error("expected error without screens")
+	}
+}
+
+func TestEnableContinuousUpdates(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	if err := conn.EnableContinuousUpdates(rfbflags.RFBTrue, 1, 2, 300, 400); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var req EnableContinuousUpdatesMessage
+	if err := conn.receive(&req); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req, (EnableContinuousUpdatesMessage{messages.EnableContinuousUpdates, rfbflags.RFBTrue, 1, 2, 300, 400}); got != want {
+		t.Errorf("incorrect message; got = %v, want = %v", got, want)
+	}
+	if got, want := mockConn.b.Len(), 0; got != want {
+		t.Errorf("%d bytes unread", got)
+	}
+}
+
+func TestFence(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	if err := conn.Fence(FenceRequest|FenceBlockBefore, []byte("sync")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// The message is written at once, so that it can not interleave with
+	// other messages.
+	if got, want := mockConn.writes, 1; got != want {
+		t.Errorf("incorrect number of writes; got = %d, want = %d", got, want)
+	}
+	var req ClientFenceMessage
+	if err := conn.receive(&req); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.Msg, messages.ClientFence; got != want {
+		t.Errorf("incorrect message-type; got = %v, want = %v", got, want)
+	}
+	if got, want := req.Flags, FenceRequest|FenceBlockBefore; got != want {
+		t.Errorf("incorrect flags; got = %#x, want = %#x", got, want)
+	}
+	payload := make([]byte, req.Length)
+	if err := conn.receive(payload); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(payload), "sync"; got != want {
+		t.Errorf("incorrect payload; got = %q, want = %q", got, want)
+	}
+
+	if err := conn.Fence(FenceRequest, make([]byte, 65)); err == nil {
+		t.Error("expected error for a payload that is too long")
+	}
+}
+
+func TestXVP(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		action XVPCode
+		ok     bool
+	}{
+		{XVPShutdown, true},
+		{XVPReboot, true},
+		{XVPReset, true},
+		{XVPInit, false},
+		{XVPReset + 1, false},
+	} {
+		mockConn.Reset()
+		err := conn.XVP(tt.action)
+		if err == nil && !tt.ok {
+			t.Errorf("XVP(%s): expected error", tt.action)
+		}
+		if err != nil {
+			if tt.ok {
+				t.Errorf("XVP(%s): unexpected error: %s", tt.action, err)
+			}
+			continue
+		}
+		var req XVPMessage
+		if err := conn.receive(&req); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := req, (XVPMessage{Msg: messages.ClientXVP, Version: 1, Code: tt.action}); got != want {
+			t.Errorf("XVP(%s): incorrect message; got = %v, want = %v", tt.action, got, want)
+		}
+	}
+}
+
+func TestQEMUAudio(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		enable bool
+		op     uint16
+	}{
+		{true, qemuAudioEnable},
+		{false, qemuAudioDisable},
+	} {
+		if err := conn.EnableQEMUAudio(tt.enable); err != nil {
+			t.Fatalf("EnableQEMUAudio(%t): unexpected error: %s", tt.enable, err)
+		}
+		var req QEMUAudioMessage
+		if err := conn.receive(&req); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := req, (QEMUAudioMessage{messages.ClientQEMU, qemuAudio, tt.op}); got != want {
+			t.Errorf("EnableQEMUAudio(%t): incorrect message; got = %v, want = %v", tt.enable, got, want)
+		}
+	}
+
+	if err := conn.SetQEMUAudioFormat(QEMUAudioS16, 2, 44100); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var req QEMUAudioSetFormatMessage
+	if err := conn.receive(&req); err != nil {
+		t.Fatal(err)
+	}
+	want := QEMUAudioSetFormatMessage{QEMUAudioMessage{messages.ClientQEMU, qemuAudio, qemuAudioSetFormat}, QEMUAudioS16, 2, 44100}
+	if got := req; got != want {
+		t.Errorf("incorrect message; got = %v, want = %v", got, want)
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes unread", mockConn.b.Len())
+	}
+
+	if err := conn.SetQEMUAudioFormat(QEMUAudioS32+1, 2, 44100); err == nil {
+		t.Error("expected error for an invalid sample format")
+	}
+}
+
+func TestExtendedKeyEvent(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	SetSettle(0) // Disable UI settling for tests.
+	for _, tt := range []struct {
+		key      keys.Key
+		scancode uint32
+		down     bool
+		keycode  uint32
+	}{
+		{keys.SmallA, 0x1e, true, 0x1e},
+		{keys.Up, 0xe048, false, 0xc8},
+		{keys.KeypadEnter, 0xe01c, true, 0x9c},
+	} {
+		mockConn.Reset()
+		if err := conn.ExtendedKeyEvent(tt.key, tt.scancode, tt.down); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var req QEMUExtendedKeyEventMessage
+		if err := conn.receive(&req); err != nil {
+			t.Fatal(err)
+		}
+		want := QEMUExtendedKeyEventMessage{messages.ClientQEMU, 0, uint16(rfbflags.BoolToRFBFlag(tt.down)), tt.key, tt.keycode}
+		if req != want {
+			t.Errorf("incorrect message; got = %v, want = %v", req, want)
+		}
+	}
+}
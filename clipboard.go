@@ -0,0 +1,373 @@
+/*
+Implementation of the Extended Clipboard pseudo-encoding.
+
+The Extended Clipboard pseudo-encoding is not part of RFC 6143, and is
+documented by the community maintained RFB protocol specification.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#extended-clipboard-pseudo-encoding
+
+A client that includes ExtendedClipboardPseudoEncoding in SetEncodings declares
+that it understands extended ClientCutText and ServerCutText messages. These
+reuse the message-types of the originals, but have a negative length, and carry
+UTF-8 text and other formats in place of Latin-1 text. A server that supports
+the extension announces it by sending its capabilities in an extended
+ServerCutText message, to which the client replies with its own.
+*/
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/logging"
+	"github.com/kward/go-vnc/messages"
+)
+
+// ClipboardFlags holds the formats and action of an extended clipboard
+// message. The low 16 bits are formats, and the high 8 bits are actions.
+type ClipboardFlags uint32
+
+// Clipboard formats.
+const (
+	ClipboardText  ClipboardFlags = 1 << iota // UTF-8 text.
+	ClipboardRTF                              // Microsoft Rich Text Format.
+	ClipboardHTML                             // Microsoft HTML clipboard fragment.
+	ClipboardDIB                              // Microsoft Device Independent Bitmap.
+	ClipboardFiles                            // Files; reserved.
+)
+
+// Clipboard actions.
+const (
+	ClipboardCaps    ClipboardFlags = 1 << (iota + 24) // Supported formats and actions.
+	ClipboardRequest                                   // Request data of formats.
+	ClipboardPeek                                      // Request formats that are available.
+	ClipboardNotify                                    // Formats that are available.
+	ClipboardProvide                                   // Data of formats.
+)
+
+const (
+	clipboardFormatMask ClipboardFlags = 0xffff
+	clipboardActionMask ClipboardFlags = 0xff << 24
+
+	// clipboardFormats are the formats supported by ClientConn.
+	clipboardFormats = ClipboardText | ClipboardRTF | ClipboardHTML
+	// clipboardActions are the actions supported by ClientConn.
+	clipboardActions = ClipboardCaps | ClipboardRequest | ClipboardPeek | ClipboardNotify | ClipboardProvide
+	// clipboardMaxSize is the largest size of a format accepted by ClientConn.
+	clipboardMaxSize = 20 << 20
+)
+
+// Formats returns the formats of the flags.
+func (f ClipboardFlags) Formats() ClipboardFlags { return f & clipboardFormatMask }
+
+// Action returns the action of the flags. The flags of a ClipboardCaps action
+// also hold the supported actions, which are left out.
+func (f ClipboardFlags) Action() ClipboardFlags {
+	if f&ClipboardCaps != 0 {
+		return ClipboardCaps
+	}
+	return f & clipboardActionMask
+}
+
+// formats returns each format of the flags, in wire order.
+func (f ClipboardFlags) formats() []ClipboardFlags {
+	var formats []ClipboardFlags
+	for format := ClipboardFlags(1); format&clipboardFormatMask != 0; format <<= 1 {
+		if f&format != 0 {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+//-----------------------------------------------------------------------------
+// Extended Clipboard Pseudo-Encoding
+
+// ExtendedClipboardPseudoEncoding declares that the client supports extended
+// ClientCutText and ServerCutText messages.
+type ExtendedClipboardPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ExtendedClipboardPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*ExtendedClipboardPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (e *ExtendedClipboardPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	return nil, errRequestOnlyPseudoEncoding(e.Type())
+}
+
+// String implements the fmt.Stringer interface.
+func (*ExtendedClipboardPseudoEncoding) String() string { return "ExtendedClipboardPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*ExtendedClipboardPseudoEncoding) Type() encodings.Encoding {
+	return encodings.ExtendedClipboardPseudo
+}
+
+//-----------------------------------------------------------------------------
+// ExtendedClipboard is sent by the server as an extended ServerCutText, once
+// the extension is enabled.
+
+// ExtendedClipboard represents the wire format message, sans message-type,
+// padding and length. It is returned in the Extended field of ServerCutText.
+type ExtendedClipboard struct {
+	Flags ClipboardFlags
+	// MaxSizes holds the largest size accepted for each format, for the
+	// ClipboardCaps action.
+	MaxSizes map[ClipboardFlags]uint32
+	// Data holds the data of each format, for the ClipboardProvide action.
+	Data map[ClipboardFlags][]byte
+}
+
+// Text returns the text of the ClipboardText format, with newline (\n) line
+// endings.
+func (m *ExtendedClipboard) Text() string {
+	text, _, _ := strings.Cut(string(m.Data[ClipboardText]), "\x00")
+	return strings.ReplaceAll(text, "\r\n", "\n")
+}
+
+// readExtendedClipboard reads the payload of an extended ServerCutText
+// message.
+func (c *ClientConn) readExtendedClipboard(length uint32) (*ExtendedClipboard, error) {
+	var flags ClipboardFlags
+	if length < uint32(binary.Size(flags)) {
+		return nil, fmt.Errorf("extended clipboard message of %d bytes is too short", length)
+	}
+	if err := c.receive(&flags); err != nil {
+		return nil, err
+	}
+	// The compressed data of the formats is bounded as their data is.
+	if length > clipboardMaxSize {
+		return nil, fmt.Errorf("extended clipboard message of %d bytes is too long", length)
+	}
+	payload := make([]uint8, length-uint32(binary.Size(flags)))
+	if err := c.receive(payload); err != nil {
+		return nil, err
+	}
+
+	msg := &ExtendedClipboard{Flags: flags}
+	switch flags.Action() {
+	case ClipboardCaps:
+		msg.MaxSizes = map[ClipboardFlags]uint32{}
+		buf := NewBuffer(payload)
+		for _, format := range flags.formats() {
+			var size uint32
+			if err := buf.Read(&size); err != nil {
+				return nil, fmt.Errorf("unable to read clipboard caps: %s", err)
+			}
+			msg.MaxSizes[format] = size
+		}
+	case ClipboardProvide:
+		data, err := unmarshalClipboardData(flags, payload)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read clipboard data: %s", err)
+		}
+		msg.Data = data
+	}
+	return msg, nil
+}
+
+// handleExtendedClipboard replies to the actions of the server that need no
+// involvement of the caller.
+func (c *ClientConn) handleExtendedClipboard(msg *ExtendedClipboard) error {
+	switch msg.Flags.Action() {
+	case ClipboardCaps:
+		c.setClipboardCaps(msg)
+		sizes := []uint32{}
+		for range clipboardFormats.formats() {
+			sizes = append(sizes, clipboardMaxSize)
+		}
+		return c.sendExtendedClipboard(clipboardActions|clipboardFormats, sizes)
+	case ClipboardRequest:
+		data := map[ClipboardFlags][]byte{}
+		c.mu.Lock()
+		for _, format := range msg.Flags.formats() {
+			if d, ok := c.clipboard[format]; ok {
+				data[format] = d
+			}
+		}
+		c.mu.Unlock()
+		return c.ClipboardProvide(data)
+	case ClipboardPeek:
+		var formats ClipboardFlags
+		c.mu.Lock()
+		for format := range c.clipboard {
+			formats |= format
+		}
+		c.mu.Unlock()
+		return c.ClipboardNotify(formats)
+	}
+	return nil
+}
+
+// ClipboardCaps returns the server provided clipboard capabilities, or nil if
+// the server has not sent them. The capabilities are only sent when
+// ExtendedClipboardPseudoEncoding is enabled with SetEncodings.
+func (c *ClientConn) ClipboardCaps() *ExtendedClipboard {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clipboardCaps
+}
+
+// setClipboardCaps stores the server provided clipboard capabilities.
+func (c *ClientConn) setClipboardCaps(caps *ExtendedClipboard) {
+	if logging.V(logging.ResultLevel) {
+		logging.Infof("clipboardCaps: %#x %v", caps.Flags, caps.MaxSizes)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clipboardCaps = caps
+}
+
+// ClipboardRequest asks the server for the data of its clipboard in the given
+// formats. The server replies with a ClipboardProvide action.
+func (c *ClientConn) ClipboardRequest(formats ClipboardFlags) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientConn.%s", logging.FnNameWithArgs("%#x", formats))
+	}
+	return c.sendExtendedClipboard(ClipboardRequest|formats.Formats(), nil)
+}
+
+// ClipboardPeek asks the server which formats its clipboard holds. The server
+// replies with a ClipboardNotify action.
+func (c *ClientConn) ClipboardPeek() error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientConn.%s", logging.FnName())
+	}
+	return c.sendExtendedClipboard(ClipboardPeek, nil)
+}
+
+// ClipboardNotify tells the server which formats the clipboard of the client
+// holds.
+func (c *ClientConn) ClipboardNotify(formats ClipboardFlags) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientConn.%s", logging.FnNameWithArgs("%#x", formats))
+	}
+	return c.sendExtendedClipboard(ClipboardNotify|formats.Formats(), nil)
+}
+
+// ClipboardProvide sends the data of the clipboard of the client, by format,
+// to the server. Text must be encoded with ClipboardTextData.
+func (c *ClientConn) ClipboardProvide(data map[ClipboardFlags][]byte) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientConn.%s", logging.FnNameWithArgs("%d formats", len(data)))
+	}
+	formats, payload, err := marshalClipboardData(data)
+	if err != nil {
+		return err
+	}
+	return c.sendExtendedClipboard(ClipboardProvide|formats, payload)
+}
+
+// clientCutTextExtended makes text the clipboard of the client, and tells the
+// server about it.
+func (c *ClientConn) clientCutTextExtended(caps *ExtendedClipboard, text string) error {
+	clipboard := map[ClipboardFlags][]byte{ClipboardText: ClipboardTextData(text)}
+	c.mu.Lock()
+	c.clipboard = clipboard
+	c.mu.Unlock()
+	if caps.Flags&ClipboardNotify != 0 {
+		return c.ClipboardNotify(ClipboardText)
+	}
+	return c.ClipboardProvide(clipboard)
+}
+
+// sendExtendedClipboard sends an extended ClientCutText message. The payload
+// is either a []uint32 or a []byte.
+func (c *ClientConn) sendExtendedClipboard(flags ClipboardFlags, payload interface{}) error {
+	length := binary.Size(flags)
+	if payload != nil {
+		length += binary.Size(payload)
+	}
+	if length > math.MaxInt32 {
+		return NewVNCError(fmt.Sprintf("Extended clipboard message of %d bytes is too long", length))
+	}
+	buf := NewBuffer(nil)
+	msg := ClientCutTextMessage{
+		Msg:    messages.ClientCutText,
+		Length: uint32(-int32(length)),
+	}
+	if err := buf.Write(msg); err != nil {
+		return err
+	}
+	if err := buf.Write(flags); err != nil {
+		return err
+	}
+	if payload != nil {
+		if err := buf.Write(payload); err != nil {
+			return err
+		}
+	}
+	return c.send(buf.Bytes())
+}
+
+// ClipboardTextData returns text as ClipboardText data, which is UTF-8 with
+// carriage-return and newline (\r\n) line endings, and a terminating null.
+func ClipboardTextData(text string) []byte {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\n", "\r\n")
+	return append([]byte(text), 0)
+}
+
+// marshalClipboardData returns the formats of data, and the compressed data of
+// a ClipboardProvide action.
+func marshalClipboardData(data map[ClipboardFlags][]byte) (ClipboardFlags, []byte, error) {
+	var formats ClipboardFlags
+	for format := range data {
+		if format.Formats() != format || len(format.formats()) != 1 {
+			return 0, nil, NewVNCError(fmt.Sprintf("Invalid clipboard format %#x", uint32(format)))
+		}
+		formats |= format
+	}
+
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	for _, format := range formats.formats() {
+		if err := binary.Write(zw, binary.BigEndian, uint32(len(data[format]))); err != nil {
+			return 0, nil, err
+		}
+		if _, err := zw.Write(data[format]); err != nil {
+			return 0, nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return 0, nil, err
+	}
+	return formats, b.Bytes(), nil
+}
+
+// unmarshalClipboardData returns the data of each format of a
+// ClipboardProvide action.
+func unmarshalClipboardData(flags ClipboardFlags, payload []byte) (map[ClipboardFlags][]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	data := map[ClipboardFlags][]byte{}
+	for _, format := range flags.formats() {
+		var size uint32
+		if err := binary.Read(zr, binary.BigEndian, &size); err != nil {
+			return nil, err
+		}
+		if size > clipboardMaxSize {
+			return nil, fmt.Errorf("clipboard data of %d bytes is too large", size)
+		}
+		d := make([]byte, size)
+		if _, err := io.ReadFull(zr, d); err != nil {
+			return nil, err
+		}
+		data[format] = d
+	}
+	return data, nil
+}
@@ -0,0 +1,300 @@
+package vnc
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/messages"
+)
+
+// extendedServerCutText returns the wire format of an extended ServerCutText
+// message, sans message-type.
+func extendedServerCutText(flags ClipboardFlags, payload []byte) []byte {
+	data := []byte{0, 0, 0}
+	data = binary.BigEndian.AppendUint32(data, uint32(-int32(4+len(payload))))
+	data = binary.BigEndian.AppendUint32(data, uint32(flags))
+	return append(data, payload...)
+}
+
+// receiveExtendedClientCutText reads an extended ClientCutText message, and
+// returns its flags and payload.
+func receiveExtendedClientCutText(t *testing.T, conn *ClientConn) (ClipboardFlags, []byte) {
+	t.Helper()
+	var msg ClientCutTextMessage
+	if err := conn.receive(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := msg.Msg, messages.ClientCutText; got != want {
+		t.Errorf("incorrect message-type; got = %v, want = %v", got, want)
+	}
+	length := -int32(msg.Length)
+	if length < 4 {
+		t.Fatalf("incorrect length %d", length)
+	}
+	var flags ClipboardFlags
+	if err := conn.receive(&flags); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, length-4)
+	if err := conn.receive(payload); err != nil {
+		t.Fatal(err)
+	}
+	return flags, payload
+}
+
+func TestExtendedClipboardPseudoEncoding_Type(t *testing.T) {
+	if got, want := (&ExtendedClipboardPseudoEncoding{}).Type(), encodings.ExtendedClipboardPseudo; got != want {
+		t.Errorf("incorrect encoding; got = %v, want = %v", got, want)
+	}
+	e := encodings.ExtendedClipboardPseudo
+	if got, want := uint32(e), uint32(0xc0a1e5ce); got != want {
+		t.Errorf("incorrect encoding value; got = %#x, want = %#x", got, want)
+	}
+}
+
+func TestClipboardData(t *testing.T) {
+	data := map[ClipboardFlags][]byte{
+		ClipboardText: ClipboardTextData("grüße\n😀"),
+		ClipboardHTML: []byte("<b>hi</b>"),
+	}
+	formats, payload, err := marshalClipboardData(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := formats, ClipboardText|ClipboardHTML; got != want {
+		t.Errorf("incorrect formats; got = %#x, want = %#x", got, want)
+	}
+	got, err := unmarshalClipboardData(formats, payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(got, data) {
+		t.Errorf("data did not round-trip; got = %q, want = %q", got, data)
+	}
+	if got, want := string(data[ClipboardText]), "grüße\r\n😀\x00"; got != want {
+		t.Errorf("incorrect text data; got = %q, want = %q", got, want)
+	}
+
+	if _, _, err := marshalClipboardData(map[ClipboardFlags][]byte{ClipboardText | ClipboardRTF: nil}); err == nil {
+		t.Error("expected error for multiple formats")
+	}
+}
+
+func TestExtendedClipboard_Caps(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	caps := ClipboardCaps | ClipboardRequest | ClipboardNotify | ClipboardProvide | ClipboardText | ClipboardHTML
+	if err := conn.send(extendedServerCutText(caps, []byte{0, 0, 0x10, 0, 0, 0, 0, 0x20})); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := (&ServerCutText{}).Read(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	m := msg.(*ServerCutText).Extended
+	if m == nil {
+		t.Fatal("expected extended message")
+	}
+	if got, want := m.MaxSizes, map[ClipboardFlags]uint32{ClipboardText: 0x1000, ClipboardHTML: 0x20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect max sizes; got = %v, want = %v", got, want)
+	}
+	if got, want := conn.ClipboardCaps(), m; got != want {
+		t.Errorf("incorrect caps; got = %v, want = %v", got, want)
+	}
+
+	// The client replies with its own caps.
+	flags, payload := receiveExtendedClientCutText(t, conn)
+	if got, want := flags, clipboardActions|clipboardFormats; got != want {
+		t.Errorf("incorrect reply flags; got = %#x, want = %#x", got, want)
+	}
+	if got, want := len(payload), 4*len(clipboardFormats.formats()); got != want {
+		t.Errorf("incorrect reply length; got = %d, want = %d", got, want)
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes unread", mockConn.b.Len())
+	}
+}
+
+func TestExtendedClipboard_Provide(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	formats, payload, err := marshalClipboardData(map[ClipboardFlags][]byte{ClipboardText: ClipboardTextData("grüße\n😀")})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := conn.send(extendedServerCutText(ClipboardProvide|formats, payload)); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := (&ServerCutText{}).Read(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := msg.(*ServerCutText).Extended.Text(), "grüße\n😀"; got != want {
+		t.Errorf("incorrect text; got = %q, want = %q", got, want)
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes unread", mockConn.b.Len())
+	}
+}
+
+func TestClientCutText_Extended(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.clipboardCaps = &ExtendedClipboard{Flags: ClipboardCaps | ClipboardRequest | ClipboardNotify | ClipboardProvide | ClipboardText}
+
+	SetSettle(0) // Disable UI settling for tests.
+	if err := conn.ClientCutText("ɹɐqooɟ"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	flags, payload := receiveExtendedClientCutText(t, conn)
+	if got, want := flags, ClipboardNotify|ClipboardText; got != want {
+		t.Errorf("incorrect flags; got = %#x, want = %#x", got, want)
+	}
+	if got := len(payload); got != 0 {
+		t.Errorf("unexpected payload of %d bytes", got)
+	}
+
+	// The server requests the text, and the client provides it.
+	if err := conn.send(extendedServerCutText(ClipboardRequest|ClipboardText|ClipboardRTF, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&ServerCutText{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	flags, payload = receiveExtendedClientCutText(t, conn)
+	if got, want := flags, ClipboardProvide|ClipboardText; got != want {
+		t.Errorf("incorrect flags; got = %#x, want = %#x", got, want)
+	}
+	data, err := unmarshalClipboardData(flags, payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := (&ExtendedClipboard{Data: data}).Text(), "ɹɐqooɟ"; got != want {
+		t.Errorf("incorrect text; got = %q, want = %q", got, want)
+	}
+
+	// The server peeks, and the client notifies.
+	if err := conn.send(extendedServerCutText(ClipboardPeek, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&ServerCutText{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	flags, _ = receiveExtendedClientCutText(t, conn)
+	if got, want := flags, ClipboardNotify|ClipboardText; got != want {
+		t.Errorf("incorrect flags; got = %#x, want = %#x", got, want)
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes unread", mockConn.b.Len())
+	}
+}
+
+func TestExtendedClipboard_Concurrent(t *testing.T) {
+	const n = 50
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	conn := NewClientConn(cc, &ClientConfig{})
+	defer conn.Close()
+	conn.setClipboardCaps(&ExtendedClipboard{Flags: ClipboardCaps | ClipboardNotify | ClipboardText})
+	SetSettle(0) // Disable UI settling for tests.
+
+	// The server peeks, while the client sends input of its own.
+	go func() {
+		for i := 0; i < n; i++ {
+			sc.Write(append([]byte{byte(messages.ServerCutText)}, extendedServerCutText(ClipboardPeek, nil)...))
+		}
+	}()
+	go func() {
+		for i := 0; i < n; i++ {
+			var messageType messages.ServerMessage
+			if err := conn.receive(&messageType); err != nil {
+				return
+			}
+			if _, err := (&ServerCutText{}).Read(conn); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		for i := 0; i < n; i++ {
+			conn.ClientCutText("foo")
+			conn.KeyEvent(keys.A, true)
+		}
+	}()
+
+	// Each message must arrive whole.
+	for i := 0; i < 3*n; i++ {
+		var messageType messages.ClientMessage
+		if err := binary.Read(sc, binary.BigEndian, &messageType); err != nil {
+			t.Fatal(err)
+		}
+		switch messageType {
+		case messages.KeyEvent:
+			var msg [7]byte
+			if _, err := io.ReadFull(sc, msg[:]); err != nil {
+				t.Fatal(err)
+			}
+		case messages.ClientCutText:
+			var msg struct {
+				_      [3]byte
+				Length int32
+			}
+			if err := binary.Read(sc, binary.BigEndian, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := msg.Length, int32(-4); got != want {
+				t.Fatalf("incorrect length; got = %d, want = %d", got, want)
+			}
+			var flags ClipboardFlags
+			if err := binary.Read(sc, binary.BigEndian, &flags); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := flags, ClipboardNotify|ClipboardText; got != want {
+				t.Fatalf("incorrect flags; got = %#x, want = %#x", got, want)
+			}
+		default:
+			t.Fatalf("unexpected message-type %v", messageType)
+		}
+	}
+}
+
+func TestServerCutText_TooLong(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		length int32
+	}{
+		{"min length", math.MinInt32},
+		{"extended", -clipboardMaxSize - 1},
+		{"latin-1", clipboardMaxSize + 1},
+		{"max length", math.MaxInt32},
+	} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{})
+		if err := conn.send([]byte{0, 0, 0}); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.send(tt.length); err != nil {
+			t.Fatal(err)
+		}
+		// The text is sent in full, and only the flags of extended messages.
+		if tt.length > 0 && tt.length < math.MaxInt32 {
+			if err := conn.send(make([]byte, tt.length)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := conn.send(ClipboardProvide | ClipboardText); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := (&ServerCutText{}).Read(conn); err == nil {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+	}
+}
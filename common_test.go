@@ -60,13 +60,15 @@ func TestBuffer_Write(t *testing.T) {
 
 // MockConn implements the net.Conn interface.
 type MockConn struct {
-	b bytes.Buffer
+	b      bytes.Buffer
+	writes int // Number of calls to Write.
 }
 
 func (m *MockConn) Read(b []byte) (int, error) {
 	return m.b.Read(b)
 }
 func (m *MockConn) Write(b []byte) (int, error) {
+	m.writes++
 	return m.b.Write(b)
 }
 func (m *MockConn) Close() error                       { return nil }
@@ -79,4 +81,5 @@ func (m *MockConn) SetWriteDeadline(t time.Time) error { return nil }
 // Implement additional buffer.Buffer functions.
 func (m *MockConn) Reset() {
 	m.b.Reset()
+	m.writes = 0
 }
@@ -1,5 +1,5 @@
 /*
-Package vnc provides VNC client implementation.
+Package vnc provides VNC client and server implementations.
 
 This package implements The Remote Framebuffer Protocol as documented in
 [RFC 6143](http://tools.ietf.org/html/rfc6143).
@@ -0,0 +1,122 @@
+// Implementation of the EAX mode of operation, used by the RSA-AES security
+// types. http://web.cs.ucdavis.edu/~rogaway/papers/eax.pdf
+
+package vnc
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+const eaxTagSize = 16
+
+// eax is an AEAD in EAX mode, with 16 byte nonces and tags.
+type eax struct {
+	block  cipher.Block
+	k1, k2 []byte // CMAC subkeys.
+}
+
+// Verify that interfaces are honored.
+var _ cipher.AEAD = (*eax)(nil)
+
+// newEAX returns block, which must have a block size of 16 bytes, in EAX mode.
+func newEAX(block cipher.Block) cipher.AEAD {
+	e := &eax{block: block, k1: make([]byte, block.BlockSize())}
+	block.Encrypt(e.k1, e.k1)
+	e.k1 = cmacDouble(e.k1)
+	e.k2 = cmacDouble(e.k1)
+	return e
+}
+
+// cmacDouble returns b multiplied by x in GF(2^128).
+func cmacDouble(b []byte) []byte {
+	d := make([]byte, len(b))
+	for i := range b {
+		d[i] = b[i] << 1
+		if i+1 < len(b) {
+			d[i] |= b[i+1] >> 7
+		}
+	}
+	if b[0]&0x80 != 0 {
+		d[len(d)-1] ^= 0x87
+	}
+	return d
+}
+
+// omac returns the CMAC of data, prefixed by the block of value t.
+func (e *eax) omac(t byte, data []byte) []byte {
+	size := e.block.BlockSize()
+	msg := make([]byte, size, size+len(data)+size)
+	msg[size-1] = t
+	msg = append(msg, data...)
+
+	// Pad the last block, if incomplete, and mask it with a subkey.
+	k := e.k1
+	if len(msg)%size != 0 {
+		msg = append(msg, 0x80)
+		for len(msg)%size != 0 {
+			msg = append(msg, 0)
+		}
+		k = e.k2
+	}
+	subtle.XORBytes(msg[len(msg)-size:], msg[len(msg)-size:], k)
+
+	mac := make([]byte, size)
+	for i := 0; i < len(msg); i += size {
+		subtle.XORBytes(mac, mac, msg[i:i+size])
+		e.block.Encrypt(mac, mac)
+	}
+	return mac
+}
+
+// NonceSize implements the cipher.AEAD interface.
+func (e *eax) NonceSize() int { return e.block.BlockSize() }
+
+// Overhead implements the cipher.AEAD interface.
+func (e *eax) Overhead() int { return eaxTagSize }
+
+// tag returns the tag of the ciphertext, and its header.
+func (e *eax) tag(n, ciphertext, header []byte) []byte {
+	t := e.omac(2, ciphertext)
+	subtle.XORBytes(t, t, n)
+	subtle.XORBytes(t, t, e.omac(1, header))
+	return t[:eaxTagSize]
+}
+
+// Seal implements the cipher.AEAD interface.
+func (e *eax) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	n := e.omac(0, nonce)
+	ret, out := sliceForAppend(dst, len(plaintext)+eaxTagSize)
+	cipher.NewCTR(e.block, n).XORKeyStream(out, plaintext)
+	copy(out[len(plaintext):], e.tag(n, out[:len(plaintext)], additionalData))
+	return ret
+}
+
+// Open implements the cipher.AEAD interface.
+func (e *eax) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < eaxTagSize {
+		return nil, errors.New("eax: message too short")
+	}
+	n := e.omac(0, nonce)
+	ciphertext, tag := ciphertext[:len(ciphertext)-eaxTagSize], ciphertext[len(ciphertext)-eaxTagSize:]
+	if subtle.ConstantTimeCompare(tag, e.tag(n, ciphertext, additionalData)) != 1 {
+		return nil, errors.New("eax: message authentication failed")
+	}
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	cipher.NewCTR(e.block, n).XORKeyStream(out, ciphertext)
+	return ret, nil
+}
+
+// sliceForAppend extends in by n bytes, and returns the extended slice, and
+// the n bytes appended.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,56 @@
+package vnc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestEAX(t *testing.T) {
+	// Test vectors of the EAX paper.
+	for _, tt := range []struct {
+		msg, key, nonce, header, cipher string
+	}{
+		{"", "233952DEE4D5ED5F9B9C6D6FF80FF478", "62EC67F9C3A4A407FCB2A8C49031A8B3", "6BFB914FD07EAE6B",
+			"E037830E8389F27B025A2D6527E79D01"},
+		{"F7FB", "91945D3F4DCBEE0BF45EF52255F095A4", "BECAF043B0A23D843194BA972C66DEBD", "FA3BFD4806EB53FA",
+			"19DD5C4C9331049D0BDAB0277408F67967E5"},
+		{"1A47CB4933", "01F74AD64077F2E704C0F60ADA3DD523", "70C3DB4F0D26368400A10ED05D2BFF5E", "234A3463C1264AC6",
+			"D851D5BAE03A59F238A23E39199DC9266626C40F80"},
+		{"481C9E39B1", "D07CF6CBB7F313BDDE66B727AFD3C5E8", "8408DFFF3C1A2B1292DC199E46B7D617", "33CCE2EABFF5A79D",
+			"632A9D131AD4C168A4225D8E1FF755939974A7BEDE"},
+		{"8B0A79306C9CE7ED99DAE4F87F8DD61636", "7C77D6E813BED5AC98BAA417477A2E7D", "1A8C98DCD73D38393B2BF1569DEEFC19", "65D2017990D62528",
+			"02083E3979DA014812F59F11D52630DA30137327D10649B0AA6E1C181DB617D7F2"},
+	} {
+		decode := func(s string) []byte {
+			b, err := hex.DecodeString(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}
+		block, err := aes.NewCipher(decode(tt.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		aead := newEAX(block)
+		msg, nonce, header, want := decode(tt.msg), decode(tt.nonce), decode(tt.header), decode(tt.cipher)
+
+		got := aead.Seal(nil, nonce, msg, header)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: incorrect ciphertext; got = %X, want = %X", tt.msg, got, want)
+		}
+		plain, err := aead.Open(nil, nonce, want, header)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.msg, err)
+		}
+		if !bytes.Equal(plain, msg) {
+			t.Errorf("%s: incorrect plaintext; got = %X, want = %X", tt.msg, plain, msg)
+		}
+		want[0] ^= 1
+		if _, err := aead.Open(nil, nonce, want, header); err == nil {
+			t.Errorf("%s: expected error for modified ciphertext", tt.msg)
+		}
+	}
+}
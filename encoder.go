@@ -0,0 +1,198 @@
+// Encoders of a VNC server.
+
+package vnc
+
+import (
+	"image"
+
+	"github.com/kward/go-vnc/encodings"
+)
+
+// An Encoder encodes the pixel data of rectangles sent by a server, for
+// clients that support its encoding.
+type Encoder interface {
+	// Type returns the encoding of the encoder.
+	Type() encodings.Encoding
+
+	// Encode returns the encoding of a rectangle of the given size, with colors
+	// in the pixel format of the client of s. It returns nil if the colors are
+	// better sent with another encoding.
+	Encode(s *ServerConn, colors []Color, width, height uint16) (Encoding, error)
+}
+
+// DefaultEncoders returns the encoders of a server whose config does not set
+// any.
+func DefaultEncoders() []Encoder {
+	return []Encoder{
+		&RawEncoder{},
+		&CopyRectEncoder{},
+		&RREEncoder{},
+		&HextileEncoder{},
+		&ZlibEncoder{},
+		&ZRLEEncoder{},
+		&TightEncoder{},
+	}
+}
+
+// encodeTileSize is the maximum width and height of the rectangles the
+// framebuffer source is encoded in, so that each encoding is chosen for a
+// small part of the changed regions.
+const encodeTileSize = 256
+
+// splitRect splits r into rectangles of at most size by size pixels.
+func splitRect(r image.Rectangle, size int) []image.Rectangle {
+	var rects []image.Rectangle
+	for y := r.Min.Y; y < r.Max.Y; y += size {
+		for x := r.Min.X; x < r.Max.X; x += size {
+			rects = append(rects, image.Rect(x, y, x+size, y+size).Intersect(r))
+		}
+	}
+	return rects
+}
+
+// RawEncoder encodes rectangles with the Raw encoding. It accepts all of them.
+type RawEncoder struct{}
+
+// Verify that interfaces are honored.
+var _ Encoder = (*RawEncoder)(nil)
+
+// Type implements the Encoder interface.
+func (*RawEncoder) Type() encodings.Encoding { return encodings.Raw }
+
+// Encode implements the Encoder interface.
+func (*RawEncoder) Encode(s *ServerConn, colors []Color, width, height uint16) (Encoding, error) {
+	return &RawEncoding{colors}, nil
+}
+
+// CopyRectEncoder enables the CopyRect encoding, for regions that a
+// FramebufferCopier reports as copied. Pixel data can not be copied, so it
+// accepts no rectangles.
+type CopyRectEncoder struct{}
+
+// Verify that interfaces are honored.
+var _ Encoder = (*CopyRectEncoder)(nil)
+
+// Type implements the Encoder interface.
+func (*CopyRectEncoder) Type() encodings.Encoding { return encodings.CopyRect }
+
+// Encode implements the Encoder interface.
+func (*CopyRectEncoder) Encode(s *ServerConn, colors []Color, width, height uint16) (Encoding, error) {
+	return nil, nil
+}
+
+// RREEncoder encodes rectangles with the RRE encoding. It accepts rectangles
+// whose subrectangles are smaller than their raw pixel data, i.e. those that
+// are mostly a single color.
+type RREEncoder struct{}
+
+// Verify that interfaces are honored.
+var _ Encoder = (*RREEncoder)(nil)
+
+// Type implements the Encoder interface.
+func (*RREEncoder) Type() encodings.Encoding { return encodings.RRE }
+
+// Encode implements the Encoder interface.
+func (*RREEncoder) Encode(s *ServerConn, colors []Color, width, height uint16) (Encoding, error) {
+	if len(colors) == 0 {
+		return nil, nil
+	}
+	tile, err := newPixelTile(colors, int(width), 0, 0, max(int(width), int(height)))
+	if err != nil {
+		return nil, err
+	}
+	bg, n := tile.background()
+	rawSize := len(colors) * len(bg)
+	rreSize := func(numSubrects int) int { return 4 + len(bg) + numSubrects*(len(bg)+8) }
+	// Each color other than the background needs a subrectangle, at least.
+	if rreSize(n-1) >= rawSize {
+		return nil, nil
+	}
+
+	tileSubrects := tile.subrects(bg)
+	if rreSize(len(tileSubrects)) >= rawSize {
+		return nil, nil
+	}
+	e := &RREEncoding{Subrects: make([]RRESubrectangle, len(tileSubrects))}
+	for i, r := range tileSubrects {
+		e.Subrects[i] = RRESubrectangle{colors[r.y*int(width)+r.x],
+			uint16(r.x), uint16(r.y), uint16(r.w), uint16(r.h)}
+	}
+	// Find a pixel of the background color.
+	for i, p := range tile.pixels {
+		if string(p) == string(bg) {
+			e.Background = colors[i]
+			break
+		}
+	}
+	return e, nil
+}
+
+// HextileEncoder encodes rectangles with the Hextile encoding. It accepts all
+// of them, as the encoding falls back to raw pixel data per tile.
+type HextileEncoder struct{}
+
+// Verify that interfaces are honored.
+var _ Encoder = (*HextileEncoder)(nil)
+
+// Type implements the Encoder interface.
+func (*HextileEncoder) Type() encodings.Encoding { return encodings.Hextile }
+
+// Encode implements the Encoder interface.
+func (*HextileEncoder) Encode(s *ServerConn, colors []Color, width, height uint16) (Encoding, error) {
+	return NewHextileEncoding(colors, width, height), nil
+}
+
+// ZlibEncoder encodes rectangles with the Zlib encoding, continuing the zlib
+// stream of the connection. It accepts all of them.
+type ZlibEncoder struct{}
+
+// Verify that interfaces are honored.
+var _ Encoder = (*ZlibEncoder)(nil)
+
+// Type implements the Encoder interface.
+func (*ZlibEncoder) Type() encodings.Encoding { return encodings.Zlib }
+
+// Encode implements the Encoder interface.
+func (*ZlibEncoder) Encode(s *ServerConn, colors []Color, width, height uint16) (Encoding, error) {
+	return s.NewZlibEncoding(colors, width, height), nil
+}
+
+// ZRLEEncoder encodes rectangles with the ZRLE encoding, continuing the zlib
+// stream of the connection. It accepts all of them.
+type ZRLEEncoder struct{}
+
+// Verify that interfaces are honored.
+var _ Encoder = (*ZRLEEncoder)(nil)
+
+// Type implements the Encoder interface.
+func (*ZRLEEncoder) Type() encodings.Encoding { return encodings.ZRLE }
+
+// Encode implements the Encoder interface.
+func (*ZRLEEncoder) Encode(s *ServerConn, colors []Color, width, height uint16) (Encoding, error) {
+	return s.NewZRLEEncoding(colors, width, height), nil
+}
+
+// Limits of Tight rectangles, as imposed by common clients.
+const (
+	tightMaxRectWidth = 2048
+	tightMaxRectSize  = 1 << 16 // Pixels.
+)
+
+// TightEncoder encodes rectangles with the Tight encoding, using fill,
+// palette or copy compression depending on the number of colors. It accepts
+// rectangles of up to 2048 pixels wide, and of up to 65536 pixels.
+type TightEncoder struct{}
+
+// Verify that interfaces are honored.
+var _ Encoder = (*TightEncoder)(nil)
+
+// Type implements the Encoder interface.
+func (*TightEncoder) Type() encodings.Encoding { return encodings.Tight }
+
+// Encode implements the Encoder interface.
+func (*TightEncoder) Encode(s *ServerConn, colors []Color, width, height uint16) (Encoding, error) {
+	if len(colors) == 0 || width > tightMaxRectWidth || len(colors) > tightMaxRectSize {
+		return nil, nil
+	}
+	return NewTightEncoding(colors, width, height), nil
+}
@@ -0,0 +1,137 @@
+package vnc
+
+import (
+	"image"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/messages"
+)
+
+// decodedColors returns the colors of a decoded rectangle of the given size.
+func decodedColors(enc Encoding, width, height int) []Color {
+	switch e := enc.(type) {
+	case *RawEncoding:
+		return e.Colors
+	case *HextileEncoding:
+		return e.Colors
+	case *ZlibEncoding:
+		return e.Colors
+	case *ZRLEEncoding:
+		return e.Colors
+	case *TightEncoding:
+		return e.Colors
+	case *RREEncoding:
+		colors := make([]Color, width*height)
+		for i := range colors {
+			colors[i] = e.Background
+		}
+		for _, s := range e.Subrects {
+			for y := int(s.Y); y < int(s.Y+s.Height); y++ {
+				for x := int(s.X); x < int(s.X+s.Width); x++ {
+					colors[y*width+x] = s.Color
+				}
+			}
+		}
+		return colors
+	}
+	return nil
+}
+
+func TestServerConn_Encode(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	mockConn := &MockConn{}
+	s := NewServerConn(mockConn, &ServerConfig{})
+	s.setPixelFormat(pixelFormat24bit)
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = pixelFormat24bit
+	conn.encodings = Encodings{&RawEncoding{}, &RREEncoding{}, &HextileEncoding{}, &ZlibEncoding{}, &ZRLEEncoding{}, &TightEncoding{}}
+
+	for _, tt := range []struct {
+		desc          string
+		encs          []encodings.Encoding
+		width, height uint16
+		numColors     int
+		want          encodings.Encoding
+	}{
+		{"no encodings", nil, 4, 4, 2, encodings.Raw},
+		{"raw", []encodings.Encoding{encodings.Raw, encodings.RRE}, 16, 16, 1, encodings.Raw},
+		{"rre solid", []encodings.Encoding{encodings.RRE, encodings.Raw}, 16, 16, 1, encodings.RRE},
+		{"rre many colors", []encodings.Encoding{encodings.RRE, encodings.Raw}, 16, 16, 200, encodings.Raw},
+		{"copyrect", []encodings.Encoding{encodings.CopyRect, encodings.Hextile}, 16, 16, 1, encodings.Hextile},
+		{"unsupported", []encodings.Encoding{encodings.CoRRE, encodings.ZRLE}, 70, 20, 10, encodings.ZRLE},
+		{"zrle stream", []encodings.Encoding{encodings.ZRLE}, 100, 30, 50, encodings.ZRLE},
+		{"zlib", []encodings.Encoding{encodings.Zlib}, 40, 20, 50, encodings.Zlib},
+		{"zlib stream", []encodings.Encoding{encodings.Zlib}, 100, 30, 50, encodings.Zlib},
+		{"tight", []encodings.Encoding{encodings.Tight, encodings.ZRLE}, 40, 20, 5, encodings.Tight},
+		{"tight too wide", []encodings.Encoding{encodings.Tight, encodings.Hextile}, 3000, 1, 5, encodings.Hextile},
+	} {
+		mockConn.Reset()
+		s.setEncodings(tt.encs)
+		colors := randomColors(r, &pixelFormat24bit, int(tt.width)*int(tt.height), tt.numColors)
+
+		enc, err := s.Encode(colors, tt.width, tt.height)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if got, want := enc.Type(), tt.want; got != want {
+			t.Errorf("%s: incorrect encoding; got = %v, want = %v", tt.desc, got, want)
+		}
+
+		// Validate that the client decodes the rectangle.
+		if err := s.FramebufferUpdate([]Rectangle{{Width: tt.width, Height: tt.height, Enc: enc}}); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		var messageType messages.ServerMessage
+		if err := conn.receive(&messageType); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := (&FramebufferUpdate{}).Read(conn)
+		if err != nil {
+			t.Errorf("%s: failed to read; %s", tt.desc, err)
+			continue
+		}
+		rect := msg.(*FramebufferUpdate).Rects[0]
+		if got, want := decodedColors(rect.Enc, int(tt.width), int(tt.height)), colors; !equalColors(got, want) {
+			t.Errorf("%s: colors did not round-trip", tt.desc)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes unread", tt.desc, mockConn.b.Len())
+		}
+	}
+}
+
+func TestServerConn_EncodersConfig(t *testing.T) {
+	s := NewServerConn(&MockConn{}, &ServerConfig{Encoders: []Encoder{&HextileEncoder{}}})
+	s.setEncodings([]encodings.Encoding{encodings.ZRLE, encodings.Hextile})
+	colors := randomColors(rand.New(rand.NewSource(1)), &s.pixelFormat, 4, 1)
+
+	enc, err := s.Encode(colors, 2, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := enc.Type(), encodings.Hextile; got != want {
+		t.Errorf("incorrect encoding; got = %v, want = %v", got, want)
+	}
+}
+
+func TestSplitRect(t *testing.T) {
+	for _, tt := range []struct {
+		r    image.Rectangle
+		want []image.Rectangle
+	}{
+		{image.Rect(0, 0, 0, 0), nil},
+		{image.Rect(1, 2, 3, 4), []image.Rectangle{image.Rect(1, 2, 3, 4)}},
+		{image.Rect(1, 2, 12, 8), []image.Rectangle{
+			image.Rect(1, 2, 6, 7), image.Rect(6, 2, 11, 7), image.Rect(11, 2, 12, 7),
+			image.Rect(1, 7, 6, 8), image.Rect(6, 7, 11, 8), image.Rect(11, 7, 12, 8),
+		}},
+	} {
+		if got, want := splitRect(tt.r, 5), tt.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: incorrect rects; got = %v, want = %v", tt.r, got, want)
+		}
+	}
+}
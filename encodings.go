@@ -6,7 +6,13 @@ package vnc
 
 import (
 	"bytes"
+	"compress/zlib"
+	"encoding/binary"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
 
 	"github.com/kward/go-vnc/encodings"
 )
@@ -108,47 +114,2027 @@ func (*RawEncoding) String() string { return "RawEncoding" }
 // Type implements the Encoding interface.
 func (*RawEncoding) Type() encodings.Encoding { return encodings.Raw }
 
+//-----------------------------------------------------------------------------
+// CopyRect Encoding
+//
+// The CopyRect (copy rectangle) encoding is a very simple and efficient
+// encoding that can be used when the client already has the same pixel data
+// elsewhere in its framebuffer. The encoding on the wire simply consists of
+// an X,Y coordinate. This gives a position in the framebuffer from which the
+// client can copy the rectangle of pixel data.
+//
+// See RFC 6143 §7.7.2.
+// https://tools.ietf.org/html/rfc6143#section-7.7.2
+
+// CopyRectEncoding holds the source position of copied rectangle data.
+type CopyRectEncoding struct {
+	SX, SY uint16 // src-x-position, src-y-position
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*CopyRectEncoding)(nil)
+
+// Marshal implements the Encoding interface.
+func (e *CopyRectEncoding) Marshal() ([]byte, error) {
+	buf := NewBuffer(nil)
+	if err := buf.Write(e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Read implements the Encoding interface.
+func (*CopyRectEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	var e CopyRectEncoding
+	if err := c.receive(&e); err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with copyrect encoding: %s", err)
+	}
+	return &e, nil
+}
+
+// Apply copies the source pixel data within the framebuffer fb to the
+// position described by rect. Overlapping source and destination areas are
+// handled correctly.
+func (e *CopyRectEncoding) Apply(fb draw.Image, rect *Rectangle) {
+	dst := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height))
+	draw.Draw(fb, dst, fb, image.Pt(int(e.SX), int(e.SY)), draw.Src)
+}
+
+// String implements the fmt.Stringer interface.
+func (*CopyRectEncoding) String() string { return "CopyRectEncoding" }
+
+// Type implements the Encoding interface.
+func (*CopyRectEncoding) Type() encodings.Encoding { return encodings.CopyRect }
+
+//-----------------------------------------------------------------------------
+// RRE Encoding
+//
+// RRE stands for rise-and-run-length encoding. As its name implies, it is
+// essentially a two-dimensional analogue of run-length encoding. RRE-encoded
+// rectangles arrive at the client in a form that can be rendered immediately
+// by the simplest of graphics engines.
+//
+// The CoRRE (compact RRE) variant limits rectangles to 255x255 pixels so that
+// subrectangle positions and sizes fit into single bytes. CoRRE is not part of
+// RFC 6143, but is widely implemented by older servers.
+//
+// See RFC 6143 §7.7.3.
+// https://tools.ietf.org/html/rfc6143#section-7.7.3
+
+// RRESubrectangle describes a single solid colored subrectangle of an RRE or
+// CoRRE encoded rectangle. The position is relative to the rectangle.
+type RRESubrectangle struct {
+	Color         Color  // subrect-pixel-value
+	X, Y          uint16 // x-, y-position
+	Width, Height uint16 // width, height
+}
+
+// RREEncoding holds RRE encoded rectangle data.
+type RREEncoding struct {
+	Background Color
+	Subrects   []RRESubrectangle
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*RREEncoding)(nil)
+
+// Marshal implements the Encoding interface.
+func (e *RREEncoding) Marshal() ([]byte, error) {
+	return marshalRRE(e.Background, e.Subrects, false)
+}
+
+// Read implements the Encoding interface.
+func (*RREEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	bg, subrects, err := readRRE(c, rect, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with rre encoding: %s", err)
+	}
+	return &RREEncoding{bg, subrects}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*RREEncoding) String() string { return "RREEncoding" }
+
+// Type implements the Encoding interface.
+func (*RREEncoding) Type() encodings.Encoding { return encodings.RRE }
+
+// CoRREEncoding holds CoRRE encoded rectangle data.
+type CoRREEncoding struct {
+	Background Color
+	Subrects   []RRESubrectangle
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*CoRREEncoding)(nil)
+
+// Marshal implements the Encoding interface.
+func (e *CoRREEncoding) Marshal() ([]byte, error) {
+	return marshalRRE(e.Background, e.Subrects, true)
+}
+
+// Read implements the Encoding interface.
+func (*CoRREEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	bg, subrects, err := readRRE(c, rect, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with corre encoding: %s", err)
+	}
+	return &CoRREEncoding{bg, subrects}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*CoRREEncoding) String() string { return "CoRREEncoding" }
+
+// Type implements the Encoding interface.
+func (*CoRREEncoding) Type() encodings.Encoding { return encodings.CoRRE }
+
+// readRRE reads the background pixel and subrectangles of an RRE or CoRRE
+// (compact) rectangle.
+func readRRE(c *ClientConn, rect *Rectangle, compact bool) (Color, []RRESubrectangle, error) {
+	var numSubrects uint32
+	if err := c.receive(&numSubrects); err != nil {
+		return Color{}, nil, err
+	}
+	// A rectangle has no more distinct subrectangles than pixels, which bounds
+	// the memory allocated for a count sent by the server.
+	if uint64(numSubrects) > uint64(rect.Width)*uint64(rect.Height) {
+		return Color{}, nil, fmt.Errorf("%d subrectangles for a %dx%d rectangle", numSubrects, rect.Width, rect.Height)
+	}
+	bg, err := c.readColor()
+	if err != nil {
+		return Color{}, nil, err
+	}
+
+	subrects := make([]RRESubrectangle, numSubrects)
+	for i := range subrects {
+		s := &subrects[i]
+		if s.Color, err = c.readColor(); err != nil {
+			return Color{}, nil, err
+		}
+		if compact {
+			var msg [4]uint8
+			if err := c.receive(&msg); err != nil {
+				return Color{}, nil, err
+			}
+			s.X, s.Y, s.Width, s.Height = uint16(msg[0]), uint16(msg[1]), uint16(msg[2]), uint16(msg[3])
+			continue
+		}
+		var msg [4]uint16
+		if err := c.receive(&msg); err != nil {
+			return Color{}, nil, err
+		}
+		s.X, s.Y, s.Width, s.Height = msg[0], msg[1], msg[2], msg[3]
+	}
+	return bg, subrects, nil
+}
+
+// marshalRRE returns the wire encoding of an RRE or CoRRE (compact) rectangle.
+func marshalRRE(bg Color, subrects []RRESubrectangle, compact bool) ([]byte, error) {
+	buf := NewBuffer(nil)
+	if err := buf.Write(uint32(len(subrects))); err != nil {
+		return nil, err
+	}
+	bytes, err := bg.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if err := buf.Write(bytes); err != nil {
+		return nil, err
+	}
+
+	for _, s := range subrects {
+		bytes, err := s.Color.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		if err := buf.Write(bytes); err != nil {
+			return nil, err
+		}
+		if compact {
+			if s.X > 255 || s.Y > 255 || s.Width > 255 || s.Height > 255 {
+				return nil, fmt.Errorf("subrectangle %v too large for corre encoding", s)
+			}
+			if err := buf.Write([4]uint8{uint8(s.X), uint8(s.Y), uint8(s.Width), uint8(s.Height)}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := buf.Write([4]uint16{s.X, s.Y, s.Width, s.Height}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+//-----------------------------------------------------------------------------
+// Hextile Encoding
+//
+// Hextile is a variation on the RRE idea. Rectangles are split up into 16x16
+// tiles, allowing the dimensions of the subrectangles to be specified in 4
+// bits each, 16 bits in total. The tiles are ordered starting at the top-left
+// going in left-to-right, top-to-bottom order.
+//
+// See RFC 6143 §7.7.4.
+// https://tools.ietf.org/html/rfc6143#section-7.7.4
+
+// Hextile subencoding mask bits.
+const (
+	hextileRaw                 = 1 << 0
+	hextileBackgroundSpecified = 1 << 1
+	hextileForegroundSpecified = 1 << 2
+	hextileAnySubrects         = 1 << 3
+	hextileSubrectsColoured    = 1 << 4
+)
+
+const hextileTileSize = 16
+
+// HextileEncoding holds Hextile encoded rectangle data. The pixel data is
+// stored decoded, in the same layout as RawEncoding.
+type HextileEncoding struct {
+	Colors        []Color
+	width, height uint16
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*HextileEncoding)(nil)
+
+// NewHextileEncoding returns a HextileEncoding for the colors of a rectangle
+// of the given width and height.
+func NewHextileEncoding(colors []Color, width, height uint16) *HextileEncoding {
+	return &HextileEncoding{colors, width, height}
+}
+
+// Marshal implements the Encoding interface.
+func (e *HextileEncoding) Marshal() ([]byte, error) {
+	if len(e.Colors) != int(e.width)*int(e.height) {
+		return nil, fmt.Errorf("hextile encoding has %d colors for a %dx%d rectangle", len(e.Colors), e.width, e.height)
+	}
+
+	buf := NewBuffer(nil)
+	var bg, fg []byte // The background and foreground known to the client.
+	for ty := 0; ty < int(e.height); ty += hextileTileSize {
+		for tx := 0; tx < int(e.width); tx += hextileTileSize {
+			tile, err := newPixelTile(e.Colors, int(e.width), tx, ty, hextileTileSize)
+			if err != nil {
+				return nil, err
+			}
+			if bg, fg, err = tile.marshalHextile(buf, bg, fg); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Read implements the Encoding interface.
+func (*HextileEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	colors, err := c.readHextile(rect, false)
+	if err != nil {
+		return nil, err
+	}
+	return &HextileEncoding{colors, rect.Width, rect.Height}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*HextileEncoding) String() string { return "HextileEncoding" }
+
+// Type implements the Encoding interface.
+func (*HextileEncoding) Type() encodings.Encoding { return encodings.Hextile }
+
+// readHextile reads a Hextile encoded rectangle. If zlibHex is set, tiles may
+// also use the zlib compressed subencodings of ZlibHex.
+func (c *ClientConn) readHextile(rect *Rectangle, zlibHex bool) ([]Color, error) {
+	colors := make([]Color, rect.Area())
+	var bg, fg Color
+	for ty := 0; ty < int(rect.Height); ty += hextileTileSize {
+		th := min(hextileTileSize, int(rect.Height)-ty)
+		for tx := 0; tx < int(rect.Width); tx += hextileTileSize {
+			tw := min(hextileTileSize, int(rect.Width)-tx)
+			fill := func(x, y, w, h int, color Color) {
+				for j := y; j < y+h; j++ {
+					for i := x; i < x+w; i++ {
+						colors[(ty+j)*int(rect.Width)+tx+i] = color
+					}
+				}
+			}
+
+			var subenc uint8
+			if err := c.receive(&subenc); err != nil {
+				return nil, err
+			}
+
+			r := c.reader()
+			if zlibHex && subenc&(zlibHexRaw|zlibHexHex) != 0 {
+				stream := &c.zlibHexStream
+				if subenc&zlibHexRaw != 0 {
+					stream = &c.zlibHexRawStream
+				}
+				var err error
+				if r, err = c.readZlibHexData(stream); err != nil {
+					return nil, err
+				}
+			}
+
+			if subenc&(hextileRaw|zlibHexRaw) != 0 {
+				tile, err := c.readPixels(r, tw*th)
+				if err != nil {
+					return nil, err
+				}
+				for j := 0; j < th; j++ {
+					copy(colors[(ty+j)*int(rect.Width)+tx:], tile[j*tw:(j+1)*tw])
+				}
+				continue
+			}
+
+			readColor := func() (Color, error) {
+				colors, err := c.readPixels(r, 1)
+				if err != nil {
+					return Color{}, err
+				}
+				return colors[0], nil
+			}
+			var err error
+			if subenc&hextileBackgroundSpecified != 0 {
+				if bg, err = readColor(); err != nil {
+					return nil, err
+				}
+			}
+			fill(0, 0, tw, th, bg)
+			if subenc&hextileForegroundSpecified != 0 {
+				if fg, err = readColor(); err != nil {
+					return nil, err
+				}
+			}
+			if subenc&hextileAnySubrects == 0 {
+				continue
+			}
+
+			var numSubrects [1]uint8
+			if _, err := io.ReadFull(r, numSubrects[:]); err != nil {
+				return nil, err
+			}
+			for i := 0; i < int(numSubrects[0]); i++ {
+				if subenc&hextileSubrectsColoured != 0 {
+					if fg, err = readColor(); err != nil {
+						return nil, err
+					}
+				}
+				var xywh [2]uint8
+				if _, err := io.ReadFull(r, xywh[:]); err != nil {
+					return nil, err
+				}
+				x, y := int(xywh[0]>>4), int(xywh[0]&0x0f)
+				w, h := int(xywh[1]>>4)+1, int(xywh[1]&0x0f)+1
+				if x+w > tw || y+h > th {
+					return nil, fmt.Errorf("hextile subrectangle exceeds tile bounds")
+				}
+				fill(x, y, w, h, fg)
+			}
+		}
+	}
+	return colors, nil
+}
+
+// pixelTile holds the wire encoded pixel values of a single tile, as used by
+// the tile based encodings.
+type pixelTile struct {
+	w, h   int
+	pixels [][]byte
+}
+
+// newPixelTile returns the tile at tx, ty of at most size by size pixels from
+// colors, which describe a rectangle of the given width.
+func newPixelTile(colors []Color, width, tx, ty, size int) (*pixelTile, error) {
+	height := len(colors) / width
+	t := &pixelTile{
+		w: min(size, width-tx),
+		h: min(size, height-ty),
+	}
+	t.pixels = make([][]byte, t.w*t.h)
+	for y := 0; y < t.h; y++ {
+		for x := 0; x < t.w; x++ {
+			bytes, err := colors[(ty+y)*width+tx+x].Marshal()
+			if err != nil {
+				return nil, err
+			}
+			t.pixels[y*t.w+x] = bytes
+		}
+	}
+	return t, nil
+}
+
+// at returns the pixel value at x, y of the tile.
+func (t *pixelTile) at(x, y int) []byte { return t.pixels[y*t.w+x] }
+
+// background returns the most frequently used pixel value of the tile, and the
+// number of unique pixel values.
+func (t *pixelTile) background() ([]byte, int) {
+	counts := map[string]int{}
+	var bg []byte
+	for _, p := range t.pixels {
+		counts[string(p)]++
+		if bg == nil || counts[string(p)] > counts[string(bg)] {
+			bg = p
+		}
+	}
+	return bg, len(counts)
+}
+
+// subrects returns the subrectangles that cover all pixels of the tile that do
+// not match the background.
+func (t *pixelTile) subrects(bg []byte) []tileSubrect {
+	var rects []tileSubrect
+	covered := make([]bool, len(t.pixels))
+	for y := 0; y < t.h; y++ {
+		for x := 0; x < t.w; x++ {
+			p := t.at(x, y)
+			if covered[y*t.w+x] || bytes.Equal(p, bg) {
+				continue
+			}
+			// Grow the subrectangle right, and then down.
+			w := 1
+			for x+w < t.w && !covered[y*t.w+x+w] && bytes.Equal(t.at(x+w, y), p) {
+				w++
+			}
+			h := 1
+		Grow:
+			for y+h < t.h {
+				for i := x; i < x+w; i++ {
+					if covered[(y+h)*t.w+i] || !bytes.Equal(t.at(i, y+h), p) {
+						break Grow
+					}
+				}
+				h++
+			}
+			for j := y; j < y+h; j++ {
+				for i := x; i < x+w; i++ {
+					covered[j*t.w+i] = true
+				}
+			}
+			rects = append(rects, tileSubrect{p, x, y, w, h})
+		}
+	}
+	return rects
+}
+
+// tileSubrect describes a solid subrectangle of a pixelTile.
+type tileSubrect struct {
+	pixel      []byte
+	x, y, w, h int
+}
+
+// marshalHextile writes the Hextile encoding of the tile to buf. The bg and fg
+// arguments hold the background and foreground pixel values carried over from
+// the previous tile, or nil if unknown. The updated values are returned.
+func (t *pixelTile) marshalHextile(buf *Buffer, bg, fg []byte) ([]byte, []byte, error) {
+	bytesPerPixel := len(t.pixels[0])
+	rawLen := 1 + len(t.pixels)*bytesPerPixel
+
+	tileBg, numColors := t.background()
+	var (
+		subenc   uint8
+		data     []byte
+		subrects []tileSubrect
+	)
+	if !bytes.Equal(tileBg, bg) {
+		subenc |= hextileBackgroundSpecified
+		data = append(data, tileBg...)
+	}
+	if numColors > 1 {
+		subrects = t.subrects(tileBg)
+		subenc |= hextileAnySubrects
+		if numColors == 2 {
+			if !bytes.Equal(subrects[0].pixel, fg) {
+				subenc |= hextileForegroundSpecified
+				data = append(data, subrects[0].pixel...)
+			}
+		} else {
+			subenc |= hextileSubrectsColoured
+		}
+		data = append(data, uint8(len(subrects)))
+		for _, s := range subrects {
+			if subenc&hextileSubrectsColoured != 0 {
+				data = append(data, s.pixel...)
+			}
+			data = append(data, uint8(s.x<<4|s.y), uint8((s.w-1)<<4|(s.h-1)))
+		}
+	}
+
+	if len(subrects) > 255 || 1+len(data) >= rawLen {
+		if err := buf.WriteByte(hextileRaw); err != nil {
+			return nil, nil, err
+		}
+		for _, p := range t.pixels {
+			if err := buf.Write(p); err != nil {
+				return nil, nil, err
+			}
+		}
+		// The background and foreground are not reliably carried over a raw tile.
+		return nil, nil, nil
+	}
+
+	if err := buf.WriteByte(subenc); err != nil {
+		return nil, nil, err
+	}
+	if err := buf.Write(data); err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case subenc&hextileSubrectsColoured != 0:
+		fg = nil
+	case subenc&hextileAnySubrects != 0:
+		fg = subrects[0].pixel
+	}
+	return tileBg, fg, nil
+}
+
+//-----------------------------------------------------------------------------
+// TRLE Encoding
+//
+// TRLE stands for Tiled Run-Length Encoding, and combines tiling, palettization
+// and run-length encoding. The rectangle is divided into 16x16 tiles, which are
+// sent in left-to-right, top-to-bottom order. Each tile is encoded with one of
+// a raw, solid, packed palette, plain RLE or palette RLE subencoding. The
+// palette of the previous tile may be reused.
+//
+// See RFC 6143 §7.7.5.
+// https://tools.ietf.org/html/rfc6143#section-7.7.5
+
+const trleTileSize = 16
+
+// TRLEEncoding holds TRLE encoded rectangle data. The pixel data is stored
+// decoded, in the same layout as RawEncoding.
+type TRLEEncoding struct {
+	Colors        []Color
+	width, height uint16
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*TRLEEncoding)(nil)
+
+// NewTRLEEncoding returns a TRLEEncoding for the colors of a rectangle of the
+// given width and height.
+func NewTRLEEncoding(colors []Color, width, height uint16) *TRLEEncoding {
+	return &TRLEEncoding{colors, width, height}
+}
+
+// Marshal implements the Encoding interface.
+func (e *TRLEEncoding) Marshal() ([]byte, error) {
+	return marshalRLETiles(e.Colors, e.width, e.height, trleTileSize)
+}
+
+// Read implements the Encoding interface.
+func (*TRLEEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	colors, err := c.readRLETiles(c.reader(), rect, trleTileSize, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with trle encoding: %s", err)
+	}
+	return &TRLEEncoding{colors, rect.Width, rect.Height}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*TRLEEncoding) String() string { return "TRLEEncoding" }
+
+// Type implements the Encoding interface.
+func (*TRLEEncoding) Type() encodings.Encoding { return encodings.TRLE }
+
+//-----------------------------------------------------------------------------
+// ZRLE Encoding
+//
+// ZRLE stands for Zlib (zlib is a compression library) Run-Length Encoding,
+// and combines an encoding similar to TRLE with zlib compression. The
+// rectangle is divided into 64x64 tiles, which are encoded with the same
+// subencodings as TRLE. A single zlib stream object is used for a given RFB
+// protocol connection, so that ZRLE rectangles must be encoded and decoded
+// strictly in order.
+//
+// See RFC 6143 §7.7.6.
+// https://tools.ietf.org/html/rfc6143#section-7.7.6
+
+const zrleTileSize = 64
+
+// ZRLEEncoding holds ZRLE encoded rectangle data. The pixel data is stored
+// decoded, in the same layout as RawEncoding.
+type ZRLEEncoding struct {
+	Colors        []Color
+	width, height uint16
+	stream        *zlibWriter // The zlib stream of the connection, if known.
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ZRLEEncoding)(nil)
+
+// NewZRLEEncoding returns a ZRLEEncoding for the colors of a rectangle of the
+// given width and height, which continues the zlib stream of the connection.
+func (s *ServerConn) NewZRLEEncoding(colors []Color, width, height uint16) *ZRLEEncoding {
+	return &ZRLEEncoding{colors, width, height, &s.zrleStream}
+}
+
+// Marshal implements the Encoding interface.
+//
+// Encodings created by a ServerConn continue the zlib stream of the
+// connection, and must be marshaled in the order they are sent. Encodings
+// read by a ClientConn start a new zlib stream, so the result is only
+// decodable as the first ZRLE rectangle sent over a connection.
+func (e *ZRLEEncoding) Marshal() ([]byte, error) {
+	tiles, err := marshalRLETiles(e.Colors, e.width, e.height, zrleTileSize)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := e.stream
+	if stream == nil {
+		stream = &zlibWriter{}
+	}
+	data, err := stream.compress(tiles)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := NewBuffer(nil)
+	if err := buf.Write(uint32(len(data))); err != nil {
+		return nil, err
+	}
+	if err := buf.Write(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Read implements the Encoding interface.
+func (*ZRLEEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	var length uint32
+	if err := c.receive(&length); err != nil {
+		return nil, err
+	}
+	if max := zlibMaxLength(zrleMaxLength(rect, int(c.pixelFormat.BPP/8))); int64(length) > max {
+		return nil, fmt.Errorf("zrle data of %d bytes is too long for a %dx%d rectangle", length, rect.Width, rect.Height)
+	}
+	data := make([]uint8, length)
+	if err := c.receive(data); err != nil {
+		return nil, err
+	}
+
+	r, err := c.zrleStream.decompress(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with zrle encoding: %s", err)
+	}
+	colors, err := c.readRLETiles(r, rect, zrleTileSize, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with zrle encoding: %s", err)
+	}
+	return &ZRLEEncoding{colors, rect.Width, rect.Height, nil}, nil
+}
+
+// zrleMaxLength returns the largest uncompressed length of the tiles of rect,
+// with pixels of bytesPerPixel bytes: that of plain RLE with a run per pixel,
+// plus a subencoding and a palette per tile.
+func zrleMaxLength(rect *Rectangle, bytesPerPixel int) int64 {
+	tilesX := (int64(rect.Width) + zrleTileSize - 1) / zrleTileSize
+	tilesY := (int64(rect.Height) + zrleTileSize - 1) / zrleTileSize
+	return int64(rect.Area())*int64(bytesPerPixel+1) + tilesX*tilesY*int64(1+rleMaxPaletteSize*bytesPerPixel)
+}
+
+// String implements the fmt.Stringer interface.
+func (*ZRLEEncoding) String() string { return "ZRLEEncoding" }
+
+// Type implements the Encoding interface.
+func (*ZRLEEncoding) Type() encodings.Encoding { return encodings.ZRLE }
+
+// RLE tile subencoding types, shared by the TRLE and ZRLE encodings.
+const (
+	rleRaw             = 0
+	rleSolid           = 1
+	rlePackedPalette   = 2 // 2 through 16; the value is the palette size.
+	rleReusePacked     = 127
+	rlePlain           = 128
+	rleReusePaletteRLE = 129
+	rlePaletteRLE      = 130 // 130 through 255; the value-128 is the palette size.
+	rleMaxPackedSize   = 16
+	rleMaxPaletteSize  = 127
+)
+
+// readRLETiles reads the tiles of a TRLE or ZRLE encoded rectangle from r.
+// Palette reuse is only permitted by TRLE.
+func (c *ClientConn) readRLETiles(r io.Reader, rect *Rectangle, tileSize int, reuse bool) ([]Color, error) {
+	colors := make([]Color, rect.Area())
+	tr := &rleTileReader{c: c, r: r, reuse: reuse}
+	for ty := 0; ty < int(rect.Height); ty += tileSize {
+		th := min(tileSize, int(rect.Height)-ty)
+		for tx := 0; tx < int(rect.Width); tx += tileSize {
+			tw := min(tileSize, int(rect.Width)-tx)
+			tile, err := tr.readTile(tw, th)
+			if err != nil {
+				return nil, err
+			}
+			for j := 0; j < th; j++ {
+				copy(colors[(ty+j)*int(rect.Width)+tx:], tile[j*tw:(j+1)*tw])
+			}
+		}
+	}
+	return colors, nil
+}
+
+// rleTileReader reads the tiles of a TRLE or ZRLE encoded rectangle.
+type rleTileReader struct {
+	c       *ClientConn
+	r       io.Reader
+	reuse   bool    // Whether palette reuse is permitted.
+	palette []Color // The palette of the previous palette based tile.
+}
+
+// readPalette reads the palette for a tile with the given subencoding.
+func (tr *rleTileReader) readPalette(subenc uint8, size int) ([]Color, error) {
+	if subenc != rleReusePacked && subenc != rleReusePaletteRLE {
+		palette, err := tr.c.readCPixels(tr.r, size)
+		if err != nil {
+			return nil, err
+		}
+		tr.palette = palette
+		return palette, nil
+	}
+	if !tr.reuse {
+		return nil, fmt.Errorf("unsupported tile subencoding %d", subenc)
+	}
+	if tr.palette == nil {
+		return nil, fmt.Errorf("tile subencoding %d without a previous palette", subenc)
+	}
+	return tr.palette, nil
+}
+
+// readTile reads a single tile of tw by th pixels.
+func (tr *rleTileReader) readTile(tw, th int) ([]Color, error) {
+	c, r := tr.c, tr.r
+	var subenc uint8
+	if err := binary.Read(r, binary.BigEndian, &subenc); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case subenc == rleRaw:
+		return c.readCPixels(r, tw*th)
+
+	case subenc == rleSolid:
+		color, err := c.readCPixels(r, 1)
+		if err != nil {
+			return nil, err
+		}
+		tile := make([]Color, tw*th)
+		for i := range tile {
+			tile[i] = color[0]
+		}
+		return tile, nil
+
+	case subenc >= rlePackedPalette && subenc <= rleMaxPackedSize, subenc == rleReusePacked:
+		palette, err := tr.readPalette(subenc, int(subenc))
+		if err != nil {
+			return nil, err
+		}
+		bits := packedPaletteBits(len(palette))
+		row := make([]byte, (tw*bits+7)/8)
+		tile := make([]Color, 0, tw*th)
+		for y := 0; y < th; y++ {
+			if _, err := io.ReadFull(r, row); err != nil {
+				return nil, err
+			}
+			for x := 0; x < tw; x++ {
+				bit := x * bits
+				idx := int(row[bit/8]>>(8-bits-bit%8)) & (1<<bits - 1)
+				if idx >= len(palette) {
+					return nil, fmt.Errorf("invalid palette index %d", idx)
+				}
+				tile = append(tile, palette[idx])
+			}
+		}
+		return tile, nil
+
+	case subenc == rlePlain:
+		tile := make([]Color, 0, tw*th)
+		for len(tile) < tw*th {
+			color, err := c.readCPixels(r, 1)
+			if err != nil {
+				return nil, err
+			}
+			n, err := readRunLength(r)
+			if err != nil {
+				return nil, err
+			}
+			if len(tile)+n > tw*th {
+				return nil, fmt.Errorf("run length exceeds tile size")
+			}
+			for i := 0; i < n; i++ {
+				tile = append(tile, color[0])
+			}
+		}
+		return tile, nil
+
+	case subenc >= rlePaletteRLE, subenc == rleReusePaletteRLE:
+		palette, err := tr.readPalette(subenc, int(subenc)-128)
+		if err != nil {
+			return nil, err
+		}
+		tile := make([]Color, 0, tw*th)
+		for len(tile) < tw*th {
+			var idx uint8
+			if err := binary.Read(r, binary.BigEndian, &idx); err != nil {
+				return nil, err
+			}
+			n := 1
+			if idx&0x80 != 0 {
+				idx &= 0x7f
+				if n, err = readRunLength(r); err != nil {
+					return nil, err
+				}
+			}
+			if int(idx) >= len(palette) {
+				return nil, fmt.Errorf("invalid palette index %d", idx)
+			}
+			if len(tile)+n > tw*th {
+				return nil, fmt.Errorf("run length exceeds tile size")
+			}
+			for i := 0; i < n; i++ {
+				tile = append(tile, palette[idx])
+			}
+		}
+		return tile, nil
+	}
+
+	return nil, fmt.Errorf("unsupported tile subencoding %d", subenc)
+}
+
+// readRunLength reads a run length, which is represented as one or more bytes.
+// The length is one more than the sum of the bytes, and all but the last byte
+// have the value 255.
+func readRunLength(r io.Reader) (int, error) {
+	n := 1
+	for {
+		var b uint8
+		if err := binary.Read(r, binary.BigEndian, &b); err != nil {
+			return 0, err
+		}
+		n += int(b)
+		if b != 255 {
+			return n, nil
+		}
+	}
+}
+
+// packedPaletteBits returns the number of bits used per pixel by a packed
+// palette tile with the given palette size.
+func packedPaletteBits(size int) int {
+	switch {
+	case size <= 2:
+		return 1
+	case size <= 4:
+		return 2
+	}
+	return 4
+}
+
+// marshalRLETiles returns the uncompressed TRLE or ZRLE tile data of the
+// colors of a rectangle of the given width and height.
+func marshalRLETiles(colors []Color, width, height uint16, tileSize int) ([]byte, error) {
+	if len(colors) != int(width)*int(height) {
+		return nil, fmt.Errorf("encoding has %d colors for a %dx%d rectangle", len(colors), width, height)
+	}
+	if len(colors) == 0 {
+		return []byte{}, nil
+	}
+
+	buf := NewBuffer(nil)
+	size, offset := colors[0].pf.cpixel()
+	for ty := 0; ty < int(height); ty += tileSize {
+		for tx := 0; tx < int(width); tx += tileSize {
+			tile, err := newPixelTile(colors, int(width), tx, ty, tileSize)
+			if err != nil {
+				return nil, err
+			}
+			for i, p := range tile.pixels {
+				tile.pixels[i] = p[offset : offset+size]
+			}
+			if err := tile.marshalRLE(buf); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalRLE writes the smallest TRLE/ZRLE subencoding of the tile to buf. The
+// pixel values of the tile must already be in CPIXEL form.
+func (t *pixelTile) marshalRLE(buf *Buffer) error {
+	// Determine the palette and the runs of identical pixel values.
+	var (
+		palette [][]byte
+		runs    []rleRun
+	)
+	indices := map[string]int{}
+	for _, p := range t.pixels {
+		idx, ok := indices[string(p)]
+		if !ok {
+			idx = len(palette)
+			indices[string(p)] = idx
+			palette = append(palette, p)
+		}
+		if n := len(runs); n > 0 && runs[n-1].idx == idx {
+			runs[n-1].length++
+			continue
+		}
+		runs = append(runs, rleRun{idx, 1})
+	}
+
+	size := len(palette[0])
+	if len(palette) == 1 {
+		if err := buf.WriteByte(rleSolid); err != nil {
+			return err
+		}
+		return buf.Write(palette[0])
+	}
+
+	// Choose the subencoding that results in the least data.
+	subenc, best := uint8(rleRaw), len(t.pixels)*size
+	plainLen := 0
+	for _, r := range runs {
+		plainLen += size + runLengthLen(r.length)
+	}
+	if plainLen < best {
+		subenc, best = rlePlain, plainLen
+	}
+	if len(palette) <= rleMaxPaletteSize {
+		paletteLen := len(palette) * size
+		for _, r := range runs {
+			paletteLen++
+			if r.length > 1 {
+				paletteLen += runLengthLen(r.length)
+			}
+		}
+		if paletteLen < best {
+			subenc, best = uint8(len(palette)+128), paletteLen
+		}
+	}
+	if len(palette) <= rleMaxPackedSize {
+		packedLen := len(palette)*size + t.h*((t.w*packedPaletteBits(len(palette))+7)/8)
+		if packedLen < best {
+			subenc = uint8(len(palette))
+		}
+	}
+
+	if err := buf.WriteByte(subenc); err != nil {
+		return err
+	}
+	switch {
+	case subenc == rleRaw:
+		for _, p := range t.pixels {
+			if err := buf.Write(p); err != nil {
+				return err
+			}
+		}
+
+	case subenc <= rleMaxPackedSize:
+		for _, p := range palette {
+			if err := buf.Write(p); err != nil {
+				return err
+			}
+		}
+		bits := packedPaletteBits(len(palette))
+		for y := 0; y < t.h; y++ {
+			row := make([]byte, (t.w*bits+7)/8)
+			for x := 0; x < t.w; x++ {
+				bit := x * bits
+				row[bit/8] |= uint8(indices[string(t.at(x, y))] << (8 - bits - bit%8))
+			}
+			if err := buf.Write(row); err != nil {
+				return err
+			}
+		}
+
+	case subenc == rlePlain:
+		for _, r := range runs {
+			if err := buf.Write(palette[r.idx]); err != nil {
+				return err
+			}
+			if err := writeRunLength(buf, r.length); err != nil {
+				return err
+			}
+		}
+
+	default: // Palette RLE.
+		for _, p := range palette {
+			if err := buf.Write(p); err != nil {
+				return err
+			}
+		}
+		for _, r := range runs {
+			if r.length == 1 {
+				if err := buf.WriteByte(uint8(r.idx)); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := buf.WriteByte(uint8(r.idx) | 0x80); err != nil {
+				return err
+			}
+			if err := writeRunLength(buf, r.length); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rleRun describes a run of identical pixel values within a tile.
+type rleRun struct {
+	idx    int // Palette index of the pixel value.
+	length int
+}
+
+// runLengthLen returns the number of bytes needed to represent run length n.
+func runLengthLen(n int) int { return (n-1)/255 + 1 }
+
+// writeRunLength writes the run length n to buf.
+func writeRunLength(buf *Buffer, n int) error {
+	n--
+	for ; n >= 255; n -= 255 {
+		if err := buf.WriteByte(255); err != nil {
+			return err
+		}
+	}
+	return buf.WriteByte(uint8(n))
+}
+
+// readColor reads a single pixel value in the pixel format of the connection.
+func (c *ClientConn) readColor() (Color, error) {
+	colors, err := c.readColors(1)
+	if err != nil {
+		return Color{}, err
+	}
+	return colors[0], nil
+}
+
+// readColors reads n pixel values in the pixel format of the connection.
+func (c *ClientConn) readColors(n int) ([]Color, error) {
+	return c.readPixels(c.reader(), n)
+}
+
+// readPixels reads n pixel values in the pixel format of the connection from r.
+func (c *ClientConn) readPixels(r io.Reader, n int) ([]Color, error) {
+	bytesPerPixel := int(c.pixelFormat.BPP / 8)
+	data := make([]uint8, n*bytesPerPixel)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return c.unmarshalColors(data, bytesPerPixel, 0)
+}
+
+// readCPixels reads n compressed pixel values (CPIXEL) from r.
+func (c *ClientConn) readCPixels(r io.Reader, n int) ([]Color, error) {
+	size, offset := c.pixelFormat.cpixel()
+	data := make([]uint8, n*size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return c.unmarshalColors(data, size, offset)
+}
+
+// unmarshalColors returns the colors of the pixel values in data. Each value
+// is size bytes long, and is placed at offset within the bytes of a full
+// pixel before being unmarshaled.
+func (c *ClientConn) unmarshalColors(data []byte, size, offset int) ([]Color, error) {
+	if size == 0 {
+		return []Color{}, nil
+	}
+	pixel := make([]byte, c.pixelFormat.BPP/8)
+	colors := make([]Color, len(data)/size)
+	for i := range colors {
+		copy(pixel[offset:], data[i*size:(i+1)*size])
+		color := NewColor(&c.pixelFormat, &c.colorMap)
+		if err := color.Unmarshal(pixel); err != nil {
+			return nil, err
+		}
+		colors[i] = *color
+	}
+	return colors, nil
+}
+
+// zlibStream inflates a zlib stream that spans multiple rectangles, as used
+// by the ZRLE, Tight and Zlib encodings. The stream is created by the first
+// rectangle, and lives for the lifetime of the connection.
+type zlibStream struct {
+	in bytes.Buffer  // Compressed data not yet consumed.
+	r  io.ReadCloser // Decompressor; nil until the stream starts.
+}
+
+// decompress appends the compressed data of a rectangle to the stream, and
+// returns a reader of the uncompressed data.
+func (z *zlibStream) decompress(data []byte) (io.Reader, error) {
+	z.in.Write(data)
+	if z.r == nil {
+		r, err := zlib.NewReader(&z.in)
+		if err != nil {
+			return nil, err
+		}
+		z.r = r
+	}
+	return z.r, nil
+}
+
+// reset discards the stream, so that the next rectangle starts a new one.
+func (z *zlibStream) reset() {
+	z.in.Reset()
+	z.r = nil
+}
+
+// zlibMaxLength returns the largest compressed length of n bytes of data
+// accepted by ClientConn. It allows for the overhead of deflate blocks, which
+// is below an eighth of the data, and of the zlib header and flushes.
+func zlibMaxLength(n int64) int64 {
+	return n + n/8 + 1024
+}
+
+// zlibWriter deflates a zlib stream that spans multiple rectangles. It is the
+// server side counterpart of zlibStream.
+type zlibWriter struct {
+	out bytes.Buffer // Compressed data of the current rectangle.
+	w   *zlib.Writer // Compressor; nil until the stream starts.
+}
+
+// compress appends the data of a rectangle to the stream, and returns the
+// compressed data, which is flushed so that it can be decoded on its own.
+func (z *zlibWriter) compress(data []byte) ([]byte, error) {
+	if z.w == nil {
+		z.w = zlib.NewWriter(&z.out)
+	}
+	z.out.Reset()
+	if _, err := z.w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := z.w.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(z.out.Bytes()), nil
+}
+
 //=============================================================================
 // Pseudo-Encodings
 //
-// Rectangles with a "pseudo-encoding" allow a server to send data to the
-// client. The interpretation of the data depends on the pseudo-encoding.
+// Rectangles with a "pseudo-encoding" allow a server to send data to the
+// client. The interpretation of the data depends on the pseudo-encoding.
+//
+// See RFC 6143 §7.8.
+// https://tools.ietf.org/html/rfc6143#section-7.8
+
+//-----------------------------------------------------------------------------
+// Cursor Pseudo-Encoding
+//
+// A client that requests the Cursor pseudo-encoding declares that it is
+// capable of drawing the cursor locally. The server then sends the shape of
+// the cursor when it changes, and stops drawing it into the framebuffer. The
+// position of the rectangle is the hotspot of the cursor.
+//
+// See RFC 6143 §7.8.1.
+// https://tools.ietf.org/html/rfc6143#section-7.8.1
+
+// Cursor describes the shape of the cursor, as sent by the server.
+type Cursor struct {
+	// Hotspot is the position within Image that is located at the pointer.
+	Hotspot image.Point
+	// Image is the shape of the cursor. Pixels outside of the cursor are
+	// transparent. An empty image means that the cursor is hidden.
+	Image *image.NRGBA
+}
+
+// CursorPseudoEncoding represents a cursor shape message from the server.
+type CursorPseudoEncoding struct {
+	// Colors holds the pixels of the cursor, in the same layout as
+	// RawEncoding.
+	Colors []Color
+	// Bitmask holds one bit per pixel, with the most significant bit first,
+	// set where the pixel is part of the cursor. Each row is padded to a
+	// whole number of bytes.
+	Bitmask       []byte
+	hotspot       image.Point
+	width, height uint16
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*CursorPseudoEncoding)(nil)
+
+// NewCursorPseudoEncoding returns a CursorPseudoEncoding for a cursor of the
+// given width and height.
+func NewCursorPseudoEncoding(colors []Color, bitmask []byte, width, height uint16) *CursorPseudoEncoding {
+	return &CursorPseudoEncoding{Colors: colors, Bitmask: bitmask, width: width, height: height}
+}
+
+// Marshal implements the Marshaler interface.
+func (e *CursorPseudoEncoding) Marshal() ([]byte, error) {
+	if len(e.Colors) != int(e.width)*int(e.height) {
+		return nil, fmt.Errorf("encoding has %d colors for a %dx%d cursor", len(e.Colors), e.width, e.height)
+	}
+	if len(e.Bitmask) != cursorMaskLen(e.width, e.height) {
+		return nil, fmt.Errorf("encoding has a %d byte bitmask for a %dx%d cursor", len(e.Bitmask), e.width, e.height)
+	}
+	buf := NewBuffer(nil)
+	for _, c := range e.Colors {
+		bytes, err := c.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		if err := buf.Write(bytes); err != nil {
+			return nil, err
+		}
+	}
+	if err := buf.Write(e.Bitmask); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Read implements the Encoding interface.
+func (*CursorPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	colors, err := c.readColors(rect.Area())
+	if err != nil {
+		return nil, err
+	}
+	bitmask := make([]uint8, cursorMaskLen(rect.Width, rect.Height))
+	if err := c.receive(bitmask); err != nil {
+		return nil, err
+	}
+
+	e := &CursorPseudoEncoding{
+		Colors:  colors,
+		Bitmask: bitmask,
+		hotspot: image.Pt(int(rect.X), int(rect.Y)),
+		width:   rect.Width,
+		height:  rect.Height,
+	}
+	c.setCursor(e.Cursor())
+	return e, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*CursorPseudoEncoding) String() string { return "CursorPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*CursorPseudoEncoding) Type() encodings.Encoding { return encodings.CursorPseudo }
+
+// Cursor returns the cursor described by the encoding.
+func (e *CursorPseudoEncoding) Cursor() *Cursor {
+	img := image.NewNRGBA(image.Rect(0, 0, int(e.width), int(e.height)))
+	for i, c := range e.Colors {
+		x, y := i%int(e.width), i/int(e.width)
+		if !cursorMaskBit(e.Bitmask, e.width, x, y) {
+			continue
+		}
+		r, g, b := c.rgb8()
+		img.SetNRGBA(x, y, color.NRGBA{r, g, b, 0xff})
+	}
+	return &Cursor{Hotspot: e.hotspot, Image: img}
+}
+
+//-----------------------------------------------------------------------------
+// XCursor Pseudo-Encoding
+//
+// The XCursor pseudo-encoding is an alternative to the Cursor pseudo-encoding,
+// which describes the shape of the cursor with two colors, in the manner of
+// the X Window System.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#x-cursor-pseudo-encoding
+
+// XCursorPseudoEncoding represents a two color cursor shape message from the
+// server.
+type XCursorPseudoEncoding struct {
+	Primary, Secondary color.RGBA
+	// Bitmap holds one bit per pixel, laid out as for Bitmask, set where the
+	// pixel has the primary color rather than the secondary color.
+	Bitmap []byte
+	// Bitmask holds one bit per pixel, with the most significant bit first,
+	// set where the pixel is part of the cursor. Each row is padded to a
+	// whole number of bytes.
+	Bitmask       []byte
+	hotspot       image.Point
+	width, height uint16
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*XCursorPseudoEncoding)(nil)
+
+// NewXCursorPseudoEncoding returns an XCursorPseudoEncoding for a cursor of the
+// given width and height.
+func NewXCursorPseudoEncoding(primary, secondary color.RGBA, bitmap, bitmask []byte, width, height uint16) *XCursorPseudoEncoding {
+	return &XCursorPseudoEncoding{
+		Primary:   primary,
+		Secondary: secondary,
+		Bitmap:    bitmap,
+		Bitmask:   bitmask,
+		width:     width,
+		height:    height,
+	}
+}
+
+// Marshal implements the Marshaler interface.
+func (e *XCursorPseudoEncoding) Marshal() ([]byte, error) {
+	if e.width == 0 || e.height == 0 {
+		return []byte{}, nil
+	}
+	maskLen := cursorMaskLen(e.width, e.height)
+	if len(e.Bitmap) != maskLen || len(e.Bitmask) != maskLen {
+		return nil, fmt.Errorf("bitmap and bitmask must be %d bytes for a %dx%d cursor", maskLen, e.width, e.height)
+	}
+	buf := NewBuffer(nil)
+	colors := []uint8{e.Primary.R, e.Primary.G, e.Primary.B, e.Secondary.R, e.Secondary.G, e.Secondary.B}
+	for _, data := range [][]byte{colors, e.Bitmap, e.Bitmask} {
+		if err := buf.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Read implements the Encoding interface.
+func (*XCursorPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	e := &XCursorPseudoEncoding{
+		hotspot: image.Pt(int(rect.X), int(rect.Y)),
+		width:   rect.Width,
+		height:  rect.Height,
+	}
+	if rect.Area() > 0 {
+		var colors [6]uint8
+		if err := c.receive(&colors); err != nil {
+			return nil, err
+		}
+		e.Primary = color.RGBA{colors[0], colors[1], colors[2], 0xff}
+		e.Secondary = color.RGBA{colors[3], colors[4], colors[5], 0xff}
+
+		maskLen := cursorMaskLen(rect.Width, rect.Height)
+		e.Bitmap = make([]uint8, maskLen)
+		e.Bitmask = make([]uint8, maskLen)
+		if err := c.receive(e.Bitmap); err != nil {
+			return nil, err
+		}
+		if err := c.receive(e.Bitmask); err != nil {
+			return nil, err
+		}
+	}
+	c.setCursor(e.Cursor())
+	return e, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*XCursorPseudoEncoding) String() string { return "XCursorPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*XCursorPseudoEncoding) Type() encodings.Encoding { return encodings.XCursorPseudo }
+
+// Cursor returns the cursor described by the encoding.
+func (e *XCursorPseudoEncoding) Cursor() *Cursor {
+	img := image.NewNRGBA(image.Rect(0, 0, int(e.width), int(e.height)))
+	for y := 0; y < int(e.height); y++ {
+		for x := 0; x < int(e.width); x++ {
+			if !cursorMaskBit(e.Bitmask, e.width, x, y) {
+				continue
+			}
+			c := e.Secondary
+			if cursorMaskBit(e.Bitmap, e.width, x, y) {
+				c = e.Primary
+			}
+			img.SetNRGBA(x, y, color.NRGBA{c.R, c.G, c.B, 0xff})
+		}
+	}
+	return &Cursor{Hotspot: e.hotspot, Image: img}
+}
+
+// cursorMaskLen returns the length in bytes of a cursor bitmask or bitmap.
+func cursorMaskLen(width, height uint16) int {
+	return (int(width) + 7) / 8 * int(height)
+}
+
+// cursorMaskBit returns whether the bit of pixel (x, y) is set in a cursor
+// bitmask or bitmap of the given width.
+func cursorMaskBit(mask []byte, width uint16, x, y int) bool {
+	i := y*((int(width)+7)/8) + x/8
+	return i < len(mask) && mask[i]&(0x80>>(x%8)) != 0
+}
+
+//-----------------------------------------------------------------------------
+// DesktopSize Pseudo-Encoding
+//
+// When a client requests DesktopSize pseudo-encoding, it is indicating to the
+// server that it can handle changes to the framebuffer size. If this encoding
+// received, the client must resize its framebuffer, and drop all existing
+// information stored in the framebuffer.
+//
+// See RFC 6143 §7.8.2.
+// https://tools.ietf.org/html/rfc6143#section-7.8.2
+
+// DesktopSizePseudoEncoding represents a desktop size message from the server.
+type DesktopSizePseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*DesktopSizePseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *DesktopSizePseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (*DesktopSizePseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	c.fbWidth = rect.Width
+	c.fbHeight = rect.Height
+
+	return &DesktopSizePseudoEncoding{}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (e *DesktopSizePseudoEncoding) String() string { return "DesktopSizePseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*DesktopSizePseudoEncoding) Type() encodings.Encoding { return encodings.DesktopSizePseudo }
+
+//-----------------------------------------------------------------------------
+// ExtendedDesktopSize Pseudo-Encoding
+//
+// The ExtendedDesktopSize pseudo-encoding extends DesktopSize with a layout
+// of the screens that make up the framebuffer, and reports the result of
+// SetDesktopSize requests. The x-position of the rectangle holds the reason
+// for the change, and the y-position its status.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#extendeddesktopsize-pseudo-encoding
+
+// DesktopSizeReason is the reason for an ExtendedDesktopSize update.
+type DesktopSizeReason uint16
+
+// DesktopSizeReason values.
+const (
+	DesktopSizeServer      DesktopSizeReason = iota // Changed by the server.
+	DesktopSizeClient                               // Requested by this client.
+	DesktopSizeOtherClient                          // Requested by another client.
+)
+
+// DesktopSizeStatus is the result of a SetDesktopSize request.
+type DesktopSizeStatus uint16
+
+// DesktopSizeStatus values.
+const (
+	DesktopSizeOK             DesktopSizeStatus = iota // No error.
+	DesktopSizeProhibited                              // Resize is administratively prohibited.
+	DesktopSizeOutOfResources                          // Out of resources.
+	DesktopSizeInvalidLayout                           // Invalid screen layout.
+)
+
+// String implements the fmt.Stringer interface.
+func (s DesktopSizeStatus) String() string {
+	switch s {
+	case DesktopSizeOK:
+		return "no error"
+	case DesktopSizeProhibited:
+		return "resize is administratively prohibited"
+	case DesktopSizeOutOfResources:
+		return "out of resources"
+	case DesktopSizeInvalidLayout:
+		return "invalid screen layout"
+	}
+	return fmt.Sprintf("unknown status %d", uint16(s))
+}
+
+// Screen describes one screen of the framebuffer.
+type Screen struct {
+	ID            uint32 // id
+	X, Y          uint16 // x-, y-position
+	Width, Height uint16 // width, height
+	Flags         uint32 // flags
+}
+
+// ExtendedDesktopSizePseudoEncoding represents an extended desktop size
+// message from the server.
+type ExtendedDesktopSizePseudoEncoding struct {
+	Reason  DesktopSizeReason
+	Status  DesktopSizeStatus
+	Screens []Screen
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ExtendedDesktopSizePseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface. The reason and status are not
+// included, as they are carried by the position of the rectangle.
+func (e *ExtendedDesktopSizePseudoEncoding) Marshal() ([]byte, error) {
+	return marshalScreens(e.Screens)
+}
+
+// Read implements the Encoding interface.
+func (*ExtendedDesktopSizePseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	screens, err := c.readScreens()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &ExtendedDesktopSizePseudoEncoding{
+		Reason:  DesktopSizeReason(rect.X),
+		Status:  DesktopSizeStatus(rect.Y),
+		Screens: screens,
+	}
+	if e.Status == DesktopSizeOK {
+		c.setFramebufferWidth(rect.Width)
+		c.setFramebufferHeight(rect.Height)
+		c.setScreens(screens)
+	}
+	return e, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*ExtendedDesktopSizePseudoEncoding) String() string {
+	return "ExtendedDesktopSizePseudoEncoding"
+}
+
+// Type implements the Encoding interface.
+func (*ExtendedDesktopSizePseudoEncoding) Type() encodings.Encoding {
+	return encodings.ExtendedDesktopSizePseudo
+}
+
+// Err returns an error if the update reports a failed SetDesktopSize request
+// of this client, and nil otherwise.
+func (e *ExtendedDesktopSizePseudoEncoding) Err() error {
+	if e.Reason != DesktopSizeClient || e.Status == DesktopSizeOK {
+		return nil
+	}
+	return fmt.Errorf("desktop resize failed: %s", e.Status)
+}
+
+// DesktopSizeResult is the result of a SetDesktopSize request of the client,
+// as carried by an ExtendedDesktopSize rectangle of a FramebufferUpdate.
+type DesktopSizeResult struct {
+	Status        DesktopSizeStatus
+	Width, Height uint16
+	Screens       []Screen
+}
+
+// Err returns an error if the request failed, and nil otherwise.
+func (m *DesktopSizeResult) Err() error {
+	if m.Status == DesktopSizeOK {
+		return nil
+	}
+	return fmt.Errorf("desktop resize failed: %s", m.Status)
+}
+
+// DesktopSizeResults returns the results of SetDesktopSize requests of the
+// client carried by the update. Changes of the size requested by the server or
+// by other clients are not included.
+func (m *FramebufferUpdate) DesktopSizeResults() []*DesktopSizeResult {
+	var results []*DesktopSizeResult
+	for _, r := range m.Rects {
+		if e, ok := r.Enc.(*ExtendedDesktopSizePseudoEncoding); ok && e.Reason == DesktopSizeClient {
+			results = append(results, &DesktopSizeResult{e.Status, r.Width, r.Height, e.Screens})
+		}
+	}
+	return results
+}
+
+// readScreens reads a screen layout, as sent by ExtendedDesktopSize.
+func (c *ClientConn) readScreens() ([]Screen, error) {
+	var msg struct {
+		NumScreens uint8   // number-of-screens
+		_          [3]byte // padding
+	}
+	if err := c.receive(&msg); err != nil {
+		return nil, err
+	}
+	screens := make([]Screen, msg.NumScreens)
+	if err := c.receive(screens); err != nil {
+		return nil, err
+	}
+	return screens, nil
+}
+
+// marshalScreens returns the wire format of a screen layout, as sent by
+// ExtendedDesktopSize.
+func marshalScreens(screens []Screen) ([]byte, error) {
+	if len(screens) > 255 {
+		return nil, fmt.Errorf("too many screens: %d", len(screens))
+	}
+	buf := NewBuffer(nil)
+	if err := buf.Write([4]uint8{uint8(len(screens))}); err != nil {
+		return nil, err
+	}
+	if err := buf.Write(screens); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//-----------------------------------------------------------------------------
+// LastRect Pseudo-Encoding
+//
+// A server that supports the LastRect pseudo-encoding may send a
+// FramebufferUpdate with 0xFFFF rectangles, when it does not know the number
+// of rectangles in advance. A LastRect rectangle then marks the end of the
+// update.
 //
-// See RFC 6143 §7.8.
-// https://tools.ietf.org/html/rfc6143#section-7.8
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#lastrect-pseudo-encoding
+
+// LastRectPseudoEncoding represents the end of a framebuffer update.
+type LastRectPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*LastRectPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*LastRectPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (*LastRectPseudoEncoding) Read(*ClientConn, *Rectangle) (Encoding, error) {
+	return &LastRectPseudoEncoding{}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*LastRectPseudoEncoding) String() string { return "LastRectPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*LastRectPseudoEncoding) Type() encodings.Encoding { return encodings.LastRectPseudo }
 
 //-----------------------------------------------------------------------------
-// DesktopSize Pseudo-Encoding
+// PointerPos Pseudo-Encoding
 //
-// When a client requests DesktopSize pseudo-encoding, it is indicating to the
-// server that it can handle changes to the framebuffer size. If this encoding
-// received, the client must resize its framebuffer, and drop all existing
-// information stored in the framebuffer.
+// The PointerPos pseudo-encoding allows the server to report the position of
+// the pointer, which is held in the position of the rectangle, for example
+// when it was moved by another client.
 //
-// See RFC 6143 §7.8.2.
-// https://tools.ietf.org/html/rfc6143#section-7.8.2
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#cursor-position-pseudo-encoding
 
-// DesktopSizePseudoEncoding represents a desktop size message from the server.
-type DesktopSizePseudoEncoding struct{}
+// PointerPosPseudoEncoding represents a pointer position message from the
+// server.
+type PointerPosPseudoEncoding struct{}
 
 // Verify that interfaces are honored.
-var _ Encoding = (*DesktopSizePseudoEncoding)(nil)
+var _ Encoding = (*PointerPosPseudoEncoding)(nil)
 
 // Marshal implements the Marshaler interface.
-func (e *DesktopSizePseudoEncoding) Marshal() ([]byte, error) {
+func (*PointerPosPseudoEncoding) Marshal() ([]byte, error) {
 	return []byte{}, nil
 }
 
 // Read implements the Encoding interface.
-func (*DesktopSizePseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
-	c.fbWidth = rect.Width
-	c.fbHeight = rect.Height
+func (*PointerPosPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	c.setPointerPos(image.Pt(int(rect.X), int(rect.Y)))
+	return &PointerPosPseudoEncoding{}, nil
+}
 
-	return &DesktopSizePseudoEncoding{}, nil
+// String implements the fmt.Stringer interface.
+func (*PointerPosPseudoEncoding) String() string { return "PointerPosPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*PointerPosPseudoEncoding) Type() encodings.Encoding { return encodings.PointerPosPseudo }
+
+//-----------------------------------------------------------------------------
+// DesktopName Pseudo-Encoding
+//
+// The DesktopName pseudo-encoding allows the server to change the name of the
+// desktop during a session.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#desktopname-pseudo-encoding
+
+// desktopNameMaxLength is the longest desktop name accepted by ClientConn, in
+// bytes.
+const desktopNameMaxLength = 1 << 20
+
+// DesktopNamePseudoEncoding represents a desktop name message from the server.
+type DesktopNamePseudoEncoding struct {
+	Name string // UTF-8 encoded.
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*DesktopNamePseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *DesktopNamePseudoEncoding) Marshal() ([]byte, error) {
+	buf := NewBuffer(nil)
+	if err := buf.Write(uint32(len(e.Name))); err != nil {
+		return nil, err
+	}
+	if err := buf.Write([]byte(e.Name)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Read implements the Encoding interface.
+func (*DesktopNamePseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	var length uint32
+	if err := c.receive(&length); err != nil {
+		return nil, err
+	}
+	if length > desktopNameMaxLength {
+		return nil, fmt.Errorf("desktop name of %d bytes is too long", length)
+	}
+	name := make([]uint8, length)
+	if err := c.receive(name); err != nil {
+		return nil, err
+	}
+	c.setDesktopName(string(name))
+	return &DesktopNamePseudoEncoding{string(name)}, nil
 }
 
 // String implements the fmt.Stringer interface.
-func (e *DesktopSizePseudoEncoding) String() string { return "DesktopSizePseudoEncoding" }
+func (*DesktopNamePseudoEncoding) String() string { return "DesktopNamePseudoEncoding" }
 
 // Type implements the Encoding interface.
-func (*DesktopSizePseudoEncoding) Type() encodings.Encoding { return encodings.DesktopSizePseudo }
+func (*DesktopNamePseudoEncoding) Type() encodings.Encoding { return encodings.DesktopNamePseudo }
+
+//-----------------------------------------------------------------------------
+// ContinuousUpdates and Fence Pseudo-Encodings
+//
+// These pseudo-encodings are never sent by the server. Instead, a client
+// includes them in SetEncodings to declare support for the
+// EnableContinuousUpdates and Fence messages. A server that supports them
+// replies with an EndOfContinuousUpdates or a Fence message respectively.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#continuousupdates-pseudo-encoding
+// and https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#fence-pseudo-encoding
+
+// ContinuousUpdatesPseudoEncoding declares support for continuous updates.
+type ContinuousUpdatesPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ContinuousUpdatesPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*ContinuousUpdatesPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (e *ContinuousUpdatesPseudoEncoding) Read(*ClientConn, *Rectangle) (Encoding, error) {
+	return nil, errRequestOnlyPseudoEncoding(e.Type())
+}
+
+// String implements the fmt.Stringer interface.
+func (*ContinuousUpdatesPseudoEncoding) String() string { return "ContinuousUpdatesPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*ContinuousUpdatesPseudoEncoding) Type() encodings.Encoding {
+	return encodings.ContinuousUpdatesPseudo
+}
+
+// FencePseudoEncoding declares support for fences.
+type FencePseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*FencePseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*FencePseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (e *FencePseudoEncoding) Read(*ClientConn, *Rectangle) (Encoding, error) {
+	return nil, errRequestOnlyPseudoEncoding(e.Type())
+}
+
+// String implements the fmt.Stringer interface.
+func (*FencePseudoEncoding) String() string { return "FencePseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*FencePseudoEncoding) Type() encodings.Encoding { return encodings.FencePseudo }
+
+//-----------------------------------------------------------------------------
+// XVP Pseudo-Encoding
+//
+// This pseudo-encoding is never sent by the server. Instead, a client includes
+// it in SetEncodings to declare support for the XVP messages. A server that
+// supports them replies with a ServerXVP message with the XVPInit code.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#xvp-pseudo-encoding
+
+// XVPPseudoEncoding declares support for XVP messages.
+type XVPPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*XVPPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*XVPPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (e *XVPPseudoEncoding) Read(*ClientConn, *Rectangle) (Encoding, error) {
+	return nil, errRequestOnlyPseudoEncoding(e.Type())
+}
+
+// String implements the fmt.Stringer interface.
+func (*XVPPseudoEncoding) String() string { return "XVPPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*XVPPseudoEncoding) Type() encodings.Encoding { return encodings.XVPPseudo }
+
+//-----------------------------------------------------------------------------
+// QEMU Extended Key Event Pseudo-Encoding
+//
+// A client that requests the QEMU Extended Key Event pseudo-encoding declares
+// that it can send key events with scan codes. A server that supports them
+// replies with an empty rectangle of this pseudo-encoding.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#qemu-extended-key-event-pseudo-encoding
+
+// QEMUExtendedKeyEventPseudoEncoding represents the acknowledgement of
+// extended key events by the server.
+type QEMUExtendedKeyEventPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*QEMUExtendedKeyEventPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*QEMUExtendedKeyEventPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (*QEMUExtendedKeyEventPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	return &QEMUExtendedKeyEventPseudoEncoding{}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*QEMUExtendedKeyEventPseudoEncoding) String() string {
+	return "QEMUExtendedKeyEventPseudoEncoding"
+}
+
+// Type implements the Encoding interface.
+func (*QEMUExtendedKeyEventPseudoEncoding) Type() encodings.Encoding {
+	return encodings.QEMUExtendedKeyEventPseudo
+}
+
+//-----------------------------------------------------------------------------
+// QEMU Audio Pseudo-Encoding
+//
+// A client that requests the QEMU Audio pseudo-encoding declares that it can
+// receive audio. A server that supports it replies with an empty rectangle of
+// this pseudo-encoding.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#qemu-audio-pseudo-encoding
+
+// QEMUAudioPseudoEncoding represents the acknowledgement of audio by the
+// server.
+type QEMUAudioPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*QEMUAudioPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*QEMUAudioPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (*QEMUAudioPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	return &QEMUAudioPseudoEncoding{}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*QEMUAudioPseudoEncoding) String() string { return "QEMUAudioPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*QEMUAudioPseudoEncoding) Type() encodings.Encoding { return encodings.QEMUAudioPseudo }
+
+//-----------------------------------------------------------------------------
+// Quality and Compression Level Pseudo-Encodings
+//
+// These pseudo-encodings are never sent by the server. Instead, a client
+// includes one of each in SetEncodings to tell the server how to trade CPU
+// usage against bandwidth in lossy and compressed encodings, such as Tight.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#pseudo-encodings
+
+// errRequestOnlyPseudoEncoding is returned when a server sends a rectangle
+// with a pseudo-encoding that is only ever sent by the client.
+func errRequestOnlyPseudoEncoding(e encodings.Encoding) error {
+	return fmt.Errorf("unexpected rectangle with %s pseudo-encoding", e)
+}
+
+// JPEGQualityLevelPseudoEncoding requests a JPEG quality level from 0 (lowest)
+// to 9 (highest). Levels above 9 are treated as 9.
+type JPEGQualityLevelPseudoEncoding struct {
+	Level uint8
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*JPEGQualityLevelPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*JPEGQualityLevelPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (e *JPEGQualityLevelPseudoEncoding) Read(*ClientConn, *Rectangle) (Encoding, error) {
+	return nil, errRequestOnlyPseudoEncoding(e.Type())
+}
+
+// String implements the fmt.Stringer interface.
+func (e *JPEGQualityLevelPseudoEncoding) String() string {
+	return fmt.Sprintf("JPEGQualityLevelPseudoEncoding(%d)", min(e.Level, 9))
+}
+
+// Type implements the Encoding interface.
+func (e *JPEGQualityLevelPseudoEncoding) Type() encodings.Encoding {
+	return encodings.JPEGQualityLevel0Pseudo + encodings.Encoding(min(e.Level, 9))
+}
+
+// CompressionLevelPseudoEncoding requests a compression level from 0 (fastest)
+// to 9 (best compression). Levels above 9 are treated as 9.
+type CompressionLevelPseudoEncoding struct {
+	Level uint8
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*CompressionLevelPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*CompressionLevelPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (e *CompressionLevelPseudoEncoding) Read(*ClientConn, *Rectangle) (Encoding, error) {
+	return nil, errRequestOnlyPseudoEncoding(e.Type())
+}
+
+// String implements the fmt.Stringer interface.
+func (e *CompressionLevelPseudoEncoding) String() string {
+	return fmt.Sprintf("CompressionLevelPseudoEncoding(%d)", min(e.Level, 9))
+}
+
+// Type implements the Encoding interface.
+func (e *CompressionLevelPseudoEncoding) Type() encodings.Encoding {
+	return encodings.CompressionLevel0Pseudo + encodings.Encoding(min(e.Level, 9))
+}
+
+// FineQualityLevelPseudoEncoding requests a JPEG quality level from 0 (lowest)
+// to 100 (highest), for servers that support finer grained control than
+// JPEGQualityLevelPseudoEncoding. Levels above 100 are treated as 100.
+type FineQualityLevelPseudoEncoding struct {
+	Level uint8
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*FineQualityLevelPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*FineQualityLevelPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (e *FineQualityLevelPseudoEncoding) Read(*ClientConn, *Rectangle) (Encoding, error) {
+	return nil, errRequestOnlyPseudoEncoding(e.Type())
+}
+
+// String implements the fmt.Stringer interface.
+func (e *FineQualityLevelPseudoEncoding) String() string {
+	return fmt.Sprintf("FineQualityLevelPseudoEncoding(%d)", min(e.Level, 100))
+}
+
+// Type implements the Encoding interface.
+func (e *FineQualityLevelPseudoEncoding) Type() encodings.Encoding {
+	return encodings.FineQualityLevel0Pseudo + encodings.Encoding(min(e.Level, 100))
+}
+
+// Subsampling is a level of chrominance subsampling used by JPEG compression.
+type Subsampling uint8
+
+const (
+	Subsamp1X   Subsampling = iota // No subsampling.
+	Subsamp4X                      // 4:2:0 subsampling.
+	Subsamp2X                      // 4:2:2 subsampling.
+	SubsampGray                    // Grayscale only.
+	Subsamp8X                      // 8:1:0 subsampling.
+	Subsamp16X                     // 16:1:0 subsampling.
+)
+
+// SubsampPseudoEncoding requests a level of JPEG chrominance subsampling.
+// Levels above Subsamp16X are treated as Subsamp16X.
+type SubsampPseudoEncoding struct {
+	Subsampling Subsampling
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*SubsampPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*SubsampPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (e *SubsampPseudoEncoding) Read(*ClientConn, *Rectangle) (Encoding, error) {
+	return nil, errRequestOnlyPseudoEncoding(e.Type())
+}
+
+// String implements the fmt.Stringer interface.
+func (e *SubsampPseudoEncoding) String() string { return "SubsampPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (e *SubsampPseudoEncoding) Type() encodings.Encoding {
+	return encodings.Subsamp1XPseudo + encodings.Encoding(min(e.Subsampling, Subsamp16X))
+}
@@ -11,40 +11,118 @@ func _() {
 	_ = x[Raw-0]
 	_ = x[CopyRect-1]
 	_ = x[RRE-2]
+	_ = x[CoRRE-4]
 	_ = x[Hextile-5]
+	_ = x[Zlib-6]
+	_ = x[Tight-7]
+	_ = x[ZlibHex-8]
 	_ = x[TRLE-15]
 	_ = x[ZRLE-16]
-	_ = x[ColorPseudo - -239]
+	_ = x[TightPNG - -260]
+	_ = x[XCursorPseudo - -240]
+	_ = x[CursorPseudo - -239]
+	_ = x[PointerPosPseudo - -232]
+	_ = x[LastRectPseudo - -224]
 	_ = x[DesktopSizePseudo - -223]
+	_ = x[DesktopNamePseudo - -307]
+	_ = x[ExtendedDesktopSizePseudo - -308]
+	_ = x[XVPPseudo - -309]
+	_ = x[FencePseudo - -312]
+	_ = x[ContinuousUpdatesPseudo - -313]
+	_ = x[QEMUExtendedKeyEventPseudo - -258]
+	_ = x[QEMUAudioPseudo - -259]
+	_ = x[ExtendedClipboardPseudo - -1063131698]
+	_ = x[JPEGQualityLevel0Pseudo - -32]
+	_ = x[JPEGQualityLevel1Pseudo - -31]
+	_ = x[JPEGQualityLevel2Pseudo - -30]
+	_ = x[JPEGQualityLevel3Pseudo - -29]
+	_ = x[JPEGQualityLevel4Pseudo - -28]
+	_ = x[JPEGQualityLevel5Pseudo - -27]
+	_ = x[JPEGQualityLevel6Pseudo - -26]
+	_ = x[JPEGQualityLevel7Pseudo - -25]
+	_ = x[JPEGQualityLevel8Pseudo - -24]
+	_ = x[JPEGQualityLevel9Pseudo - -23]
+	_ = x[CompressionLevel0Pseudo - -256]
+	_ = x[CompressionLevel1Pseudo - -255]
+	_ = x[CompressionLevel2Pseudo - -254]
+	_ = x[CompressionLevel3Pseudo - -253]
+	_ = x[CompressionLevel4Pseudo - -252]
+	_ = x[CompressionLevel5Pseudo - -251]
+	_ = x[CompressionLevel6Pseudo - -250]
+	_ = x[CompressionLevel7Pseudo - -249]
+	_ = x[CompressionLevel8Pseudo - -248]
+	_ = x[CompressionLevel9Pseudo - -247]
+	_ = x[FineQualityLevel0Pseudo - -512]
+	_ = x[FineQualityLevel100Pseudo - -412]
+	_ = x[Subsamp1XPseudo - -768]
+	_ = x[Subsamp4XPseudo - -767]
+	_ = x[Subsamp2XPseudo - -766]
+	_ = x[SubsampGrayPseudo - -765]
+	_ = x[Subsamp8XPseudo - -764]
+	_ = x[Subsamp16XPseudo - -763]
+	_ = x[ColorPseudo - -239]
 }
 
-const (
-	_Encoding_name_0 = "ColorPseudo"
-	_Encoding_name_1 = "DesktopSizePseudo"
-	_Encoding_name_2 = "RawCopyRectRRE"
-	_Encoding_name_3 = "Hextile"
-	_Encoding_name_4 = "TRLEZRLE"
-)
+const _Encoding_name = "ExtendedClipboardPseudoSubsamp1XPseudoSubsamp4XPseudoSubsamp2XPseudoSubsampGrayPseudoSubsamp8XPseudoSubsamp16XPseudoFineQualityLevel0PseudoFineQualityLevel100PseudoContinuousUpdatesPseudoFencePseudoXVPPseudoExtendedDesktopSizePseudoDesktopNamePseudoTightPNGQEMUAudioPseudoQEMUExtendedKeyEventPseudoCompressionLevel0PseudoCompressionLevel1PseudoCompressionLevel2PseudoCompressionLevel3PseudoCompressionLevel4PseudoCompressionLevel5PseudoCompressionLevel6PseudoCompressionLevel7PseudoCompressionLevel8PseudoCompressionLevel9PseudoXCursorPseudoCursorPseudoPointerPosPseudoLastRectPseudoDesktopSizePseudoJPEGQualityLevel0PseudoJPEGQualityLevel1PseudoJPEGQualityLevel2PseudoJPEGQualityLevel3PseudoJPEGQualityLevel4PseudoJPEGQualityLevel5PseudoJPEGQualityLevel6PseudoJPEGQualityLevel7PseudoJPEGQualityLevel8PseudoJPEGQualityLevel9PseudoRawCopyRectRRECoRREHextileZlibTightZlibHexTRLEZRLE"
 
-var (
-	_Encoding_index_2 = [...]uint8{0, 3, 11, 14}
-	_Encoding_index_4 = [...]uint8{0, 4, 8}
-)
+var _Encoding_map = map[Encoding]string{
+	-1063131698: _Encoding_name[0:23],
+	-768:        _Encoding_name[23:38],
+	-767:        _Encoding_name[38:53],
+	-766:        _Encoding_name[53:68],
+	-765:        _Encoding_name[68:85],
+	-764:        _Encoding_name[85:100],
+	-763:        _Encoding_name[100:116],
+	-512:        _Encoding_name[116:139],
+	-412:        _Encoding_name[139:164],
+	-313:        _Encoding_name[164:187],
+	-312:        _Encoding_name[187:198],
+	-309:        _Encoding_name[198:207],
+	-308:        _Encoding_name[207:232],
+	-307:        _Encoding_name[232:249],
+	-260:        _Encoding_name[249:257],
+	-259:        _Encoding_name[257:272],
+	-258:        _Encoding_name[272:298],
+	-256:        _Encoding_name[298:321],
+	-255:        _Encoding_name[321:344],
+	-254:        _Encoding_name[344:367],
+	-253:        _Encoding_name[367:390],
+	-252:        _Encoding_name[390:413],
+	-251:        _Encoding_name[413:436],
+	-250:        _Encoding_name[436:459],
+	-249:        _Encoding_name[459:482],
+	-248:        _Encoding_name[482:505],
+	-247:        _Encoding_name[505:528],
+	-240:        _Encoding_name[528:541],
+	-239:        _Encoding_name[541:553],
+	-232:        _Encoding_name[553:569],
+	-224:        _Encoding_name[569:583],
+	-223:        _Encoding_name[583:600],
+	-32:         _Encoding_name[600:623],
+	-31:         _Encoding_name[623:646],
+	-30:         _Encoding_name[646:669],
+	-29:         _Encoding_name[669:692],
+	-28:         _Encoding_name[692:715],
+	-27:         _Encoding_name[715:738],
+	-26:         _Encoding_name[738:761],
+	-25:         _Encoding_name[761:784],
+	-24:         _Encoding_name[784:807],
+	-23:         _Encoding_name[807:830],
+	0:           _Encoding_name[830:833],
+	1:           _Encoding_name[833:841],
+	2:           _Encoding_name[841:844],
+	4:           _Encoding_name[844:849],
+	5:           _Encoding_name[849:856],
+	6:           _Encoding_name[856:860],
+	7:           _Encoding_name[860:865],
+	8:           _Encoding_name[865:872],
+	15:          _Encoding_name[872:876],
+	16:          _Encoding_name[876:880],
+}
 
 func (i Encoding) String() string {
-	switch {
-	case i == -239:
-		return _Encoding_name_0
-	case i == -223:
-		return _Encoding_name_1
-	case 0 <= i && i <= 2:
-		return _Encoding_name_2[_Encoding_index_2[i]:_Encoding_index_2[i+1]]
-	case i == 5:
-		return _Encoding_name_3
-	case 15 <= i && i <= 16:
-		i -= 15
-		return _Encoding_name_4[_Encoding_index_4[i]:_Encoding_index_4[i+1]]
-	default:
-		return "Encoding(" + strconv.FormatInt(int64(i), 10) + ")"
+	if str, ok := _Encoding_map[i]; ok {
+		return str
 	}
+	return "Encoding(" + strconv.FormatInt(int64(i), 10) + ")"
 }
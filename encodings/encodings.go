@@ -13,9 +13,67 @@ const (
 	Raw               Encoding = 0
 	CopyRect          Encoding = 1
 	RRE               Encoding = 2
+	CoRRE             Encoding = 4
 	Hextile           Encoding = 5
+	Zlib              Encoding = 6
+	Tight             Encoding = 7
+	ZlibHex           Encoding = 8
 	TRLE              Encoding = 15
 	ZRLE              Encoding = 16
-	ColorPseudo       Encoding = -239
+	TightPNG          Encoding = -260
+	XCursorPseudo     Encoding = -240
+	CursorPseudo      Encoding = -239
+	PointerPosPseudo  Encoding = -232
+	LastRectPseudo    Encoding = -224
 	DesktopSizePseudo Encoding = -223
+
+	DesktopNamePseudo         Encoding = -307
+	ExtendedDesktopSizePseudo Encoding = -308
+	XVPPseudo                 Encoding = -309
+	FencePseudo               Encoding = -312
+	ContinuousUpdatesPseudo   Encoding = -313
+
+	QEMUExtendedKeyEventPseudo Encoding = -258
+	QEMUAudioPseudo            Encoding = -259
+
+	ExtendedClipboardPseudo Encoding = -1063131698 // 0xC0A1E5CE
+
+	JPEGQualityLevel0Pseudo Encoding = -32
+	JPEGQualityLevel1Pseudo Encoding = -31
+	JPEGQualityLevel2Pseudo Encoding = -30
+	JPEGQualityLevel3Pseudo Encoding = -29
+	JPEGQualityLevel4Pseudo Encoding = -28
+	JPEGQualityLevel5Pseudo Encoding = -27
+	JPEGQualityLevel6Pseudo Encoding = -26
+	JPEGQualityLevel7Pseudo Encoding = -25
+	JPEGQualityLevel8Pseudo Encoding = -24
+	JPEGQualityLevel9Pseudo Encoding = -23
+
+	CompressionLevel0Pseudo Encoding = -256
+	CompressionLevel1Pseudo Encoding = -255
+	CompressionLevel2Pseudo Encoding = -254
+	CompressionLevel3Pseudo Encoding = -253
+	CompressionLevel4Pseudo Encoding = -252
+	CompressionLevel5Pseudo Encoding = -251
+	CompressionLevel6Pseudo Encoding = -250
+	CompressionLevel7Pseudo Encoding = -249
+	CompressionLevel8Pseudo Encoding = -248
+	CompressionLevel9Pseudo Encoding = -247
+
+	// The fine-grained quality levels 0 to 100 are the range of encodings
+	// from FineQualityLevel0Pseudo to FineQualityLevel100Pseudo.
+	FineQualityLevel0Pseudo   Encoding = -512
+	FineQualityLevel100Pseudo Encoding = -412
+
+	Subsamp1XPseudo   Encoding = -768
+	Subsamp4XPseudo   Encoding = -767
+	Subsamp2XPseudo   Encoding = -766
+	SubsampGrayPseudo Encoding = -765
+	Subsamp8XPseudo   Encoding = -764
+	Subsamp16XPseudo  Encoding = -763
 )
+
+// ColorPseudo is the former name of CursorPseudo.
+//
+// Deprecated: Use CursorPseudo.
+const ColorPseudo = CursorPseudo
@@ -3,10 +3,17 @@ package vnc
 // TODO(kward): Fully test the encodings.
 
 import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"math/rand"
+	"reflect"
 	"testing"
 
 	"github.com/kward/go-vnc/encodings"
 	"github.com/kward/go-vnc/go/operators"
+	"github.com/kward/go-vnc/rfbflags"
 )
 
 func TestEncoding_Marshal(t *testing.T) {
@@ -60,9 +67,746 @@ func TestRawEncoding_Marshal(t *testing.T) {
 
 func TestRawEncoding_Read(t *testing.T) {}
 
+func TestCopyRectEncoding_Type(t *testing.T) {
+	e := &CopyRectEncoding{}
+	if got, want := e.Type(), encodings.CopyRect; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestCopyRectEncoding_Marshal(t *testing.T) {
+	e := &CopyRectEncoding{SX: 258, SY: 3}
+	data, err := e.Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := data, []byte{1, 2, 0, 3}; !operators.EqualSlicesOfByte(got, want) {
+		t.Errorf("incorrect result; got = %v, want = %v", got, want)
+	}
+}
+
+func TestCopyRectEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.encodings = Encodings{&RawEncoding{}, &CopyRectEncoding{}}
+
+	rect := &Rectangle{X: 10, Y: 20, Width: 30, Height: 40, Enc: &CopyRectEncoding{SX: 1, SY: 2}}
+	data, err := rect.Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got := NewRectangle(conn.Encodable)
+	if err := got.Read(conn); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	enc, ok := got.Enc.(*CopyRectEncoding)
+	if !ok {
+		t.Fatalf("incorrect encoding type; got = %T", got.Enc)
+	}
+	if enc.SX != 1 || enc.SY != 2 {
+		t.Errorf("incorrect source position; got = (%d, %d), want = (1, 2)", enc.SX, enc.SY)
+	}
+
+	// The Marshaled rectangle must also round-trip through Unmarshal.
+	var unmarshaled Rectangle
+	if err := unmarshaled.Unmarshal(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := *unmarshaled.Enc.(*CopyRectEncoding), *enc; got != want {
+		t.Errorf("incorrect unmarshaled encoding; got = %v, want = %v", got, want)
+	}
+}
+
+func TestCopyRectEncoding_Apply(t *testing.T) {
+	fb := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	red := color.RGBA{255, 0, 0, 255}
+	fb.Set(0, 0, red)
+	fb.Set(1, 1, red)
+
+	// Overlapping copy of the top-left 3x3 block down and right by one.
+	e := &CopyRectEncoding{SX: 0, SY: 0}
+	e.Apply(fb, &Rectangle{X: 1, Y: 1, Width: 3, Height: 3})
+
+	for _, tt := range []struct {
+		x, y int
+		c    color.RGBA
+	}{
+		{0, 0, red},
+		{1, 1, red},
+		{2, 2, red},
+		{2, 1, color.RGBA{}},
+		{3, 3, color.RGBA{}},
+	} {
+		if got, want := fb.RGBAAt(tt.x, tt.y), tt.c; got != want {
+			t.Errorf("(%d, %d): incorrect color; got = %v, want = %v", tt.x, tt.y, got, want)
+		}
+	}
+}
+
+func TestRREEncoding(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat16bit
+
+	for _, tt := range []struct {
+		desc string
+		enc  Encoding
+		data []byte
+	}{
+		{"rre without subrects",
+			&RREEncoding{},
+			[]byte{0, 0, 0, 0, 0, 1}},
+		{"rre",
+			&RREEncoding{},
+			[]byte{
+				0, 0, 0, 2, // number-of-subrectangles
+				0, 1, // background-pixel-value
+				0, 2, 0, 1, 0, 2, 0, 3, 0, 4, // subrect 0
+				1, 3, 0, 5, 0, 6, 1, 7, 0, 8, // subrect 1
+			}},
+		{"corre",
+			&CoRREEncoding{},
+			[]byte{
+				0, 0, 0, 2, // number-of-subrectangles
+				0, 1, // background-pixel-value
+				0, 2, 1, 2, 3, 4, // subrect 0
+				1, 3, 5, 6, 7, 8, // subrect 1
+			}},
+	} {
+		mockConn.Reset()
+		if err := conn.send(tt.data); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+
+		enc, err := tt.enc.Read(conn, &Rectangle{Width: 16, Height: 16})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if got, want := enc.Type(), tt.enc.Type(); got != want {
+			t.Errorf("%s: incorrect encoding; got = %s, want = %s", tt.desc, got, want)
+		}
+
+		var subrects []RRESubrectangle
+		switch e := enc.(type) {
+		case *RREEncoding:
+			subrects = e.Subrects
+		case *CoRREEncoding:
+			subrects = e.Subrects
+		}
+		if len(subrects) == 2 {
+			if got, want := subrects[1].Color.R, uint16(0x103); got != want {
+				t.Errorf("%s: incorrect subrect color; got = %#x, want = %#x", tt.desc, got, want)
+			}
+			if got, want := subrects[1].Height, uint16(8); got != want {
+				t.Errorf("%s: incorrect subrect height; got = %d, want = %d", tt.desc, got, want)
+			}
+		}
+
+		data, err := enc.Marshal()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if got, want := data, tt.data; !operators.EqualSlicesOfByte(got, want) {
+			t.Errorf("%s: incorrect result; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestRREEncoding_ReadTooManySubrects(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat16bit
+
+	for _, enc := range []Encoding{&RREEncoding{}, &CoRREEncoding{}} {
+		mockConn.Reset()
+		if err := conn.send([]byte{0xff, 0xff, 0xff, 0xff, 0, 1}); err != nil {
+			t.Fatalf("%s: unexpected error: %s", enc, err)
+		}
+		if _, err := enc.Read(conn, &Rectangle{Width: 16, Height: 16}); err == nil {
+			t.Errorf("%s: expected error", enc)
+		}
+	}
+}
+
+func TestCoRREEncoding_MarshalTooLarge(t *testing.T) {
+	c := Color{&PixelFormat16bit, &ColorMap{}, 0, 0, 0, 0}
+	e := &CoRREEncoding{c, []RRESubrectangle{{c, 0, 0, 256, 1}}}
+	if _, err := e.Marshal(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// equalColors compares the RGB values of two slices of colors.
+func equalColors(x, y []Color) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i].R != y[i].R || x[i].G != y[i].G || x[i].B != y[i].B {
+			return false
+		}
+	}
+	return true
+}
+
+// randomColors returns n random colors, using at most numColors unique values.
+func randomColors(r *rand.Rand, pf *PixelFormat, n, numColors int) []Color {
+	palette := make([]Color, numColors)
+	for i := range palette {
+		pixel := make([]byte, pf.BPP/8)
+		r.Read(pixel)
+		c := NewColor(pf, &ColorMap{})
+		c.Unmarshal(pixel)
+		palette[i] = *c
+	}
+	colors := make([]Color, n)
+	for i := range colors {
+		colors[i] = palette[r.Intn(numColors)]
+	}
+	return colors
+}
+
+func TestHextileEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat16bit
+
+	// A 20x2 rectangle, covered by a 16x2 and a 4x2 tile.
+	data := []byte{
+		// Tile 0: background, foreground and a single subrect.
+		hextileBackgroundSpecified | hextileForegroundSpecified | hextileAnySubrects,
+		0, 1, // background
+		0, 2, // foreground
+		1,          // number-of-subrectangles
+		0x21, 0x10, // x=2, y=1, w=2, h=1
+		// Tile 1: background carried over from tile 0, with coloured subrects.
+		hextileAnySubrects | hextileSubrectsColoured,
+		2,
+		0, 3, 0x00, 0x00, // x=0, y=0, w=1, h=1
+		0, 4, 0x31, 0x00, // x=3, y=1, w=1, h=1
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	rect := &Rectangle{Width: 20, Height: 2}
+	enc, err := (&HextileEncoding{}).Read(conn, rect)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	colors := enc.(*HextileEncoding).Colors
+	if got, want := len(colors), rect.Area(); got != want {
+		t.Fatalf("incorrect number of colors; got = %d, want = %d", got, want)
+	}
+	for _, tt := range []struct {
+		x, y int
+		r    uint16
+	}{
+		{0, 0, 1}, {2, 1, 2}, {3, 1, 2}, {4, 1, 1}, {2, 0, 1},
+		{16, 0, 3}, {17, 0, 1}, {19, 1, 4}, {18, 1, 1},
+	} {
+		if got, want := colors[tt.y*20+tt.x].R, tt.r; got != want {
+			t.Errorf("(%d, %d): incorrect color; got = %d, want = %d", tt.x, tt.y, got, want)
+		}
+	}
+}
+
+func TestHextileEncoding_Marshal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		desc          string
+		pf            PixelFormat
+		width, height uint16
+		numColors     int
+	}{
+		{"solid", PixelFormat32bit, 40, 40, 1},
+		{"two colors", PixelFormat32bit, 33, 17, 2},
+		{"few colors", PixelFormat16bit, 50, 20, 4},
+		{"many colors", PixelFormat32bit, 16, 16, 200},
+		{"empty", PixelFormat32bit, 0, 0, 1},
+	} {
+		mockConn.Reset()
+		conn.pixelFormat = tt.pf
+		colors := randomColors(r, &conn.pixelFormat, int(tt.width)*int(tt.height), tt.numColors)
+
+		data, err := NewHextileEncoding(colors, tt.width, tt.height).Marshal()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if err := conn.send(data); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		enc, err := (&HextileEncoding{}).Read(conn, &Rectangle{Width: tt.width, Height: tt.height})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if got, want := enc.(*HextileEncoding).Colors, colors; !equalColors(got, want) {
+			t.Errorf("%s: colors did not round-trip", tt.desc)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes unread", tt.desc, mockConn.b.Len())
+		}
+	}
+}
+
+// pixelFormat24bit is a 32 bpp little-endian format with a depth of 24, which
+// uses 3 byte CPIXEL values.
+var pixelFormat24bit = PixelFormat{32, 24, rfbflags.RFBFalse, rfbflags.RFBTrue, 255, 255, 255, 16, 8, 0, [3]byte{}}
+
+func TestReadRLETiles(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat16bit
+
+	for _, tt := range []struct {
+		desc string
+		w, h uint16
+		data []byte
+		reds []uint16
+	}{
+		{"raw", 2, 1,
+			[]byte{rleRaw, 0, 1, 0, 2},
+			[]uint16{1, 2}},
+		{"solid", 3, 1,
+			[]byte{rleSolid, 0, 5},
+			[]uint16{5, 5, 5}},
+		{"packed palette", 5, 2,
+			[]byte{2, 0, 1, 0, 2, 0xb0, 0x08},
+			[]uint16{2, 1, 2, 2, 1, 1, 1, 1, 1, 2}},
+		{"plain rle", 20, 15,
+			[]byte{rlePlain, 0, 1, 255, 43, 0, 2, 0},
+			append(bytes16(1, 299), 2)},
+		{"palette rle", 4, 1,
+			[]byte{130, 0, 1, 0, 2, 0x81, 1, 0x00, 0x01},
+			[]uint16{2, 2, 1, 2}},
+	} {
+		colors, err := conn.readRLETiles(bytes.NewReader(tt.data), &Rectangle{Width: tt.w, Height: tt.h}, zrleTileSize, false)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		reds := make([]uint16, len(colors))
+		for i, c := range colors {
+			reds[i] = c.R
+		}
+		if got, want := reds, tt.reds; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: incorrect colors; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestTRLEEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat16bit
+
+	// A 20x1 rectangle, covered by a 16x1 and a 4x1 tile. The second tile
+	// reuses the palette of the first.
+	data := []byte{
+		2, 0, 1, 0, 2, 0xf0, 0x0f, // packed palette
+		rleReusePacked, 0x50, // packed palette, with reuse
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	enc, err := (&TRLEEncoding{}).Read(conn, &Rectangle{Width: 20, Height: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	reds := []uint16{}
+	for _, c := range enc.(*TRLEEncoding).Colors {
+		reds = append(reds, c.R)
+	}
+	want := []uint16{2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 1, 2, 1, 2}
+	if !reflect.DeepEqual(reds, want) {
+		t.Errorf("incorrect colors; got = %v, want = %v", reds, want)
+	}
+
+	// Palette reuse is not permitted by ZRLE, nor without a previous palette.
+	for _, reuse := range []bool{false, true} {
+		data := []byte{rleReusePaletteRLE, 0}
+		if _, err := conn.readRLETiles(bytes.NewReader(data), &Rectangle{Width: 1, Height: 1}, trleTileSize, reuse); err == nil {
+			t.Errorf("reuse %v: expected error", reuse)
+		}
+	}
+}
+
+func TestTRLEEncoding_Marshal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		desc          string
+		pf            PixelFormat
+		width, height uint16
+		numColors     int
+	}{
+		{"solid", PixelFormat32bit, 40, 20, 1},
+		{"packed palette", pixelFormat24bit, 17, 33, 5},
+		{"many colors", PixelFormat16bit, 16, 16, 300},
+	} {
+		mockConn.Reset()
+		conn.pixelFormat = tt.pf
+		colors := randomColors(r, &conn.pixelFormat, int(tt.width)*int(tt.height), tt.numColors)
+
+		data, err := NewTRLEEncoding(colors, tt.width, tt.height).Marshal()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if err := conn.send(data); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		enc, err := (&TRLEEncoding{}).Read(conn, &Rectangle{Width: tt.width, Height: tt.height})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if got, want := enc.(*TRLEEncoding).Colors, colors; !equalColors(got, want) {
+			t.Errorf("%s: colors did not round-trip", tt.desc)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes unread", tt.desc, mockConn.b.Len())
+		}
+	}
+}
+
+// bytes16 returns a slice of n copies of v.
+func bytes16(v uint16, n int) []uint16 {
+	s := make([]uint16, n)
+	for i := range s {
+		s[i] = v
+	}
+	return s
+}
+
+func TestZRLEEncoding_Marshal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for _, tt := range []struct {
+		desc          string
+		pf            PixelFormat
+		width, height uint16
+		numColors     int
+	}{
+		{"solid", PixelFormat32bit, 130, 70, 1},
+		{"two colors", PixelFormat16bit, 64, 64, 2},
+		{"packed palette", pixelFormat24bit, 70, 10, 16},
+		{"palette rle", pixelFormat24bit, 65, 65, 100},
+		{"many colors", PixelFormat32bit, 64, 3, 1000},
+		{"8 bpp", PixelFormat8bit, 10, 10, 3},
+	} {
+		// Each ZRLE rectangle requires a new connection, as the zlib stream
+		// of the server starts with the first one.
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{})
+		conn.pixelFormat = tt.pf
+		colors := randomColors(r, &conn.pixelFormat, int(tt.width)*int(tt.height), tt.numColors)
+
+		data, err := NewServerConn(&MockConn{}, &ServerConfig{}).NewZRLEEncoding(colors, tt.width, tt.height).Marshal()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if err := conn.send(data); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		enc, err := (&ZRLEEncoding{}).Read(conn, &Rectangle{Width: tt.width, Height: tt.height})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if got, want := enc.(*ZRLEEncoding).Colors, colors; !equalColors(got, want) {
+			t.Errorf("%s: colors did not round-trip", tt.desc)
+		}
+	}
+}
+
+func TestZRLEEncoding_Read(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = pixelFormat24bit
+
+	// Send several rectangles using a single zlib stream, as a server would.
+	var (
+		stream bytes.Buffer
+		rects  [][]Color
+	)
+	zw := zlib.NewWriter(&stream)
+	for i := 0; i < 3; i++ {
+		colors := randomColors(r, &conn.pixelFormat, 100*20, 1+i*10)
+		tiles, err := marshalRLETiles(colors, 100, 20, zrleTileSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		zw.Write(tiles)
+		zw.Flush()
+		conn.send(uint32(stream.Len()))
+		conn.send(stream.Bytes())
+		stream.Reset()
+		rects = append(rects, colors)
+	}
+
+	for i, colors := range rects {
+		enc, err := (&ZRLEEncoding{}).Read(conn, &Rectangle{Width: 100, Height: 20})
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %s", i, err)
+		}
+		if got, want := enc.(*ZRLEEncoding).Colors, colors; !equalColors(got, want) {
+			t.Errorf("%d: incorrect colors", i)
+		}
+	}
+}
+
+func TestZRLEEncoding_ReadTooLong(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat32bit
+
+	if err := conn.send(uint32(0xffffffff)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&ZRLEEncoding{}).Read(conn, &Rectangle{Width: 64, Height: 64}); err == nil {
+		t.Error("expected error")
+	}
+}
+
 func TestDesktopSizePseudoEncoding_Type(t *testing.T) {
 	e := &DesktopSizePseudoEncoding{}
 	if got, want := e.Type(), encodings.DesktopSizePseudo; got != want {
 		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
 	}
 }
+
+func TestLevelPseudoEncodings(t *testing.T) {
+	for _, tt := range []struct {
+		enc  Encoding
+		want encodings.Encoding
+	}{
+		{&JPEGQualityLevelPseudoEncoding{0}, encodings.JPEGQualityLevel0Pseudo},
+		{&JPEGQualityLevelPseudoEncoding{6}, encodings.JPEGQualityLevel6Pseudo},
+		{&JPEGQualityLevelPseudoEncoding{20}, encodings.JPEGQualityLevel9Pseudo},
+		{&CompressionLevelPseudoEncoding{1}, encodings.CompressionLevel1Pseudo},
+		{&CompressionLevelPseudoEncoding{10}, encodings.CompressionLevel9Pseudo},
+		{&FineQualityLevelPseudoEncoding{0}, encodings.FineQualityLevel0Pseudo},
+		{&FineQualityLevelPseudoEncoding{75}, -437},
+		{&FineQualityLevelPseudoEncoding{255}, encodings.FineQualityLevel100Pseudo},
+		{&SubsampPseudoEncoding{Subsamp1X}, encodings.Subsamp1XPseudo},
+		{&SubsampPseudoEncoding{SubsampGray}, encodings.SubsampGrayPseudo},
+		{&SubsampPseudoEncoding{Subsamp16X}, encodings.Subsamp16XPseudo},
+		{&SubsampPseudoEncoding{Subsamp16X + 1}, encodings.Subsamp16XPseudo},
+	} {
+		if got, want := tt.enc.Type(), tt.want; got != want {
+			t.Errorf("%s: incorrect encoding; got = %d, want = %d", tt.enc, got, want)
+		}
+		if _, err := tt.enc.Read(nil, &Rectangle{}); err == nil {
+			t.Errorf("%s: expected error", tt.enc)
+		}
+	}
+
+	encs := Encodings{&JPEGQualityLevelPseudoEncoding{5}, &CompressionLevelPseudoEncoding{2}}
+	bytes, err := encs.Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error; %s", err)
+	}
+	if got, want := bytes, []byte{0xff, 0xff, 0xff, 0xe5, 0xff, 0xff, 0xff, 0x02}; !operators.EqualSlicesOfByte(got, want) {
+		t.Errorf("incorrect result; got = %v, want = %v", got, want)
+	}
+}
+
+func TestCursorPseudoEncoding(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = pixelFormat24bit
+
+	if conn.Cursor() != nil {
+		t.Fatal("expected no cursor before one is sent")
+	}
+
+	// A 10x2 cursor, with every other pixel of the first row visible.
+	colors := make([]Color, 20)
+	for i := range colors {
+		colors[i] = Color{pf: &conn.pixelFormat, cm: &conn.colorMap, R: uint16(i), G: 0x80, B: 0xff}
+	}
+	bitmask := []byte{0xaa, 0x80, 0x00, 0x00}
+	data, err := NewCursorPseudoEncoding(colors, bitmask, 10, 2).Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := len(data), 20*4+4; got != want {
+		t.Errorf("incorrect length; got = %d, want = %d", got, want)
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	enc, err := (&CursorPseudoEncoding{}).Read(conn, &Rectangle{X: 3, Y: 1, Width: 10, Height: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := enc.(*CursorPseudoEncoding).Colors, colors; !equalColors(got, want) {
+		t.Errorf("colors did not round-trip")
+	}
+
+	cursor := conn.Cursor()
+	if cursor == nil {
+		t.Fatal("expected a cursor")
+	}
+	if got, want := cursor.Hotspot, image.Pt(3, 1); got != want {
+		t.Errorf("incorrect hotspot; got = %v, want = %v", got, want)
+	}
+	for _, tt := range []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{0, 0, color.NRGBA{0, 0x80, 0xff, 0xff}},
+		{1, 0, color.NRGBA{}},
+		{6, 0, color.NRGBA{6, 0x80, 0xff, 0xff}},
+		{8, 0, color.NRGBA{8, 0x80, 0xff, 0xff}},
+		{9, 0, color.NRGBA{}},
+		{0, 1, color.NRGBA{}},
+	} {
+		if got := cursor.Image.NRGBAAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("incorrect pixel (%d, %d); got = %v, want = %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	if _, err := NewCursorPseudoEncoding(colors, bitmask[:2], 10, 2).Marshal(); err == nil {
+		t.Error("expected error for a short bitmask")
+	}
+}
+
+func TestXCursorPseudoEncoding(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	primary, secondary := color.RGBA{0xff, 0, 0, 0xff}, color.RGBA{0, 0, 0xff, 0xff}
+	bitmap := []byte{0x80, 0x00}
+	bitmask := []byte{0xc0, 0x40}
+	data, err := NewXCursorPseudoEncoding(primary, secondary, bitmap, bitmask, 2, 2).Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := data, []byte{0xff, 0, 0, 0, 0, 0xff, 0x80, 0x00, 0xc0, 0x40}; !operators.EqualSlicesOfByte(got, want) {
+		t.Errorf("incorrect result; got = %v, want = %v", got, want)
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := (&XCursorPseudoEncoding{}).Read(conn, &Rectangle{X: 1, Y: 0, Width: 2, Height: 2}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cursor := conn.Cursor()
+	if got, want := cursor.Hotspot, image.Pt(1, 0); got != want {
+		t.Errorf("incorrect hotspot; got = %v, want = %v", got, want)
+	}
+	for _, tt := range []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{0, 0, color.NRGBA{0xff, 0, 0, 0xff}},
+		{1, 0, color.NRGBA{0, 0, 0xff, 0xff}},
+		{0, 1, color.NRGBA{}},
+		{1, 1, color.NRGBA{0, 0, 0xff, 0xff}},
+	} {
+		if got := cursor.Image.NRGBAAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("incorrect pixel (%d, %d); got = %v, want = %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	// An empty cursor has no data, and hides the cursor.
+	if _, err := (&XCursorPseudoEncoding{}).Read(conn, &Rectangle{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := conn.Cursor().Image.Bounds().Empty(); !got {
+		t.Errorf("expected an empty cursor image")
+	}
+}
+
+func TestExtendedDesktopSizePseudoEncoding(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	screens := []Screen{{ID: 7, X: 0, Y: 0, Width: 640, Height: 480, Flags: 0}}
+	for _, tt := range []struct {
+		desc    string
+		reason  DesktopSizeReason
+		status  DesktopSizeStatus
+		resized bool
+		err     bool
+	}{
+		{"server", DesktopSizeServer, DesktopSizeOK, true, false},
+		{"client", DesktopSizeClient, DesktopSizeOK, true, false},
+		{"prohibited", DesktopSizeClient, DesktopSizeProhibited, false, true},
+		{"other client failed", DesktopSizeOtherClient, DesktopSizeInvalidLayout, false, false},
+	} {
+		mockConn.Reset()
+		conn.setFramebufferWidth(0)
+		conn.setFramebufferHeight(0)
+
+		rect := &Rectangle{
+			X:      uint16(tt.reason),
+			Y:      uint16(tt.status),
+			Width:  640,
+			Height: 480,
+			Enc:    &ExtendedDesktopSizePseudoEncoding{Screens: screens},
+		}
+		data, err := rect.Marshal()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		var got Rectangle
+		if err := got.Unmarshal(data); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		if got, want := got.Enc, (&ExtendedDesktopSizePseudoEncoding{tt.reason, tt.status, screens}); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: incorrect unmarshal; got = %v, want = %v", tt.desc, got, want)
+		}
+
+		if err := conn.send(data[12:]); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		enc, err := (&ExtendedDesktopSizePseudoEncoding{}).Read(conn, rect)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		e := enc.(*ExtendedDesktopSizePseudoEncoding)
+		if got, want := e.Err() != nil, tt.err; got != want {
+			t.Errorf("%s: incorrect error; got = %v, want error = %v", tt.desc, e.Err(), want)
+		}
+		if got, want := conn.FramebufferWidth() == 640, tt.resized; got != want {
+			t.Errorf("%s: incorrect resize; got = %v, want = %v", tt.desc, got, want)
+		}
+		if tt.resized && !reflect.DeepEqual(conn.Screens(), screens) {
+			t.Errorf("%s: incorrect screens; got = %v, want = %v", tt.desc, conn.Screens(), screens)
+		}
+	}
+}
+
+func TestDesktopNamePseudoEncoding_ReadTooLong(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	if err := conn.send(uint32(0xffffffff)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&DesktopNamePseudoEncoding{}).Read(conn, &Rectangle{}); err == nil {
+		t.Error("expected error")
+	}
+	if got, want := conn.DesktopName(), ""; got != want {
+		t.Errorf("incorrect desktop name; got = %q, want = %q", got, want)
+	}
+}
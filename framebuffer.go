@@ -0,0 +1,434 @@
+// Framebuffers of a VNC server.
+
+package vnc
+
+import (
+	"image"
+	"image/draw"
+	"log"
+	"sync"
+
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// FramebufferSource provides the framebuffer of a server, and tracks the
+// regions of it that change. Changes are counted in generations, which start
+// at 1, so that generation 0 precedes all of them.
+type FramebufferSource interface {
+	// Bounds returns the bounds of the framebuffer.
+	Bounds() image.Rectangle
+
+	// Damaged returns the regions of the framebuffer that changed after
+	// generation gen, and the current generation. Generation 0 returns the
+	// whole framebuffer.
+	Damaged(gen uint64) ([]image.Rectangle, uint64)
+
+	// Changed returns a channel that is closed by the next change of the
+	// framebuffer.
+	Changed() <-chan struct{}
+
+	// Image returns a copy of the region r of the framebuffer.
+	Image(r image.Rectangle) image.Image
+}
+
+// FramebufferCopier is implemented by a FramebufferSource that tracks regions
+// copied within the framebuffer, e.g. by scrolling, so that they can be sent
+// with the CopyRect encoding.
+type FramebufferCopier interface {
+	// Changes returns the changes of the framebuffer after generation gen, in
+	// order, and the current generation. It returns false if the changes are
+	// no longer known.
+	Changes(gen uint64) ([]FramebufferChange, uint64, bool)
+}
+
+// FramebufferChange describes a change of a region of a framebuffer.
+type FramebufferChange struct {
+	Rect image.Rectangle // The changed region.
+	Copy bool            // Whether the region was copied from Src.
+	Src  image.Point     // The top-left corner of the copied region.
+}
+
+// maxDamageHistory is the number of changes remembered by RGBAFramebuffer.
+// Older generations are treated as if the whole framebuffer changed.
+const maxDamageHistory = 64
+
+// maxDamageRects is the number of regions Damaged returns before merging them
+// into their bounding box.
+const maxDamageRects = 16
+
+// damageRecord holds a change of a framebuffer.
+type damageRecord struct {
+	gen uint64
+	FramebufferChange
+}
+
+// RGBAFramebuffer is a FramebufferSource backed by an image.RGBA. It is safe
+// for concurrent use.
+type RGBAFramebuffer struct {
+	mu      sync.RWMutex
+	img     *image.RGBA
+	gen     uint64
+	history []damageRecord
+	changed chan struct{}
+}
+
+// Verify that interfaces are honored.
+var _ FramebufferSource = (*RGBAFramebuffer)(nil)
+var _ FramebufferCopier = (*RGBAFramebuffer)(nil)
+
+// NewRGBAFramebuffer returns a black RGBAFramebuffer of the given size.
+func NewRGBAFramebuffer(width, height int) *RGBAFramebuffer {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	return &RGBAFramebuffer{
+		img:     img,
+		gen:     1,
+		changed: make(chan struct{}),
+	}
+}
+
+// Draw calls fn to draw on the framebuffer, and marks r as damaged. Only the
+// pixels within r may be changed by fn.
+func (f *RGBAFramebuffer) Draw(r image.Rectangle, fn func(img *image.RGBA)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(f.img)
+	f.damage(FramebufferChange{Rect: r})
+}
+
+// Damage marks r as damaged, for pixels changed outside of Draw.
+func (f *RGBAFramebuffer) Damage(r image.Rectangle) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.damage(FramebufferChange{Rect: r})
+}
+
+// Copy copies the pixels at sp to the region r of the framebuffer, and marks
+// r as damaged. Overlapping source and destination regions are handled
+// correctly.
+func (f *RGBAFramebuffer) Copy(r image.Rectangle, sp image.Point) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Clip r, so that both it and its source are within the framebuffer.
+	bounds := f.img.Bounds()
+	delta := sp.Sub(r.Min)
+	r = r.Intersect(bounds).Intersect(bounds.Sub(delta))
+	if r.Empty() {
+		return
+	}
+	sp = r.Min.Add(delta)
+	draw.Draw(f.img, r, f.img, sp, draw.Src)
+	f.damage(FramebufferChange{r, true, sp})
+}
+
+// damage records the change c. The caller must hold the write lock.
+func (f *RGBAFramebuffer) damage(c FramebufferChange) {
+	c.Rect = c.Rect.Intersect(f.img.Bounds())
+	if c.Rect.Empty() {
+		return
+	}
+	f.gen++
+	f.history = append(f.history, damageRecord{f.gen, c})
+	if len(f.history) > maxDamageHistory {
+		f.history = f.history[len(f.history)-maxDamageHistory:]
+	}
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// Bounds implements the FramebufferSource interface.
+func (f *RGBAFramebuffer) Bounds() image.Rectangle {
+	return f.img.Bounds()
+}
+
+// Changes implements the FramebufferCopier interface.
+func (f *RGBAFramebuffer) Changes(gen uint64) ([]FramebufferChange, uint64, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if gen >= f.gen {
+		return nil, f.gen, true
+	}
+	if gen == 0 || len(f.history) == 0 || gen < f.history[0].gen-1 {
+		return nil, f.gen, false
+	}
+	var changes []FramebufferChange
+	for _, d := range f.history {
+		if d.gen > gen {
+			changes = append(changes, d.FramebufferChange)
+		}
+	}
+	return changes, f.gen, true
+}
+
+// Changed implements the FramebufferSource interface.
+func (f *RGBAFramebuffer) Changed() <-chan struct{} {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.changed
+}
+
+// Damaged implements the FramebufferSource interface. Overlapping regions are
+// merged.
+func (f *RGBAFramebuffer) Damaged(gen uint64) ([]image.Rectangle, uint64) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if gen >= f.gen {
+		return nil, f.gen
+	}
+	if gen == 0 || len(f.history) == 0 || gen < f.history[0].gen-1 {
+		return []image.Rectangle{f.img.Bounds()}, f.gen
+	}
+	var rects []image.Rectangle
+	for _, d := range f.history {
+		if d.gen > gen {
+			rects = append(rects, d.Rect)
+		}
+	}
+	return mergeRects(rects), f.gen
+}
+
+// Image implements the FramebufferSource interface.
+func (f *RGBAFramebuffer) Image(r image.Rectangle) image.Image {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	r = r.Intersect(f.img.Bounds())
+	img := image.NewRGBA(r)
+	draw.Draw(img, r, f.img, r.Min, draw.Src)
+	return img
+}
+
+// mergeRects merges overlapping rectangles, until none overlap. More than
+// maxDamageRects rectangles are merged into their bounding box.
+func mergeRects(rects []image.Rectangle) []image.Rectangle {
+	merged := append([]image.Rectangle{}, rects...)
+	for overlaps := true; overlaps; {
+		overlaps = false
+		for i := 0; i < len(merged); i++ {
+			for j := i + 1; j < len(merged); j++ {
+				if merged[i].Overlaps(merged[j]) {
+					merged[i] = merged[i].Union(merged[j])
+					merged = append(merged[:j], merged[j+1:]...)
+					j--
+					overlaps = true
+				}
+			}
+		}
+	}
+	if len(merged) > maxDamageRects {
+		bounds := image.Rectangle{}
+		for _, r := range merged {
+			bounds = bounds.Union(r)
+		}
+		return []image.Rectangle{bounds}
+	}
+	return merged
+}
+
+// requestUpdate queues a FramebufferUpdateRequest for serveFramebuffer. A
+// request that is still pending is merged into the new one.
+func (s *ServerConn) requestUpdate(req *FramebufferUpdateRequestMessage) {
+	select {
+	case old := <-s.updateRequests:
+		if !rfbflags.ToBool(old.Inc) {
+			r := requestRect(req).Union(requestRect(old))
+			req = &FramebufferUpdateRequestMessage{req.Msg, rfbflags.RFBFalse,
+				uint16(r.Min.X), uint16(r.Min.Y), uint16(r.Dx()), uint16(r.Dy())}
+		}
+	default:
+	}
+	s.updateRequests <- req
+}
+
+// requestRect returns the region of the framebuffer requested by req.
+func requestRect(req *FramebufferUpdateRequestMessage) image.Rectangle {
+	return image.Rect(int(req.X), int(req.Y), int(req.X)+int(req.Width), int(req.Y)+int(req.Height))
+}
+
+// serveFramebuffer answers FramebufferUpdateRequests from the framebuffer
+// source of the server, until done is closed. Incremental requests are
+// answered once part of the requested region has changed.
+func (s *ServerConn) serveFramebuffer(done <-chan struct{}) {
+	fb := s.config.Framebuffer
+	var (
+		req     *FramebufferUpdateRequestMessage
+		changed <-chan struct{}
+	)
+	for {
+		if req != nil {
+			// Watch for changes before looking for them, so that none are missed.
+			changed = fb.Changed()
+			sent, err := s.framebufferUpdate(req)
+			if err != nil {
+				log.Printf("error sending framebuffer update; %v", err)
+				s.Close()
+				return
+			}
+			if sent {
+				req, changed = nil, nil
+			}
+		}
+		select {
+		case <-done:
+			return
+		case req = <-s.updateRequests:
+		case <-changed:
+		}
+	}
+}
+
+// framebufferUpdate answers req with the changed, or for a non-incremental
+// request all, regions of the framebuffer source within the requested region.
+// It returns false if an incremental request has nothing to send yet.
+func (s *ServerConn) framebufferUpdate(req *FramebufferUpdateRequestMessage) (bool, error) {
+	fb := s.config.Framebuffer
+	bounds := fb.Bounds()
+	area := requestRect(req).Intersect(bounds)
+
+	// Collect the damage since the last update into the regions that the
+	// client does not have yet.
+	var copies []FramebufferChange
+	if rfbflags.ToBool(req.Inc) {
+		var damaged []image.Rectangle
+		copies, damaged = s.framebufferChanges(area)
+		s.addPending(damaged)
+	} else {
+		damaged, gen := fb.Damaged(s.fbGen)
+		s.fbGen = gen
+		s.addPending(damaged)
+	}
+
+	var regions []image.Rectangle
+	if rfbflags.ToBool(req.Inc) {
+		for _, p := range s.fbPending {
+			if r := p.Intersect(area); !r.Empty() {
+				regions = append(regions, r)
+			}
+		}
+		if len(copies) == 0 && len(regions) == 0 {
+			return false, nil
+		}
+	} else if !area.Empty() {
+		regions = append(regions, area)
+	}
+	// Damage outside of the requested region is left for later requests.
+	s.fbPending = subtractRect(s.fbPending, area)
+
+	rects := make([]Rectangle, 0, len(copies)+len(regions))
+	for _, c := range copies {
+		rects = append(rects, Rectangle{
+			X: uint16(c.Rect.Min.X), Y: uint16(c.Rect.Min.Y),
+			Width: uint16(c.Rect.Dx()), Height: uint16(c.Rect.Dy()),
+			Enc: &CopyRectEncoding{uint16(c.Src.X), uint16(c.Src.Y)},
+		})
+	}
+	pf := s.PixelFormat()
+	for _, region := range regions {
+		for _, r := range splitRect(region, encodeTileSize) {
+			colors, err := imageToColors(fb.Image(r), &pf, nil)
+			if err != nil {
+				return false, err
+			}
+			enc, err := s.Encode(colors, uint16(r.Dx()), uint16(r.Dy()))
+			if err != nil {
+				return false, err
+			}
+			rects = append(rects, Rectangle{
+				X: uint16(r.Min.X), Y: uint16(r.Min.Y),
+				Width: uint16(r.Dx()), Height: uint16(r.Dy()),
+				Enc: enc,
+			})
+		}
+	}
+	return true, s.FramebufferUpdate(rects)
+}
+
+// framebufferChanges returns the regions of the framebuffer source that
+// changed since the last update of the client, and advances the generation of
+// the client. Copies are returned separately if the client can apply them
+// itself, which requires its framebuffer to be exactly that of the last
+// update.
+func (s *ServerConn) framebufferChanges(area image.Rectangle) ([]FramebufferChange, []image.Rectangle) {
+	fb := s.config.Framebuffer
+	if copier, ok := fb.(FramebufferCopier); ok && area == fb.Bounds() && len(s.fbPending) == 0 && s.supports(encodings.CopyRect) {
+		if changes, gen, ok := copier.Changes(s.fbGen); ok {
+			copies, damaged := planCopies(changes)
+			s.fbGen = gen
+			return copies, damaged
+		}
+	}
+	damaged, gen := fb.Damaged(s.fbGen)
+	s.fbGen = gen
+	return nil, damaged
+}
+
+// addPending adds damaged regions to those the client does not have yet.
+func (s *ServerConn) addPending(damaged []image.Rectangle) {
+	if len(damaged) > 0 {
+		s.fbPending = mergeRects(append(s.fbPending, damaged...))
+	}
+}
+
+// subtractRect returns the parts of rects outside of cut.
+func subtractRect(rects []image.Rectangle, cut image.Rectangle) []image.Rectangle {
+	var parts []image.Rectangle
+	for _, r := range rects {
+		i := r.Intersect(cut)
+		if i.Empty() {
+			parts = append(parts, r)
+			continue
+		}
+		for _, p := range []image.Rectangle{
+			image.Rect(r.Min.X, r.Min.Y, r.Max.X, i.Min.Y), // Above.
+			image.Rect(r.Min.X, i.Max.Y, r.Max.X, r.Max.Y), // Below.
+			image.Rect(r.Min.X, i.Min.Y, i.Min.X, i.Max.Y), // Left.
+			image.Rect(i.Max.X, i.Min.Y, r.Max.X, i.Max.Y), // Right.
+		} {
+			if !p.Empty() {
+				parts = append(parts, p)
+			}
+		}
+	}
+	return parts
+}
+
+// planCopies returns the copies of changes that a client can apply to its
+// framebuffer in order, and the regions that must be sent after them. A copy
+// can be applied if its source is up to date in the framebuffer of the client
+// at that point.
+func planCopies(changes []FramebufferChange) ([]FramebufferChange, []image.Rectangle) {
+	var (
+		copies []FramebufferChange
+		dirty  []image.Rectangle // Regions the client does not have yet.
+	)
+	for _, c := range changes {
+		if c.Copy && !overlapsAny(c.Rect.Add(c.Src.Sub(c.Rect.Min)), dirty) {
+			copies = append(copies, c)
+			// The copy brings regions within it up to date.
+			kept := dirty[:0]
+			for _, d := range dirty {
+				if !d.In(c.Rect) {
+					kept = append(kept, d)
+				}
+			}
+			dirty = kept
+			continue
+		}
+		dirty = append(dirty, c.Rect)
+	}
+	return copies, mergeRects(dirty)
+}
+
+// overlapsAny returns whether r overlaps any of rects.
+func overlapsAny(r image.Rectangle, rects []image.Rectangle) bool {
+	for _, o := range rects {
+		if r.Overlaps(o) {
+			return true
+		}
+	}
+	return false
+}
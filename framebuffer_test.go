@@ -0,0 +1,368 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+func TestRGBAFramebuffer_Damaged(t *testing.T) {
+	fb := NewRGBAFramebuffer(64, 48)
+	bounds := image.Rect(0, 0, 64, 48)
+
+	rects, gen := fb.Damaged(0)
+	if got, want := rects, []image.Rectangle{bounds}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect initial damage; got = %v, want = %v", got, want)
+	}
+	if rects, _ := fb.Damaged(gen); rects != nil {
+		t.Errorf("unexpected damage %v", rects)
+	}
+
+	changed := fb.Changed()
+	fb.Damage(image.Rect(0, 0, 8, 8))
+	select {
+	case <-changed:
+	default:
+		t.Error("Changed() channel not closed by damage")
+	}
+	fb.Damage(image.Rect(4, 4, 12, 12))
+	fb.Damage(image.Rect(32, 32, 40, 40))
+	fb.Damage(image.Rect(60, 40, 80, 60)) // Clipped to the bounds.
+	fb.Damage(image.Rect(100, 100, 108, 108))
+
+	for _, tt := range []struct {
+		gen   uint64
+		rects []image.Rectangle
+	}{
+		{gen, []image.Rectangle{image.Rect(0, 0, 12, 12), image.Rect(32, 32, 40, 40), image.Rect(60, 40, 64, 48)}},
+		{gen + 2, []image.Rectangle{image.Rect(32, 32, 40, 40), image.Rect(60, 40, 64, 48)}},
+		{gen + 4, nil},
+	} {
+		rects, _ := fb.Damaged(tt.gen)
+		if got, want := rects, tt.rects; !reflect.DeepEqual(got, want) {
+			t.Errorf("gen %d: incorrect damage; got = %v, want = %v", tt.gen, got, want)
+		}
+	}
+
+	// Changes beyond the history damage the whole framebuffer.
+	for i := 0; i <= maxDamageHistory; i++ {
+		fb.Damage(image.Rect(i%64, 0, i%64+1, 1))
+	}
+	rects, _ = fb.Damaged(gen)
+	if got, want := rects, []image.Rectangle{bounds}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect damage after history; got = %v, want = %v", got, want)
+	}
+}
+
+func TestRGBAFramebuffer_Copy(t *testing.T) {
+	fb := NewRGBAFramebuffer(8, 8)
+	_, gen := fb.Damaged(0)
+	red := color.RGBA{255, 0, 0, 255}
+	fb.Draw(image.Rect(0, 0, 2, 2), func(img *image.RGBA) {
+		img.SetRGBA(0, 0, red)
+	})
+
+	// Copies are clipped to the framebuffer, for both source and destination.
+	fb.Copy(image.Rect(-1, 5, 4, 12), image.Pt(-2, -1))
+	fb.Copy(image.Rect(0, 0, 2, 2), image.Pt(20, 20))
+
+	changes, _, ok := fb.Changes(gen)
+	if !ok {
+		t.Fatal("Changes() unexpectedly unknown")
+	}
+	if got, want := changes, []FramebufferChange{
+		{Rect: image.Rect(0, 0, 2, 2)},
+		{image.Rect(1, 6, 4, 8), true, image.Pt(0, 0)},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect changes; got = %v, want = %v", got, want)
+	}
+	if got, want := fb.Image(image.Rect(1, 6, 2, 7)).At(1, 6), color.Color(red); got != want {
+		t.Errorf("incorrect copied color; got = %v, want = %v", got, want)
+	}
+	if _, _, ok := fb.Changes(0); ok {
+		t.Error("Changes(0) unexpectedly known")
+	}
+}
+
+func TestPlanCopies(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		changes []FramebufferChange
+		copies  []FramebufferChange
+		dirty   []image.Rectangle
+	}{
+		{"copy",
+			[]FramebufferChange{{image.Rect(0, 0, 4, 4), true, image.Pt(4, 4)}},
+			[]FramebufferChange{{image.Rect(0, 0, 4, 4), true, image.Pt(4, 4)}},
+			[]image.Rectangle{}},
+		{"damaged source",
+			[]FramebufferChange{
+				{Rect: image.Rect(5, 5, 6, 6)},
+				{image.Rect(0, 0, 4, 4), true, image.Pt(4, 4)}},
+			nil,
+			[]image.Rectangle{image.Rect(5, 5, 6, 6), image.Rect(0, 0, 4, 4)}},
+		{"damage after copy",
+			[]FramebufferChange{
+				{image.Rect(0, 0, 4, 4), true, image.Pt(4, 4)},
+				{Rect: image.Rect(5, 5, 6, 6)}},
+			[]FramebufferChange{{image.Rect(0, 0, 4, 4), true, image.Pt(4, 4)}},
+			[]image.Rectangle{image.Rect(5, 5, 6, 6)}},
+		{"damage covered by copy",
+			[]FramebufferChange{
+				{Rect: image.Rect(1, 1, 2, 2)},
+				{Rect: image.Rect(3, 3, 5, 5)},
+				{image.Rect(0, 0, 4, 4), true, image.Pt(4, 4)}},
+			nil,
+			[]image.Rectangle{image.Rect(0, 0, 5, 5)}},
+		{"damage within copy",
+			[]FramebufferChange{
+				{Rect: image.Rect(1, 1, 2, 2)},
+				{image.Rect(0, 0, 4, 4), true, image.Pt(4, 4)}},
+			[]FramebufferChange{{image.Rect(0, 0, 4, 4), true, image.Pt(4, 4)}},
+			[]image.Rectangle{}},
+	} {
+		copies, dirty := planCopies(tt.changes)
+		if got, want := copies, tt.copies; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: incorrect copies; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := dirty, tt.dirty; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: incorrect dirty regions; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestMergeRects(t *testing.T) {
+	for _, tt := range []struct {
+		desc  string
+		rects []image.Rectangle
+		want  []image.Rectangle
+	}{
+		{"none", nil, []image.Rectangle{}},
+		{"disjoint",
+			[]image.Rectangle{image.Rect(0, 0, 2, 2), image.Rect(2, 2, 4, 4)},
+			[]image.Rectangle{image.Rect(0, 0, 2, 2), image.Rect(2, 2, 4, 4)}},
+		{"chained",
+			[]image.Rectangle{image.Rect(0, 0, 2, 2), image.Rect(4, 4, 6, 6), image.Rect(1, 1, 5, 5)},
+			[]image.Rectangle{image.Rect(0, 0, 6, 6)}},
+	} {
+		if got, want := mergeRects(tt.rects), tt.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: incorrect rects; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+
+	var rects []image.Rectangle
+	for i := 0; i <= maxDamageRects; i++ {
+		rects = append(rects, image.Rect(2*i, 0, 2*i+1, 1))
+	}
+	if got, want := mergeRects(rects), []image.Rectangle{image.Rect(0, 0, 2*maxDamageRects+1, 1)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect bounding box; got = %v, want = %v", got, want)
+	}
+}
+
+func TestServerConn_FramebufferUpdate(t *testing.T) {
+	fb := NewRGBAFramebuffer(16, 16)
+	mockConn := &MockConn{}
+	s := NewServerConn(mockConn, &ServerConfig{Framebuffer: fb})
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = s.PixelFormat()
+
+	if got, want := [2]uint16{s.FramebufferWidth(), s.FramebufferHeight()}, [2]uint16{16, 16}; got != want {
+		t.Errorf("incorrect framebuffer size; got = %v, want = %v", got, want)
+	}
+
+	red := color.RGBA{255, 0, 0, 255}
+	var drawn []image.Rectangle
+	for _, tt := range []struct {
+		desc  string
+		draw  image.Rectangle
+		req   FramebufferUpdateRequestMessage
+		sent  bool
+		rects []image.Rectangle
+	}{
+		{"full", image.Rectangle{},
+			FramebufferUpdateRequestMessage{Inc: rfbflags.RFBFalse, Width: 16, Height: 16},
+			true, []image.Rectangle{image.Rect(0, 0, 16, 16)}},
+		{"unchanged", image.Rectangle{},
+			FramebufferUpdateRequestMessage{Inc: rfbflags.RFBTrue, Width: 16, Height: 16},
+			false, nil},
+		{"changed", image.Rect(2, 3, 6, 5),
+			FramebufferUpdateRequestMessage{Inc: rfbflags.RFBTrue, Width: 16, Height: 16},
+			true, []image.Rectangle{image.Rect(2, 3, 6, 5)}},
+		{"outside of request", image.Rect(8, 8, 10, 10),
+			FramebufferUpdateRequestMessage{Inc: rfbflags.RFBTrue, Width: 4, Height: 4},
+			false, nil},
+		{"inside of request", image.Rectangle{},
+			FramebufferUpdateRequestMessage{Inc: rfbflags.RFBTrue, X: 8, Y: 8, Width: 8, Height: 8},
+			true, []image.Rectangle{image.Rect(8, 8, 10, 10)}},
+		{"inside of request again", image.Rectangle{},
+			FramebufferUpdateRequestMessage{Inc: rfbflags.RFBTrue, X: 8, Y: 8, Width: 8, Height: 8},
+			false, nil},
+		{"partly inside of request", image.Rect(2, 2, 6, 6),
+			FramebufferUpdateRequestMessage{Inc: rfbflags.RFBTrue, Width: 4, Height: 4},
+			true, []image.Rectangle{image.Rect(2, 2, 4, 4)}},
+		{"partly inside of request again", image.Rectangle{},
+			FramebufferUpdateRequestMessage{Inc: rfbflags.RFBTrue, Width: 4, Height: 4},
+			false, nil},
+		{"rest of request", image.Rectangle{},
+			FramebufferUpdateRequestMessage{Inc: rfbflags.RFBTrue, Width: 16, Height: 16},
+			true, []image.Rectangle{image.Rect(2, 4, 6, 6), image.Rect(4, 2, 6, 4)}},
+		{"rest of request again", image.Rectangle{},
+			FramebufferUpdateRequestMessage{Inc: rfbflags.RFBTrue, Width: 16, Height: 16},
+			false, nil},
+	} {
+		mockConn.Reset()
+		if !tt.draw.Empty() {
+			drawn = append(drawn, tt.draw)
+			fb.Draw(tt.draw, func(img *image.RGBA) {
+				for y := tt.draw.Min.Y; y < tt.draw.Max.Y; y++ {
+					for x := tt.draw.Min.X; x < tt.draw.Max.X; x++ {
+						img.SetRGBA(x, y, red)
+					}
+				}
+			})
+		}
+
+		sent, err := s.framebufferUpdate(&tt.req)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		if got, want := sent, tt.sent; got != want {
+			t.Errorf("%s: incorrect sent; got = %t, want = %t", tt.desc, got, want)
+		}
+		if !sent {
+			if mockConn.b.Len() != 0 {
+				t.Errorf("%s: %d bytes sent", tt.desc, mockConn.b.Len())
+			}
+			continue
+		}
+
+		var messageType messages.ServerMessage
+		if err := conn.receive(&messageType); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := (&FramebufferUpdate{}).Read(conn)
+		if err != nil {
+			t.Fatalf("%s: failed to read; %s", tt.desc, err)
+		}
+		var rects []image.Rectangle
+		for _, rect := range msg.(*FramebufferUpdate).Rects {
+			r := image.Rect(int(rect.X), int(rect.Y), int(rect.X+rect.Width), int(rect.Y+rect.Height))
+			rects = append(rects, r)
+			for i, c := range rect.Enc.(*RawEncoding).Colors {
+				p := image.Pt(r.Min.X+i%r.Dx(), r.Min.Y+i/r.Dx())
+				want := uint16(0)
+				for _, d := range drawn {
+					if p.In(d) {
+						want = conn.pixelFormat.RedMax
+					}
+				}
+				if c.R != want {
+					t.Errorf("%s: incorrect red at %v; got = %d, want = %d", tt.desc, p, c.R, want)
+				}
+			}
+		}
+		if got, want := rects, tt.rects; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: incorrect rects; got = %v, want = %v", tt.desc, got, want)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes unread", tt.desc, mockConn.b.Len())
+		}
+	}
+}
+
+func TestServerConn_FramebufferUpdateCopyRect(t *testing.T) {
+	fb := NewRGBAFramebuffer(16, 16)
+	mockConn := &MockConn{}
+	s := NewServerConn(mockConn, &ServerConfig{Framebuffer: fb})
+	s.setEncodings([]encodings.Encoding{encodings.CopyRect, encodings.Raw})
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = s.PixelFormat()
+	conn.encodings = Encodings{&RawEncoding{}, &CopyRectEncoding{}}
+
+	full := FramebufferUpdateRequestMessage{Inc: rfbflags.RFBTrue, Width: 16, Height: 16}
+	for _, tt := range []struct {
+		desc    string
+		pending []image.Rectangle
+		change  func()
+		want    []encodings.Encoding
+	}{
+		{"initial", nil, func() {}, []encodings.Encoding{encodings.Raw}},
+		{"copy", nil, func() { fb.Copy(image.Rect(0, 0, 8, 8), image.Pt(8, 8)) },
+			[]encodings.Encoding{encodings.CopyRect}},
+		{"copy and damage", nil, func() {
+			fb.Copy(image.Rect(0, 0, 8, 8), image.Pt(8, 8))
+			fb.Damage(image.Rect(15, 15, 16, 16))
+		}, []encodings.Encoding{encodings.CopyRect, encodings.Raw}},
+		{"pending", []image.Rectangle{image.Rect(12, 0, 16, 4)}, func() { fb.Copy(image.Rect(0, 0, 8, 8), image.Pt(8, 8)) },
+			[]encodings.Encoding{encodings.Raw, encodings.Raw}},
+	} {
+		mockConn.Reset()
+		s.fbPending = tt.pending
+		tt.change()
+
+		if _, err := s.framebufferUpdate(&full); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		var messageType messages.ServerMessage
+		if err := conn.receive(&messageType); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := (&FramebufferUpdate{}).Read(conn)
+		if err != nil {
+			t.Fatalf("%s: failed to read; %s", tt.desc, err)
+		}
+		var got []encodings.Encoding
+		for _, rect := range msg.(*FramebufferUpdate).Rects {
+			got = append(got, rect.Enc.Type())
+		}
+		if want := tt.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: incorrect encodings; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestServerConn_FramebufferUpdatePartial(t *testing.T) {
+	fb := NewRGBAFramebuffer(16, 16)
+	mockConn := &MockConn{}
+	s := NewServerConn(mockConn, &ServerConfig{Framebuffer: fb})
+
+	// A client that only requests part of the screen receives it once.
+	req := FramebufferUpdateRequestMessage{Inc: rfbflags.RFBTrue, X: 4, Y: 4, Width: 4, Height: 4}
+	for i, want := range []bool{true, false, false} {
+		mockConn.Reset()
+		sent, err := s.framebufferUpdate(&req)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %s", i, err)
+		}
+		if got := sent; got != want {
+			t.Errorf("%d: incorrect sent; got = %t, want = %t", i, got, want)
+		}
+	}
+	if got, want := s.fbPending, subtractRect([]image.Rectangle{fb.Bounds()}, requestRect(&req)); !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect pending regions; got = %v, want = %v", got, want)
+	}
+}
+
+func TestSubtractRect(t *testing.T) {
+	for _, tt := range []struct {
+		rects []image.Rectangle
+		cut   image.Rectangle
+		want  []image.Rectangle
+	}{
+		{[]image.Rectangle{image.Rect(0, 0, 4, 4)}, image.Rect(4, 4, 8, 8), []image.Rectangle{image.Rect(0, 0, 4, 4)}},
+		{[]image.Rectangle{image.Rect(0, 0, 4, 4)}, image.Rect(0, 0, 8, 8), nil},
+		{[]image.Rectangle{image.Rect(0, 0, 4, 4)}, image.Rect(2, 2, 8, 8),
+			[]image.Rectangle{image.Rect(0, 0, 4, 2), image.Rect(0, 2, 2, 4)}},
+		{[]image.Rectangle{image.Rect(0, 0, 6, 6)}, image.Rect(2, 2, 4, 4), []image.Rectangle{
+			image.Rect(0, 0, 6, 2), image.Rect(0, 4, 6, 6), image.Rect(0, 2, 2, 4), image.Rect(4, 2, 6, 4)}},
+	} {
+		if got, want := subtractRect(tt.rects, tt.cut), tt.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v - %v: incorrect rects; got = %v, want = %v", tt.rects, tt.cut, got, want)
+		}
+	}
+}
@@ -150,7 +150,14 @@ func (c *ClientConn) securityHandshake38() error {
 	if logging.V(logging.FnDeclLevel) {
 		logging.Infof("%s", logging.FnName())
 	}
+	return c.negotiateSecurityType(false)
+}
 
+// negotiateSecurityType receives the security types supported by the server,
+// and performs the handshake of the first one matching the client config.
+// When tunneled, the negotiation is repeated inside a TLS session, and the
+// TLS security type is not chosen again.
+func (c *ClientConn) negotiateSecurityType(tunneled bool) error {
 	// Determine server supported security types.
 	var numSecurityTypes uint8
 	if err := c.receive(&numSecurityTypes); err != nil {
@@ -176,6 +183,9 @@ func (c *ClientConn) securityHandshake38() error {
 	var auth ClientAuth
 FindAuth:
 	for _, securityType := range securityTypes {
+		if tunneled && securityType == secTypeTLS {
+			continue
+		}
 		for _, a := range c.config.Auth {
 			if a.SecurityType() == securityType {
 				// We use the first matching supported authentication.
@@ -203,7 +213,9 @@ func (c *ClientConn) securityResultHandshake() error {
 		logging.Infof("%s", logging.FnName())
 	}
 
-	if c.config.secType == secTypeNone {
+	// Version 3.8 sends a SecurityResult for the None security type, but
+	// version 3.3 does not.
+	if c.config.secType == secTypeNone && c.protocolVersion == PROTO_VERS_3_3 {
 		return nil
 	}
 
@@ -214,6 +226,10 @@ func (c *ClientConn) securityResultHandshake() error {
 	switch securityResult {
 	case 0:
 	case 1:
+		// Version 3.3 sends no reason for the failure.
+		if c.protocolVersion == PROTO_VERS_3_3 {
+			return NewVNCError("SecurityResult handshake failed: authentication failed")
+		}
 		reason, err := c.readErrorReason()
 		if err != nil {
 			return err
@@ -244,3 +260,168 @@ func (c *ClientConn) readErrorReason() (string, error) {
 
 	return string(reason), nil
 }
+
+// protocolVersionHandshake implements the server side of §7.1.1
+// ProtocolVersion Handshake.
+func (s *ServerConn) protocolVersionHandshake(ctx context.Context) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerConn.%s", logging.FnName())
+	}
+
+	pv := PROTO_VERS_3_8
+	if mpv := ctx.Value("vnc_max_proto_version"); mpv == "3.3" {
+		pv = PROTO_VERS_3_3
+	}
+	if err := s.send([]byte(pv)); err != nil {
+		return err
+	}
+
+	// Read the ProtocolVersion message sent by the client.
+	var protocolVersion [pvLen]byte
+	if err := s.receive(&protocolVersion); err != nil {
+		return err
+	}
+	if logging.V(logging.ResultLevel) {
+		logging.Infof("protocolVersion: %s", protocolVersion)
+	}
+
+	major, minor, err := parseProtocolVersion(protocolVersion[:])
+	if err != nil {
+		return err
+	}
+	switch {
+	case major == 3 && minor < 8:
+		// Other versions, such as 3.5 and 3.7, are treated as 3.3.
+		pv = PROTO_VERS_3_3
+	case major == 3 && pv == PROTO_VERS_3_8:
+	default:
+		return NewVNCError(fmt.Sprintf("ProtocolVersion handshake failed; unsupported version '%v'", string(protocolVersion[:])))
+	}
+	s.protocolVersion = pv
+	return nil
+}
+
+// securityHandshake implements the server side of §7.1.2 Security Handshake,
+// and §7.1.3 SecurityResult Handshake.
+func (s *ServerConn) securityHandshake() error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerConn.%s", logging.FnName())
+	}
+
+	auths := s.config.Auth
+	if auths == nil {
+		auths = []ServerAuth{&ServerAuthNone{}}
+	}
+	if s.protocolVersion == PROTO_VERS_3_3 {
+		return s.securityHandshake33(auths)
+	}
+	return s.securityHandshake38(auths)
+}
+
+func (s *ServerConn) securityHandshake33(auths []ServerAuth) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerConn.%s", logging.FnName())
+	}
+
+	// The server chooses the security type. 3.3 only knows None and VNCAuth.
+	var auth ServerAuth
+	for _, a := range auths {
+		if t := a.SecurityType(); t == secTypeNone || t == secTypeVNCAuth {
+			auth = a
+			break
+		}
+	}
+	if auth == nil {
+		reason := "no security types supported by protocol version 3.3"
+		if err := s.sendErrorReason(uint32(secTypeInvalid), reason); err != nil {
+			return err
+		}
+		return NewVNCError(fmt.Sprintf("Security handshake failed; %s", reason))
+	}
+	if err := s.send(uint32(auth.SecurityType())); err != nil {
+		return err
+	}
+
+	err := auth.Handshake(s)
+	// 3.3 sends no SecurityResult for None.
+	if auth.SecurityType() == secTypeNone {
+		return err
+	}
+	return s.securityResultHandshake(err)
+}
+
+func (s *ServerConn) securityHandshake38(auths []ServerAuth) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerConn.%s", logging.FnName())
+	}
+
+	if len(auths) == 0 {
+		reason := "no security types configured"
+		if err := s.sendErrorReason(uint8(0), reason); err != nil {
+			return err
+		}
+		return NewVNCError(fmt.Sprintf("Security handshake failed; %s", reason))
+	}
+	securityTypes := []uint8{uint8(len(auths))}
+	for _, a := range auths {
+		securityTypes = append(securityTypes, a.SecurityType())
+	}
+	if err := s.send(securityTypes); err != nil {
+		return err
+	}
+
+	// Read the security type chosen by the client.
+	var secType uint8
+	if err := s.receive(&secType); err != nil {
+		return err
+	}
+	if logging.V(logging.ResultLevel) {
+		logging.Infof("secType: %d", secType)
+	}
+	for _, a := range auths {
+		if a.SecurityType() == secType {
+			return s.securityResultHandshake(a.Handshake(s))
+		}
+	}
+	return s.securityResultHandshake(NewVNCError(fmt.Sprintf("unsupported security type %d", secType)))
+}
+
+// securityResultHandshake implements the server side of §7.1.3 SecurityResult
+// Handshake, for the result of the authentication handshake.
+func (s *ServerConn) securityResultHandshake(authErr error) error {
+	if authErr == nil {
+		return s.sendSecurityResult("")
+	}
+	if err := s.sendSecurityResult(authErr.Error()); err != nil {
+		return err
+	}
+	return NewVNCError(fmt.Sprintf("Security handshake failed; %v", authErr))
+}
+
+// sendSecurityResult sends a SecurityResult message, which is a failure when
+// reason is not empty.
+func (s *ServerConn) sendSecurityResult(reason string) error {
+	if reason == "" {
+		return s.send(uint32(0))
+	}
+	if s.protocolVersion == PROTO_VERS_3_3 {
+		return s.send(uint32(1))
+	}
+	return s.sendErrorReason(uint32(1), reason)
+}
+
+// sendErrorReason sends a failure status, of a width given by the type of
+// status, followed by the reason for the failure.
+func (s *ServerConn) sendErrorReason(status interface{}, reason string) error {
+	buf := NewBuffer(nil)
+	if err := buf.Write(status); err != nil {
+		return err
+	}
+	if err := buf.Write(uint32(len(reason))); err != nil {
+		return err
+	}
+	if err := buf.Write([]byte(reason)); err != nil {
+		return err
+	}
+	return s.send(buf.Bytes())
+}
@@ -86,5 +86,50 @@ func (c *ClientConn) serverInit() error {
 	}
 	c.setDesktopName(string(name))
 
+	if c.config.secType == secTypeTight {
+		if err := c.tightInit(); err != nil {
+			return Errorf("failure reading Tight capabilities; %v", err)
+		}
+	}
+
 	return nil
 }
+
+// clientInit implements the server side of §7.3.1 ClientInit.
+func (s *ServerConn) clientInit() error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerConn.%s", logging.FnName())
+	}
+
+	var sharedFlag rfbflags.RFBFlag
+	if err := s.receive(&sharedFlag); err != nil {
+		return err
+	}
+	if logging.V(logging.ResultLevel) {
+		logging.Infof("sharedFlag: %d", sharedFlag)
+	}
+	s.shared = rfbflags.ToBool(sharedFlag)
+	return nil
+}
+
+// serverInit implements the server side of §7.3.2 ServerInit.
+func (s *ServerConn) serverInit() error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerConn.%s", logging.FnName())
+	}
+
+	msg := ServerInit{
+		FBWidth:     s.fbWidth,
+		FBHeight:    s.fbHeight,
+		PixelFormat: s.pixelFormat,
+		NameLength:  uint32(len(s.config.DesktopName)),
+	}
+	buf := NewBuffer(nil)
+	if err := buf.Write(msg); err != nil {
+		return err
+	}
+	if err := buf.Write([]byte(s.config.DesktopName)); err != nil {
+		return err
+	}
+	return s.send(buf.Bytes())
+}
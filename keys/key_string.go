@@ -123,16 +123,16 @@ func _() {
 	_ = x[End-65367]
 	_ = x[Begin-65368]
 	_ = x[Select-65376]
-	_ = x[Print-65376]
-	_ = x[Execute-65376]
-	_ = x[Insert-65376]
-	_ = x[Undo-65376]
-	_ = x[Redo-65376]
-	_ = x[Menu-65376]
-	_ = x[Find-65376]
-	_ = x[Cancel-65376]
-	_ = x[Help-65376]
-	_ = x[Break-65376]
+	_ = x[Print-65377]
+	_ = x[Execute-65378]
+	_ = x[Insert-65379]
+	_ = x[Undo-65381]
+	_ = x[Redo-65382]
+	_ = x[Menu-65383]
+	_ = x[Find-65384]
+	_ = x[Cancel-65385]
+	_ = x[Help-65386]
+	_ = x[Break-65387]
 	_ = x[ModeSwitch-65406]
 	_ = x[NumLock-65407]
 	_ = x[KeypadSpace-65408]
@@ -148,29 +148,29 @@ func _() {
 	_ = x[KeypadRight-65432]
 	_ = x[KeypadDown-65433]
 	_ = x[KeypadPrior-65434]
-	_ = x[KeypadPageUp-65435]
-	_ = x[KeypadNext-65436]
-	_ = x[KeypadPageDown-65437]
-	_ = x[KeypadEnd-65438]
-	_ = x[KeypadBegin-65439]
-	_ = x[KeypadInsert-65440]
-	_ = x[KeypadDelete-65441]
-	_ = x[KeypadMultiply-65442]
-	_ = x[KeypadAdd-65443]
-	_ = x[KeypadSeparator-65444]
-	_ = x[KeypadSubtract-65445]
-	_ = x[KeypadDecimal-65446]
-	_ = x[KeypadDivide-65447]
-	_ = x[Keypad0-65448]
-	_ = x[Keypad1-65449]
-	_ = x[Keypad2-65450]
-	_ = x[Keypad3-65451]
-	_ = x[Keypad4-65452]
-	_ = x[Keypad5-65453]
-	_ = x[Keypad6-65454]
-	_ = x[Keypad7-65455]
-	_ = x[Keypad8-65456]
-	_ = x[Keypad9-65457]
+	_ = x[KeypadNext-65435]
+	_ = x[KeypadEnd-65436]
+	_ = x[KeypadBegin-65437]
+	_ = x[KeypadInsert-65438]
+	_ = x[KeypadDelete-65439]
+	_ = x[KeypadPageUp-65434]
+	_ = x[KeypadPageDown-65435]
+	_ = x[KeypadMultiply-65450]
+	_ = x[KeypadAdd-65451]
+	_ = x[KeypadSeparator-65452]
+	_ = x[KeypadSubtract-65453]
+	_ = x[KeypadDecimal-65454]
+	_ = x[KeypadDivide-65455]
+	_ = x[Keypad0-65456]
+	_ = x[Keypad1-65457]
+	_ = x[Keypad2-65458]
+	_ = x[Keypad3-65459]
+	_ = x[Keypad4-65460]
+	_ = x[Keypad5-65461]
+	_ = x[Keypad6-65462]
+	_ = x[Keypad7-65463]
+	_ = x[Keypad8-65464]
+	_ = x[Keypad9-65465]
 	_ = x[KeypadEqual-65469]
 	_ = x[F1-65470]
 	_ = x[F2-65471]
@@ -200,7 +200,7 @@ func _() {
 	_ = x[HyperRight-65518]
 }
 
-const _Key_name = "SpaceExclaimQuoteDblNumberSignDollarPercentAmpersandApostropheParenLeftParenRightAsteriskPlusCommaMinusPeriodSlashDigit0Digit1Digit2Digit3Digit4Digit5Digit6Digit7Digit8Digit9ColonSemicolonLessEqualGreaterQuestionAtABCDEFGHIJKLMNOPQRSTUVWXYZBracketLeftBackslashBracketRightAsciiCircumUnderscoreGraveSmallASmallBSmallCSmallDSmallESmallFSmallGSmallHSmallISmallJSmallKSmallLSmallMSmallNSmallOSmallPSmallQSmallRSmallSSmallTSmallUSmallVSmallWSmallXSmallYSmallZBraceLeftBarBraceRightAsciiTildeBackSpaceTabLinefeedClearReturnPauseScrollLockSysReqEscapeHomeLeftUpRightDownPageUpPageDownEndBeginSelectModeSwitchNumLockKeypadSpaceKeypadTabKeypadEnterKeypadF1KeypadF2KeypadF3KeypadF4KeypadHomeKeypadLeftKeypadUpKeypadRightKeypadDownKeypadPriorKeypadPageUpKeypadNextKeypadPageDownKeypadEndKeypadBeginKeypadInsertKeypadDeleteKeypadMultiplyKeypadAddKeypadSeparatorKeypadSubtractKeypadDecimalKeypadDivideKeypad0Keypad1Keypad2Keypad3Keypad4Keypad5Keypad6Keypad7Keypad8Keypad9KeypadEqualF1F2F3F4F5F6F7F8F9F10F11F12ShiftLeftShiftRightControlLeftControlRightCapsLockShiftLockMetaLeftMetaRightAltLeftAltRightSuperLeftSuperRightHyperLeftHyperRightDelete"
+const _Key_name = "SpaceExclaimQuoteDblNumberSignDollarPercentAmpersandApostropheParenLeftParenRightAsteriskPlusCommaMinusPeriodSlashDigit0Digit1Digit2Digit3Digit4Digit5Digit6Digit7Digit8Digit9ColonSemicolonLessEqualGreaterQuestionAtABCDEFGHIJKLMNOPQRSTUVWXYZBracketLeftBackslashBracketRightAsciiCircumUnderscoreGraveSmallASmallBSmallCSmallDSmallESmallFSmallGSmallHSmallISmallJSmallKSmallLSmallMSmallNSmallOSmallPSmallQSmallRSmallSSmallTSmallUSmallVSmallWSmallXSmallYSmallZBraceLeftBarBraceRightAsciiTildeBackSpaceTabLinefeedClearReturnPauseScrollLockSysReqEscapeHomeLeftUpRightDownPageUpPageDownEndBeginSelectPrintExecuteInsertUndoRedoMenuFindCancelHelpBreakModeSwitchNumLockKeypadSpaceKeypadTabKeypadEnterKeypadF1KeypadF2KeypadF3KeypadF4KeypadHomeKeypadLeftKeypadUpKeypadRightKeypadDownKeypadPriorKeypadNextKeypadEndKeypadBeginKeypadInsertKeypadDeleteKeypadMultiplyKeypadAddKeypadSeparatorKeypadSubtractKeypadDecimalKeypadDivideKeypad0Keypad1Keypad2Keypad3Keypad4Keypad5Keypad6Keypad7Keypad8Keypad9KeypadEqualF1F2F3F4F5F6F7F8F9F10F11F12ShiftLeftShiftRightControlLeftControlRightCapsLockShiftLockMetaLeftMetaRightAltLeftAltRightSuperLeftSuperRightHyperLeftHyperRightDelete"
 
 var _Key_map = map[Key]string{
 	32:    _Key_name[0:5],
@@ -317,72 +317,80 @@ var _Key_map = map[Key]string{
 	65367: _Key_name[577:580],
 	65368: _Key_name[580:585],
 	65376: _Key_name[585:591],
-	65406: _Key_name[591:601],
-	65407: _Key_name[601:608],
-	65408: _Key_name[608:619],
-	65417: _Key_name[619:628],
-	65421: _Key_name[628:639],
-	65425: _Key_name[639:647],
-	65426: _Key_name[647:655],
-	65427: _Key_name[655:663],
-	65428: _Key_name[663:671],
-	65429: _Key_name[671:681],
-	65430: _Key_name[681:691],
-	65431: _Key_name[691:699],
-	65432: _Key_name[699:710],
-	65433: _Key_name[710:720],
-	65434: _Key_name[720:731],
-	65435: _Key_name[731:743],
-	65436: _Key_name[743:753],
-	65437: _Key_name[753:767],
-	65438: _Key_name[767:776],
-	65439: _Key_name[776:787],
-	65440: _Key_name[787:799],
-	65441: _Key_name[799:811],
-	65442: _Key_name[811:825],
-	65443: _Key_name[825:834],
-	65444: _Key_name[834:849],
-	65445: _Key_name[849:863],
-	65446: _Key_name[863:876],
-	65447: _Key_name[876:888],
-	65448: _Key_name[888:895],
-	65449: _Key_name[895:902],
-	65450: _Key_name[902:909],
-	65451: _Key_name[909:916],
-	65452: _Key_name[916:923],
-	65453: _Key_name[923:930],
-	65454: _Key_name[930:937],
-	65455: _Key_name[937:944],
-	65456: _Key_name[944:951],
-	65457: _Key_name[951:958],
-	65469: _Key_name[958:969],
-	65470: _Key_name[969:971],
-	65471: _Key_name[971:973],
-	65472: _Key_name[973:975],
-	65473: _Key_name[975:977],
-	65474: _Key_name[977:979],
-	65475: _Key_name[979:981],
-	65476: _Key_name[981:983],
-	65477: _Key_name[983:985],
-	65478: _Key_name[985:987],
-	65479: _Key_name[987:990],
-	65480: _Key_name[990:993],
-	65481: _Key_name[993:996],
-	65505: _Key_name[996:1005],
-	65506: _Key_name[1005:1015],
-	65507: _Key_name[1015:1026],
-	65508: _Key_name[1026:1038],
-	65509: _Key_name[1038:1046],
-	65510: _Key_name[1046:1055],
-	65511: _Key_name[1055:1063],
-	65512: _Key_name[1063:1072],
-	65513: _Key_name[1072:1079],
-	65514: _Key_name[1079:1087],
-	65515: _Key_name[1087:1096],
-	65516: _Key_name[1096:1106],
-	65517: _Key_name[1106:1115],
-	65518: _Key_name[1115:1125],
-	65535: _Key_name[1125:1131],
+	65377: _Key_name[591:596],
+	65378: _Key_name[596:603],
+	65379: _Key_name[603:609],
+	65381: _Key_name[609:613],
+	65382: _Key_name[613:617],
+	65383: _Key_name[617:621],
+	65384: _Key_name[621:625],
+	65385: _Key_name[625:631],
+	65386: _Key_name[631:635],
+	65387: _Key_name[635:640],
+	65406: _Key_name[640:650],
+	65407: _Key_name[650:657],
+	65408: _Key_name[657:668],
+	65417: _Key_name[668:677],
+	65421: _Key_name[677:688],
+	65425: _Key_name[688:696],
+	65426: _Key_name[696:704],
+	65427: _Key_name[704:712],
+	65428: _Key_name[712:720],
+	65429: _Key_name[720:730],
+	65430: _Key_name[730:740],
+	65431: _Key_name[740:748],
+	65432: _Key_name[748:759],
+	65433: _Key_name[759:769],
+	65434: _Key_name[769:780],
+	65435: _Key_name[780:790],
+	65436: _Key_name[790:799],
+	65437: _Key_name[799:810],
+	65438: _Key_name[810:822],
+	65439: _Key_name[822:834],
+	65450: _Key_name[834:848],
+	65451: _Key_name[848:857],
+	65452: _Key_name[857:872],
+	65453: _Key_name[872:886],
+	65454: _Key_name[886:899],
+	65455: _Key_name[899:911],
+	65456: _Key_name[911:918],
+	65457: _Key_name[918:925],
+	65458: _Key_name[925:932],
+	65459: _Key_name[932:939],
+	65460: _Key_name[939:946],
+	65461: _Key_name[946:953],
+	65462: _Key_name[953:960],
+	65463: _Key_name[960:967],
+	65464: _Key_name[967:974],
+	65465: _Key_name[974:981],
+	65469: _Key_name[981:992],
+	65470: _Key_name[992:994],
+	65471: _Key_name[994:996],
+	65472: _Key_name[996:998],
+	65473: _Key_name[998:1000],
+	65474: _Key_name[1000:1002],
+	65475: _Key_name[1002:1004],
+	65476: _Key_name[1004:1006],
+	65477: _Key_name[1006:1008],
+	65478: _Key_name[1008:1010],
+	65479: _Key_name[1010:1013],
+	65480: _Key_name[1013:1016],
+	65481: _Key_name[1016:1019],
+	65505: _Key_name[1019:1028],
+	65506: _Key_name[1028:1038],
+	65507: _Key_name[1038:1049],
+	65508: _Key_name[1049:1061],
+	65509: _Key_name[1061:1069],
+	65510: _Key_name[1069:1078],
+	65511: _Key_name[1078:1086],
+	65512: _Key_name[1086:1095],
+	65513: _Key_name[1095:1102],
+	65514: _Key_name[1102:1110],
+	65515: _Key_name[1110:1119],
+	65516: _Key_name[1119:1129],
+	65517: _Key_name[1129:1138],
+	65518: _Key_name[1138:1148],
+	65535: _Key_name[1148:1154],
 }
 
 func (i Key) String() string {
@@ -197,10 +197,11 @@ const ( // Cursor control & motion.
 	Begin
 )
 const ( // Misc functions.
-	Select Key = 0xff60
+	Select Key = iota + 0xff60
 	Print
 	Execute
 	Insert
+	_
 	Undo
 	Redo
 	Menu
@@ -227,14 +228,16 @@ const ( // Keypad functions cont.
 	KeypadRight
 	KeypadDown
 	KeypadPrior
-	KeypadPageUp
 	KeypadNext
-	KeypadPageDown
 	KeypadEnd
 	KeypadBegin
 	KeypadInsert
 	KeypadDelete
-	KeypadMultiply
+	KeypadPageUp   = KeypadPrior
+	KeypadPageDown = KeypadNext
+)
+const ( // Keypad functions cont.
+	KeypadMultiply Key = iota + 0xffaa
 	KeypadAdd
 	KeypadSeparator
 	KeypadSubtract
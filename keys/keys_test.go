@@ -49,6 +49,14 @@ func TestControlKeyValues(t *testing.T) {
 		{"Return", Return, 0xff0d},
 		{"Escape", Escape, 0xff1b},
 		{"Delete", Delete, 0xffff},
+		{"Insert", Insert, 0xff63},
+		{"Menu", Menu, 0xff67},
+		{"Break", Break, 0xff6b},
+		{"KeypadPageUp", KeypadPageUp, 0xff9a},
+		{"KeypadDelete", KeypadDelete, 0xff9f},
+		{"KeypadMultiply", KeypadMultiply, 0xffaa},
+		{"Keypad0", Keypad0, 0xffb0},
+		{"Keypad9", Keypad9, 0xffb9},
 	}
 	for _, tt := range tests {
 		if tt.key != tt.want {
@@ -128,3 +136,29 @@ func TestTextToKeys(t *testing.T) {
 		})
 	}
 }
+
+func TestXTScancode(t *testing.T) {
+	for _, tt := range []struct {
+		key  Key
+		code uint32
+		ok   bool
+	}{
+		{Escape, 0x01, true},
+		{SmallA, 0x1e, true},
+		{A, 0x1e, true},
+		{Exclaim, 0x02, true},
+		{Return, 0x1c, true},
+		{F12, 0x58, true},
+		{Keypad5, 0x4c, true},
+		{KeypadEnter, 0xe01c, true},
+		{Up, 0xe048, true},
+		{Insert, 0xe052, true},
+		{Delete, 0xe053, true},
+		{Key(0xe9), 0, false}, // eacute
+	} {
+		code, ok := XTScancode(tt.key)
+		if ok != tt.ok || code != tt.code {
+			t.Errorf("XTScancode(%v) = %#x, %v; want %#x, %v", tt.key, code, ok, tt.code, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package keys
+
+// XTScancode returns the XT (PC/AT scan code set 1) make code of the key that
+// produces k on a US keyboard layout, and false if there is none. Codes of
+// extended keys include the 0xE0 prefix in their high byte, e.g. 0xE048 for Up.
+//
+// Shifted characters return the code of their unshifted key, e.g. both A and
+// SmallA return 0x1E; the shift key must be sent separately.
+func XTScancode(k Key) (uint32, bool) {
+	if k >= A && k <= Z {
+		k += SmallA - A
+	}
+	code, ok := xtScancodes[k]
+	return code, ok
+}
+
+// xtScancodes maps keys to the XT scan codes of a US keyboard layout.
+var xtScancodes = map[Key]uint32{
+	// Main block.
+	Escape:       0x01,
+	Digit1:       0x02,
+	Exclaim:      0x02,
+	Digit2:       0x03,
+	At:           0x03,
+	Digit3:       0x04,
+	NumberSign:   0x04,
+	Digit4:       0x05,
+	Dollar:       0x05,
+	Digit5:       0x06,
+	Percent:      0x06,
+	Digit6:       0x07,
+	AsciiCircum:  0x07,
+	Digit7:       0x08,
+	Ampersand:    0x08,
+	Digit8:       0x09,
+	Asterisk:     0x09,
+	Digit9:       0x0a,
+	ParenLeft:    0x0a,
+	Digit0:       0x0b,
+	ParenRight:   0x0b,
+	Minus:        0x0c,
+	Underscore:   0x0c,
+	Equal:        0x0d,
+	Plus:         0x0d,
+	BackSpace:    0x0e,
+	Tab:          0x0f,
+	SmallQ:       0x10,
+	SmallW:       0x11,
+	SmallE:       0x12,
+	SmallR:       0x13,
+	SmallT:       0x14,
+	SmallY:       0x15,
+	SmallU:       0x16,
+	SmallI:       0x17,
+	SmallO:       0x18,
+	SmallP:       0x19,
+	BracketLeft:  0x1a,
+	BraceLeft:    0x1a,
+	BracketRight: 0x1b,
+	BraceRight:   0x1b,
+	Return:       0x1c,
+	Linefeed:     0x1c,
+	ControlLeft:  0x1d,
+	SmallA:       0x1e,
+	SmallS:       0x1f,
+	SmallD:       0x20,
+	SmallF:       0x21,
+	SmallG:       0x22,
+	SmallH:       0x23,
+	SmallJ:       0x24,
+	SmallK:       0x25,
+	SmallL:       0x26,
+	Semicolon:    0x27,
+	Colon:        0x27,
+	Apostrophe:   0x28,
+	QuoteDbl:     0x28,
+	Grave:        0x29,
+	AsciiTilde:   0x29,
+	ShiftLeft:    0x2a,
+	Backslash:    0x2b,
+	Bar:          0x2b,
+	SmallZ:       0x2c,
+	SmallX:       0x2d,
+	SmallC:       0x2e,
+	SmallV:       0x2f,
+	SmallB:       0x30,
+	SmallN:       0x31,
+	SmallM:       0x32,
+	Comma:        0x33,
+	Less:         0x33,
+	Period:       0x34,
+	Greater:      0x34,
+	Slash:        0x35,
+	Question:     0x35,
+	ShiftRight:   0x36,
+	AltLeft:      0x38,
+	Space:        0x39,
+	CapsLock:     0x3a,
+
+	// Function keys.
+	F1:  0x3b,
+	F2:  0x3c,
+	F3:  0x3d,
+	F4:  0x3e,
+	F5:  0x3f,
+	F6:  0x40,
+	F7:  0x41,
+	F8:  0x42,
+	F9:  0x43,
+	F10: 0x44,
+	F11: 0x57,
+	F12: 0x58,
+
+	// Keypad.
+	NumLock:        0x45,
+	ScrollLock:     0x46,
+	Keypad7:        0x47,
+	KeypadHome:     0x47,
+	Keypad8:        0x48,
+	KeypadUp:       0x48,
+	Keypad9:        0x49,
+	KeypadPrior:    0x49,
+	KeypadSubtract: 0x4a,
+	Keypad4:        0x4b,
+	KeypadLeft:     0x4b,
+	Keypad5:        0x4c,
+	KeypadBegin:    0x4c,
+	Keypad6:        0x4d,
+	KeypadRight:    0x4d,
+	KeypadAdd:      0x4e,
+	Keypad1:        0x4f,
+	KeypadEnd:      0x4f,
+	Keypad2:        0x50,
+	KeypadDown:     0x50,
+	Keypad3:        0x51,
+	KeypadNext:     0x51,
+	Keypad0:        0x52,
+	KeypadInsert:   0x52,
+	KeypadDecimal:  0x53,
+	KeypadDelete:   0x53,
+	KeypadMultiply: 0x37,
+	KeypadEnter:    0xe01c,
+	KeypadDivide:   0xe035,
+	SysReq:         0x54,
+
+	// Extended keys.
+	ControlRight: 0xe01d,
+	Print:        0xe037,
+	AltRight:     0xe038,
+	Pause:        0xe046,
+	Home:         0xe047,
+	Up:           0xe048,
+	PageUp:       0xe049,
+	Left:         0xe04b,
+	Right:        0xe04d,
+	End:          0xe04f,
+	Down:         0xe050,
+	PageDown:     0xe051,
+	Insert:       0xe052,
+	Delete:       0xe053,
+	SuperLeft:    0xe05b,
+	SuperRight:   0xe05c,
+	Menu:         0xe05d,
+}
@@ -14,15 +14,25 @@ func _() {
 	_ = x[KeyEvent-4]
 	_ = x[PointerEvent-5]
 	_ = x[ClientCutText-6]
+	_ = x[EnableContinuousUpdates-150]
+	_ = x[ClientFence-248]
+	_ = x[ClientXVP-250]
+	_ = x[SetDesktopSize-251]
+	_ = x[ClientQEMU-255]
 }
 
 const (
 	_ClientMessage_name_0 = "SetPixelFormat"
 	_ClientMessage_name_1 = "SetEncodingsFramebufferUpdateRequestKeyEventPointerEventClientCutText"
+	_ClientMessage_name_2 = "EnableContinuousUpdates"
+	_ClientMessage_name_3 = "ClientFence"
+	_ClientMessage_name_4 = "ClientXVPSetDesktopSize"
+	_ClientMessage_name_5 = "ClientQEMU"
 )
 
 var (
 	_ClientMessage_index_1 = [...]uint8{0, 12, 36, 44, 56, 69}
+	_ClientMessage_index_4 = [...]uint8{0, 9, 23}
 )
 
 func (i ClientMessage) String() string {
@@ -32,6 +42,15 @@ func (i ClientMessage) String() string {
 	case 2 <= i && i <= 6:
 		i -= 2
 		return _ClientMessage_name_1[_ClientMessage_index_1[i]:_ClientMessage_index_1[i+1]]
+	case i == 150:
+		return _ClientMessage_name_2
+	case i == 248:
+		return _ClientMessage_name_3
+	case 250 <= i && i <= 251:
+		i -= 250
+		return _ClientMessage_name_4[_ClientMessage_index_4[i]:_ClientMessage_index_4[i+1]]
+	case i == 255:
+		return _ClientMessage_name_5
 	default:
 		return "ClientMessage(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
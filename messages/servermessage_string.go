@@ -12,16 +12,37 @@ func _() {
 	_ = x[SetColorMapEntries-1]
 	_ = x[Bell-2]
 	_ = x[ServerCutText-3]
+	_ = x[EndOfContinuousUpdates-150]
+	_ = x[ServerFence-248]
+	_ = x[ServerXVP-250]
+	_ = x[ServerQEMU-255]
 }
 
-const _ServerMessage_name = "FramebufferUpdateSetColorMapEntriesBellServerCutText"
+const (
+	_ServerMessage_name_0 = "FramebufferUpdateSetColorMapEntriesBellServerCutText"
+	_ServerMessage_name_1 = "EndOfContinuousUpdates"
+	_ServerMessage_name_2 = "ServerFence"
+	_ServerMessage_name_3 = "ServerXVP"
+	_ServerMessage_name_4 = "ServerQEMU"
+)
 
-var _ServerMessage_index = [...]uint8{0, 17, 35, 39, 52}
+var (
+	_ServerMessage_index_0 = [...]uint8{0, 17, 35, 39, 52}
+)
 
 func (i ServerMessage) String() string {
-	idx := int(i) - 0
-	if i < 0 || idx >= len(_ServerMessage_index)-1 {
+	switch {
+	case i <= 3:
+		return _ServerMessage_name_0[_ServerMessage_index_0[i]:_ServerMessage_index_0[i+1]]
+	case i == 150:
+		return _ServerMessage_name_1
+	case i == 248:
+		return _ServerMessage_name_2
+	case i == 250:
+		return _ServerMessage_name_3
+	case i == 255:
+		return _ServerMessage_name_4
+	default:
 		return "ServerMessage(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _ServerMessage_name[_ServerMessage_index[idx]:_ServerMessage_index[idx+1]]
 }
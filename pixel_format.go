@@ -123,3 +123,50 @@ func (pf PixelFormat) order() binary.ByteOrder {
 	}
 	return binary.LittleEndian
 }
+
+// cpixel returns the size in bytes of a compressed pixel value (CPIXEL), as
+// used by the TRLE and ZRLE encodings, and its offset within the bytes of a
+// full pixel value.
+//
+// A CPIXEL is the same as a PIXEL, except where the true-color-flag is set,
+// bits-per-pixel is 32, depth is 24 or less, and all of the bits making up the
+// red, green, and blue intensities fit in either the least significant 3 bytes
+// or the most significant 3 bytes. In this case, a CPIXEL is only 3 bytes long.
+//
+// See RFC 6143 §7.7.5.
+func (pf PixelFormat) cpixel() (size, offset int) {
+	bytesPerPixel := int(pf.BPP / 8)
+	if !rfbflags.IsTrueColor(pf.TrueColor) || pf.BPP != 32 || pf.Depth > 24 {
+		return bytesPerPixel, 0
+	}
+
+	mask := uint32(pf.RedMax)<<pf.RedShift | uint32(pf.GreenMax)<<pf.GreenShift | uint32(pf.BlueMax)<<pf.BlueShift
+	bigEndian := rfbflags.IsBigEndian(pf.BigEndian)
+	switch {
+	case mask&0xff000000 == 0: // Least significant 3 bytes.
+		if bigEndian {
+			return 3, 1
+		}
+		return 3, 0
+	case mask&0x000000ff == 0: // Most significant 3 bytes.
+		if bigEndian {
+			return 3, 0
+		}
+		return 3, 1
+	}
+	return bytesPerPixel, 0
+}
+
+// tpixelSize returns the size in bytes of a Tight pixel value (TPIXEL).
+//
+// A TPIXEL is the same as a PIXEL, except where the true-color-flag is set,
+// bits-per-pixel is 32, depth is 24, and all of red-max, green-max and
+// blue-max are 255. In this case, a TPIXEL is 3 bytes long, holding the red,
+// green and blue intensities in that order.
+func (pf PixelFormat) tpixelSize() int {
+	if rfbflags.IsTrueColor(pf.TrueColor) && pf.BPP == 32 && pf.Depth == 24 &&
+		pf.RedMax == 255 && pf.GreenMax == 255 && pf.BlueMax == 255 {
+		return 3
+	}
+	return int(pf.BPP / 8)
+}
@@ -143,3 +143,30 @@ func equalPixelFormat(g, w PixelFormat) bool {
 	}
 	return operators.EqualSlicesOfByte(got, want)
 }
+
+func TestPixelFormat_cpixel(t *testing.T) {
+	for _, tt := range []struct {
+		desc         string
+		pf           PixelFormat
+		size, offset int
+	}{
+		{"8 bpp", PixelFormat8bit, 1, 0},
+		{"16 bpp", PixelFormat16bit, 2, 0},
+		{"32 bpp, depth 32", PixelFormat32bit, 4, 0},
+		{"32 bpp, depth 24, big-endian, low bytes",
+			PixelFormat{32, 24, rfbflags.RFBTrue, rfbflags.RFBTrue, 255, 255, 255, 16, 8, 0, [3]byte{}}, 3, 1},
+		{"32 bpp, depth 24, little-endian, low bytes",
+			PixelFormat{32, 24, rfbflags.RFBFalse, rfbflags.RFBTrue, 255, 255, 255, 16, 8, 0, [3]byte{}}, 3, 0},
+		{"32 bpp, depth 24, big-endian, high bytes",
+			PixelFormat{32, 24, rfbflags.RFBTrue, rfbflags.RFBTrue, 255, 255, 255, 24, 16, 8, [3]byte{}}, 3, 0},
+		{"32 bpp, depth 24, little-endian, high bytes",
+			PixelFormat{32, 24, rfbflags.RFBFalse, rfbflags.RFBTrue, 255, 255, 255, 24, 16, 8, [3]byte{}}, 3, 1},
+		{"32 bpp, depth 24, color mapped",
+			PixelFormat{32, 24, rfbflags.RFBTrue, rfbflags.RFBFalse, 255, 255, 255, 16, 8, 0, [3]byte{}}, 4, 0},
+	} {
+		size, offset := tt.pf.cpixel()
+		if size != tt.size || offset != tt.offset {
+			t.Errorf("%s: cpixel() = %d, %d; want %d, %d", tt.desc, size, offset, tt.size, tt.offset)
+		}
+	}
+}
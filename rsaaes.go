@@ -0,0 +1,359 @@
+/*
+Implementation of the RSA-AES security types.
+
+The RSA-AES security types of RealVNC and TigerVNC are not part of RFC 6143,
+and are documented by the community maintained RFB protocol specification.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#rsa-aes-security-type
+*/
+package vnc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+
+	"github.com/kward/go-vnc/logging"
+)
+
+// Sub-types of the RSA-AES authentication, sent by the server.
+const (
+	rsaAESUserPass = uint8(1)
+	rsaAESPass     = uint8(2)
+)
+
+// Sizes of RSA keys, in bits.
+const (
+	rsaAESMinKeyBits    = 1024
+	rsaAESMaxKeyBits    = 8192
+	rsaAESClientKeyBits = 2048
+)
+
+// ClientAuthRSAAES is the RSA-AES authentication. The client and server agree
+// on session keys by RSA key exchange, then the client authenticates with the
+// Username and Password of the ClientConfig over a channel encrypted with AES
+// in EAX mode. Unless Unencrypted is set, the channel encrypts all later
+// messages too.
+//
+// The four combinations of AES256 and Unencrypted are the RSA-AES (5),
+// RSA-AES Unencrypted (6), RSA-AES-256 (129) and RSA-AES-256 Unencrypted
+// (130) security types.
+type ClientAuthRSAAES struct {
+	// AES256 selects AES-256 and SHA-256, rather than AES-128 and SHA-1.
+	AES256 bool
+
+	// Unencrypted stops encrypting messages after authentication.
+	Unencrypted bool
+
+	// VerifyServerKey is called with the public key of the server, e.g. to
+	// compare its fingerprint with a known one. If it returns an error, then
+	// the handshake fails. If it is not set, then all keys are accepted.
+	VerifyServerKey func(key *rsa.PublicKey) error
+}
+
+// Verify that interfaces are honored.
+var _ ClientAuth = (*ClientAuthRSAAES)(nil)
+
+func (auth *ClientAuthRSAAES) SecurityType() uint8 {
+	switch {
+	case auth.AES256 && auth.Unencrypted:
+		return secTypeRAne256
+	case auth.AES256:
+		return secTypeRA256
+	case auth.Unencrypted:
+		return secTypeRA2ne
+	}
+	return secTypeRA2
+}
+
+func (auth *ClientAuthRSAAES) Handshake(conn *ClientConn) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientAuthRSAAES.%s", logging.FnName())
+	}
+
+	// Exchange public keys.
+	serverKey, serverKeyBlob, err := receiveRSAAESPublicKey(conn)
+	if err != nil {
+		return err
+	}
+	if auth.VerifyServerKey != nil {
+		if err := auth.VerifyServerKey(serverKey); err != nil {
+			return NewVNCError(fmt.Sprintf("Security Handshake failed; server key rejected: %v", err))
+		}
+	}
+	clientKey, err := rsa.GenerateKey(rand.Reader, rsaAESClientKeyBits)
+	if err != nil {
+		return err
+	}
+	clientKeyBlob := rsaAESPublicKeyBlob(&clientKey.PublicKey)
+	if err := conn.send(clientKeyBlob); err != nil {
+		return err
+	}
+
+	// Exchange randoms, each encrypted with the public key of its receiver.
+	keySize := auth.keySize()
+	clientRandom := make([]byte, keySize)
+	if _, err := rand.Read(clientRandom); err != nil {
+		return err
+	}
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, serverKey, clientRandom)
+	if err != nil {
+		return err
+	}
+	buf := NewBuffer(nil)
+	if err := buf.Write(uint16(len(encrypted))); err != nil {
+		return err
+	}
+	if err := buf.Write(encrypted); err != nil {
+		return err
+	}
+	if err := conn.send(buf.Bytes()); err != nil {
+		return err
+	}
+	var length uint16
+	if err := conn.receive(&length); err != nil {
+		return err
+	}
+	if int(length) != clientKey.Size() {
+		return NewVNCError(fmt.Sprintf("Security Handshake failed; invalid RSA-AES server random length %d", length))
+	}
+	encrypted = nil
+	if err := conn.receiveN(&encrypted, int(length)); err != nil {
+		return err
+	}
+	serverRandom, err := rsa.DecryptPKCS1v15(nil, clientKey, encrypted)
+	if err != nil || len(serverRandom) != keySize {
+		return NewVNCError("Security Handshake failed; invalid RSA-AES server random")
+	}
+
+	// Encrypt the connection, and verify that neither key was replaced.
+	raw := conn.c
+	rc, err := newRSAAESConn(raw,
+		auth.sessionKey(clientRandom, serverRandom),
+		auth.sessionKey(serverRandom, clientRandom))
+	if err != nil {
+		return err
+	}
+	conn.c = rc
+	if err := conn.send(auth.sum(clientKeyBlob, serverKeyBlob)); err != nil {
+		return err
+	}
+	serverHash := make([]byte, auth.hash().Size())
+	if err := conn.receive(serverHash); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(serverHash, auth.sum(serverKeyBlob, clientKeyBlob)) != 1 {
+		return NewVNCError("Security Handshake failed; RSA-AES key hashes do not match")
+	}
+
+	// Authenticate.
+	var subType uint8
+	if err := conn.receive(&subType); err != nil {
+		return err
+	}
+	username, password := conn.config.Username, conn.config.Password
+	switch subType {
+	case rsaAESUserPass:
+		if username == "" {
+			return NewVNCError("Security Handshake failed; no username provided for RSA-AES")
+		}
+	case rsaAESPass:
+		username = ""
+	default:
+		return NewVNCError(fmt.Sprintf("Security Handshake failed; invalid RSA-AES sub-type %d", subType))
+	}
+	if len(username) > 255 || len(password) > 255 {
+		return NewVNCError("Security Handshake failed; RSA-AES credentials longer than 255 bytes")
+	}
+	buf = NewBuffer(nil)
+	for _, s := range []string{username, password} {
+		if err := buf.Write(uint8(len(s))); err != nil {
+			return err
+		}
+		if err := buf.Write([]byte(s)); err != nil {
+			return err
+		}
+	}
+	if err := conn.send(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if auth.Unencrypted {
+		conn.c = raw
+	}
+	return nil
+}
+
+// keySize returns the size of the AES keys, in bytes.
+func (auth *ClientAuthRSAAES) keySize() int {
+	if auth.AES256 {
+		return 32
+	}
+	return 16
+}
+
+// hash returns a new hash of the security type.
+func (auth *ClientAuthRSAAES) hash() hash.Hash {
+	if auth.AES256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+// sum returns the hash of the concatenated data.
+func (auth *ClientAuthRSAAES) sum(data ...[]byte) []byte {
+	h := auth.hash()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// sessionKey returns the AES key derived from the randoms. The key of the
+// messages sent by the client hashes the server random first, and that of
+// the messages sent by the server hashes the client random first.
+func (auth *ClientAuthRSAAES) sessionKey(first, second []byte) []byte {
+	return auth.sum(first, second)[:auth.keySize()]
+}
+
+// receiveRSAAESPublicKey receives an RSA public key, and returns it along with
+// its serialized form.
+func receiveRSAAESPublicKey(conn *ClientConn) (*rsa.PublicKey, []byte, error) {
+	var bits uint32
+	if err := conn.receive(&bits); err != nil {
+		return nil, nil, err
+	}
+	if bits < rsaAESMinKeyBits || bits > rsaAESMaxKeyBits {
+		return nil, nil, NewVNCError(fmt.Sprintf("Security Handshake failed; unsupported RSA-AES key length %d", bits))
+	}
+	size := int(bits+7) / 8
+	var n, e []byte
+	if err := conn.receiveN(&n, size); err != nil {
+		return nil, nil, err
+	}
+	if err := conn.receiveN(&e, size); err != nil {
+		return nil, nil, err
+	}
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, nil, NewVNCError("Security Handshake failed; invalid RSA-AES public exponent")
+	}
+	blob := make([]byte, 4, 4+2*size)
+	binary.BigEndian.PutUint32(blob, bits)
+	blob = append(append(blob, n...), e...)
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, blob, nil
+}
+
+// rsaAESPublicKeyBlob returns the serialized form of key: its length in bits,
+// and its modulus and exponent of the size of the modulus.
+func rsaAESPublicKeyBlob(key *rsa.PublicKey) []byte {
+	bits := key.N.BitLen()
+	size := (bits + 7) / 8
+	b := make([]byte, 4+2*size)
+	binary.BigEndian.PutUint32(b, uint32(bits))
+	key.N.FillBytes(b[4 : 4+size])
+	big.NewInt(int64(key.E)).FillBytes(b[4+size:])
+	return b
+}
+
+// rsaAESMaxMessageSize is the maximum size of the messages written to an
+// rsaAESConn.
+const rsaAESMaxMessageSize = 8192
+
+// rsaAESConn is a connection encrypted with AES in EAX mode. Each message is
+// its length, the encrypted data, and the tag authenticating both. The nonce
+// is a little-endian message counter, separate in each direction.
+type rsaAESConn struct {
+	net.Conn
+
+	in      cipher.AEAD
+	inNonce [16]byte
+	buf     []byte // Decrypted data not read yet.
+
+	mu       sync.Mutex // Guards out and outNonce.
+	out      cipher.AEAD
+	outNonce [16]byte
+}
+
+// Verify that interfaces are honored.
+var _ net.Conn = (*rsaAESConn)(nil)
+
+// newRSAAESConn returns c encrypted with the AES keys of the messages read
+// and written.
+func newRSAAESConn(c net.Conn, inKey, outKey []byte) (*rsaAESConn, error) {
+	in, err := aes.NewCipher(inKey)
+	if err != nil {
+		return nil, err
+	}
+	out, err := aes.NewCipher(outKey)
+	if err != nil {
+		return nil, err
+	}
+	return &rsaAESConn{Conn: c, in: newEAX(in), out: newEAX(out)}, nil
+}
+
+// incNonce increments the little-endian counter.
+func incNonce(nonce *[16]byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+// Read implements the net.Conn interface.
+func (c *rsaAESConn) Read(b []byte) (int, error) {
+	for len(c.buf) == 0 {
+		var header [2]byte
+		if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+			return 0, err
+		}
+		msg := make([]byte, int(binary.BigEndian.Uint16(header[:]))+c.in.Overhead())
+		if _, err := io.ReadFull(c.Conn, msg); err != nil {
+			return 0, err
+		}
+		data, err := c.in.Open(msg[:0], c.inNonce[:], msg, header[:])
+		if err != nil {
+			return 0, NewVNCError(fmt.Sprintf("RSA-AES message invalid: %v", err))
+		}
+		incNonce(&c.inNonce)
+		c.buf = data
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Write implements the net.Conn interface.
+func (c *rsaAESConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var msg bytes.Buffer
+	for n := 0; n < len(b); {
+		data := b[n:min(len(b), n+rsaAESMaxMessageSize)]
+		var header [2]byte
+		binary.BigEndian.PutUint16(header[:], uint16(len(data)))
+		msg.Reset()
+		msg.Write(header[:])
+		msg.Write(c.out.Seal(nil, c.outNonce[:], data, header[:]))
+		if _, err := c.Conn.Write(msg.Bytes()); err != nil {
+			return n, err
+		}
+		incNonce(&c.outNonce)
+		n += len(data)
+	}
+	return len(b), nil
+}
@@ -0,0 +1,224 @@
+package vnc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+)
+
+// serveRSAAES performs the server side of the RSA-AES authentication of auth
+// over c, requesting the sub-type. It returns the credentials sent by the
+// client, and then sends a SecurityResult.
+func serveRSAAES(c net.Conn, auth *ClientAuthRSAAES, serverKey *rsa.PrivateKey, subType uint8) (string, string, error) {
+	// Exchange public keys.
+	serverKeyBlob := rsaAESPublicKeyBlob(&serverKey.PublicKey)
+	if _, err := c.Write(serverKeyBlob); err != nil {
+		return "", "", err
+	}
+	var bits uint32
+	if err := binary.Read(c, binary.BigEndian, &bits); err != nil {
+		return "", "", err
+	}
+	size := int(bits+7) / 8
+	clientKeyBlob := make([]byte, 4+2*size)
+	binary.BigEndian.PutUint32(clientKeyBlob, bits)
+	if _, err := io.ReadFull(c, clientKeyBlob[4:]); err != nil {
+		return "", "", err
+	}
+	clientKey := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(clientKeyBlob[4 : 4+size]),
+		E: int(new(big.Int).SetBytes(clientKeyBlob[4+size:]).Int64()),
+	}
+
+	// Exchange randoms.
+	var length uint16
+	if err := binary.Read(c, binary.BigEndian, &length); err != nil {
+		return "", "", err
+	}
+	encrypted := make([]byte, length)
+	if _, err := io.ReadFull(c, encrypted); err != nil {
+		return "", "", err
+	}
+	clientRandom, err := rsa.DecryptPKCS1v15(nil, serverKey, encrypted)
+	if err != nil {
+		return "", "", err
+	}
+	serverRandom := make([]byte, auth.keySize())
+	rand.Read(serverRandom)
+	if encrypted, err = rsa.EncryptPKCS1v15(rand.Reader, clientKey, serverRandom); err != nil {
+		return "", "", err
+	}
+	if err := binary.Write(c, binary.BigEndian, uint16(len(encrypted))); err != nil {
+		return "", "", err
+	}
+	if _, err := c.Write(encrypted); err != nil {
+		return "", "", err
+	}
+
+	// Verify the hashes of the keys.
+	rc, err := newRSAAESConn(c, auth.sessionKey(serverRandom, clientRandom), auth.sessionKey(clientRandom, serverRandom))
+	if err != nil {
+		return "", "", err
+	}
+	clientHash := make([]byte, auth.hash().Size())
+	if _, err := io.ReadFull(rc, clientHash); err != nil {
+		return "", "", err
+	}
+	if !bytes.Equal(clientHash, auth.sum(clientKeyBlob, serverKeyBlob)) {
+		return "", "", errors.New("incorrect client hash")
+	}
+	if _, err := rc.Write(auth.sum(serverKeyBlob, clientKeyBlob)); err != nil {
+		return "", "", err
+	}
+
+	// Authenticate.
+	if _, err := rc.Write([]byte{subType}); err != nil {
+		return "", "", err
+	}
+	var creds [2]string
+	for i := range creds {
+		var n [1]byte
+		if _, err := io.ReadFull(rc, n[:]); err != nil {
+			return "", "", err
+		}
+		b := make([]byte, n[0])
+		if _, err := io.ReadFull(rc, b); err != nil {
+			return "", "", err
+		}
+		creds[i] = string(b)
+	}
+
+	var result net.Conn = rc
+	if auth.Unencrypted {
+		result = c
+	}
+	if err := binary.Write(result, binary.BigEndian, uint32(0)); err != nil {
+		return "", "", err
+	}
+	return creds[0], creds[1], nil
+}
+
+func TestClientAuthRSAAES_Handshake(t *testing.T) {
+	serverKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned := func(key *rsa.PublicKey) error {
+		if !key.Equal(serverKey.Public()) {
+			return fmt.Errorf("unknown key")
+		}
+		return nil
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		desc               string
+		auth               *ClientAuthRSAAES
+		secType            uint8
+		subType            uint8
+		username, password string
+		ok                 bool
+	}{
+		{"ra2", &ClientAuthRSAAES{}, secTypeRA2, rsaAESUserPass, "user", "pass", true},
+		{"ra2ne", &ClientAuthRSAAES{Unencrypted: true}, secTypeRA2ne, rsaAESPass, "", "pass", true},
+		{"ra256", &ClientAuthRSAAES{AES256: true}, secTypeRA256, rsaAESUserPass, "user", "pass", true},
+		{"rane256", &ClientAuthRSAAES{AES256: true, Unencrypted: true}, secTypeRAne256, rsaAESPass, "", "pass", true},
+		{"pinned key", &ClientAuthRSAAES{VerifyServerKey: pinned}, secTypeRA2, rsaAESPass, "", "pass", true},
+		{"rejected key", &ClientAuthRSAAES{VerifyServerKey: func(*rsa.PublicKey) error {
+			return pinned(&otherKey.PublicKey)
+		}}, secTypeRA2, rsaAESPass, "", "pass", false},
+		{"no username", &ClientAuthRSAAES{}, secTypeRA2, rsaAESUserPass, "", "pass", false},
+	} {
+		if got, want := tt.auth.SecurityType(), tt.secType; got != want {
+			t.Errorf("%s: incorrect security type; got = %v, want = %v", tt.desc, got, want)
+		}
+
+		sc, cc := tcpConnPair(t)
+		type result struct {
+			username, password string
+			err                error
+		}
+		served := make(chan result, 1)
+		go func() {
+			username, password, err := serveRSAAES(sc, tt.auth, serverKey, tt.subType)
+			served <- result{username, password, err}
+		}()
+
+		conn := NewClientConn(cc, &ClientConfig{Username: tt.username, Password: tt.password})
+		err := tt.auth.Handshake(conn)
+		if !tt.ok {
+			conn.Close()
+			<-served
+			sc.Close()
+			if err == nil {
+				t.Errorf("%s: expected error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+		}
+		var securityResult uint32 = 1
+		if err := conn.receive(&securityResult); err != nil {
+			t.Errorf("%s: error reading SecurityResult: %s", tt.desc, err)
+		}
+		conn.Close()
+		res := <-served
+		sc.Close()
+		if res.err != nil {
+			t.Errorf("%s: unexpected server error: %s", tt.desc, res.err)
+			continue
+		}
+		if got, want := securityResult, uint32(0); got != want {
+			t.Errorf("%s: incorrect SecurityResult; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := res.username, tt.username; got != want {
+			t.Errorf("%s: incorrect username; got = %q, want = %q", tt.desc, got, want)
+		}
+		if got, want := res.password, tt.password; got != want {
+			t.Errorf("%s: incorrect password; got = %q, want = %q", tt.desc, got, want)
+		}
+	}
+}
+
+func TestRSAAESConn(t *testing.T) {
+	a, b := tcpConnPair(t)
+	defer a.Close()
+	defer b.Close()
+	k1, k2 := bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 16)
+	ac, err := newRSAAESConn(a, k1, k2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := newRSAAESConn(b, k2, k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make([]byte, 3*rsaAESMaxMessageSize+5)
+	rand.Read(want)
+	go ac.Write(want)
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(bc, got); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("data did not round-trip")
+	}
+
+	// A modified message fails authentication.
+	go a.Write([]byte{0, 1, 0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	if _, err := bc.Read(got); err == nil {
+		t.Error("expected error for modified message")
+	}
+}
@@ -4,14 +4,25 @@ package vnc
 
 import (
 	"crypto/des"
+	"crypto/rand"
+	"crypto/subtle"
+	"log"
 
 	"github.com/kward/go-vnc/logging"
 )
 
 const (
-	secTypeInvalid = uint8(0)
-	secTypeNone    = uint8(1)
-	secTypeVNCAuth = uint8(2)
+	secTypeInvalid  = uint8(0)
+	secTypeNone     = uint8(1)
+	secTypeVNCAuth  = uint8(2)
+	secTypeRA2      = uint8(5)
+	secTypeRA2ne    = uint8(6)
+	secTypeTight    = uint8(16)
+	secTypeTLS      = uint8(18)
+	secTypeVeNCrypt = uint8(19)
+	secTypeARD      = uint8(30)
+	secTypeRA256    = uint8(129)
+	secTypeRAne256  = uint8(130)
 )
 
 // ClientAuth implements a method of authenticating with a remote server.
@@ -77,9 +88,15 @@ func (auth *ClientAuthVNC) Handshake(conn *ClientConn) error {
 }
 
 func (auth *ClientAuthVNC) encode(ch *vncAuthChallenge) error {
+	return encodeVNCAuthChallenge(auth.Password, ch)
+}
+
+// encodeVNCAuthChallenge encrypts the challenge with the password, as the
+// response of VNC Authentication.
+func encodeVNCAuthChallenge(password string, ch *vncAuthChallenge) error {
 	// Copy password string to 8 byte 0-padded slice
 	key := make([]byte, 8)
-	copy(key, auth.Password)
+	copy(key, password)
 
 	// Each byte of the password needs to be reversed. This is a
 	// non RFC-documented behaviour of VNC clients and servers
@@ -100,3 +117,96 @@ func (auth *ClientAuthVNC) encode(ch *vncAuthChallenge) error {
 
 	return nil
 }
+
+// ServerAuth implements a method of authenticating a remote client.
+type ServerAuth interface {
+	// SecurityType returns the byte identifier sent to the client to identify
+	// this authentication scheme.
+	SecurityType() uint8
+
+	// Handshake is called when the authentication handshake should be
+	// performed, as part of the general RFB handshake. An error fails the
+	// handshake, and is sent to the client as the reason. (see 7.2.1)
+	Handshake(*ServerConn) error
+}
+
+// ServerAuthNone is the "none" authentication. See 7.2.1.
+type ServerAuthNone struct{}
+
+func (*ServerAuthNone) SecurityType() uint8 {
+	return secTypeNone
+}
+
+func (*ServerAuthNone) Handshake(conn *ServerConn) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerAuthNone.%s", logging.FnName())
+	}
+
+	return nil
+}
+
+// ServerAuthVNC is the standard password authentication. See 7.2.2.
+type ServerAuthVNC struct {
+	// Password returns the password the client must provide, e.g. from a
+	// lookup by the address of the client. It is called for each connection.
+	Password func(conn *ServerConn) (string, error)
+}
+
+// NewServerAuthVNC returns a ServerAuthVNC for a fixed password.
+func NewServerAuthVNC(password string) *ServerAuthVNC {
+	return &ServerAuthVNC{func(*ServerConn) (string, error) { return password, nil }}
+}
+
+func (*ServerAuthVNC) SecurityType() uint8 {
+	return secTypeVNCAuth
+}
+
+func (auth *ServerAuthVNC) Handshake(conn *ServerConn) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerAuthVNC.%s", logging.FnName())
+	}
+
+	// Send a random challenge, and read the response of the client, before
+	// looking up the password, so that the client reads a SecurityResult next
+	// whatever the outcome.
+	var challenge vncAuthChallenge
+	if _, err := rand.Read(challenge[:]); err != nil {
+		return err
+	}
+	if err := conn.send(challenge); err != nil {
+		return err
+	}
+	var response vncAuthChallenge
+	if err := conn.receive(&response); err != nil {
+		return err
+	}
+
+	password, err := auth.password(conn)
+	if err != nil {
+		// The reason sent to the client does not reveal errors of the server.
+		log.Printf("VNCAuth password lookup failed; %v", err)
+		return NewVNCError("Authentication failed")
+	}
+	if err := encodeVNCAuthChallenge(password, &challenge); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(challenge[:], response[:]) != 1 {
+		return NewVNCError("Authentication failed")
+	}
+	return nil
+}
+
+// password returns the password the client must provide.
+func (auth *ServerAuthVNC) password(conn *ServerConn) (string, error) {
+	if auth.Password == nil {
+		return "", NewVNCError("no password lookup for VNCAuth")
+	}
+	password, err := auth.Password(conn)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", NewVNCError("no password provided for VNCAuth")
+	}
+	return password, nil
+}
@@ -3,7 +3,9 @@ package vnc
 
 import (
 	"encoding/hex"
+	"errors"
 	"io"
+	"net"
 	"strings"
 	"testing"
 )
@@ -98,3 +100,37 @@ func TestClientAuthVNC_encode(t *testing.T) {
 		}
 	}
 }
+
+func TestServerAuthVNC_Handshake(t *testing.T) {
+	lookupErr := errors.New("lookup failed")
+	for _, tt := range []struct {
+		desc     string
+		auth     *ServerAuthVNC
+		password string
+		err      error
+	}{
+		{"match", NewServerAuthVNC("12345678"), "12345678", nil},
+		{"truncated", NewServerAuthVNC("123456789"), "12345678", nil},
+		{"mismatch", NewServerAuthVNC("abc123"), "abc124", NewVNCError("Authentication failed")},
+		{"lookup", &ServerAuthVNC{func(*ServerConn) (string, error) { return "", lookupErr }}, "12345678", NewVNCError("Authentication failed")},
+		{"no lookup", &ServerAuthVNC{}, "12345678", NewVNCError("Authentication failed")},
+		{"no password", NewServerAuthVNC(""), "12345678", NewVNCError("Authentication failed")},
+	} {
+		sc, cc := net.Pipe()
+		conn := NewServerConn(sc, &ServerConfig{})
+		client := NewClientConn(cc, &ClientConfig{})
+		go func() {
+			(&ClientAuthVNC{tt.password}).Handshake(client)
+			cc.Close()
+		}()
+
+		err := tt.auth.Handshake(conn)
+		if tt.err == nil && err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+		}
+		if tt.err != nil && (err == nil || err.Error() != tt.err.Error()) {
+			t.Errorf("%s: incorrect error; got = %v, want = %v", tt.desc, err, tt.err)
+		}
+		sc.Close()
+	}
+}
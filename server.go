@@ -7,6 +7,9 @@ package vnc
 import (
 	"fmt"
 	"image"
+	"math"
+	"strings"
+	"unicode"
 
 	"github.com/kward/go-vnc/encodings"
 	"github.com/kward/go-vnc/logging"
@@ -82,14 +85,19 @@ func (m *FramebufferUpdate) Read(c *ClientConn) (ServerMessage, error) {
 		logging.Infof("numRects: %d", numRects)
 	}
 
-	// Extract rectangles.
-	rects := make([]Rectangle, numRects)
+	// Extract rectangles. Servers that support the LastRect pseudo-encoding
+	// may send 0xFFFF rectangles, and end the update with a LastRect
+	// rectangle.
+	rects := []Rectangle{}
 	for i := 0; i < int(numRects); i++ {
 		rect := NewRectangle(c.Encodable)
 		if err := rect.Read(c); err != nil {
 			return nil, err
 		}
-		rects[i] = *rect
+		if rect.Enc.Type() == encodings.LastRectPseudo {
+			break
+		}
+		rects = append(rects, *rect)
 	}
 
 	return newFramebufferUpdate(rects), nil
@@ -132,6 +140,22 @@ func (m *FramebufferUpdate) Unmarshal(_ []byte) error {
 	return fmt.Errorf("Unmarshal() unimplemented")
 }
 
+// FramebufferUpdate sends a FramebufferUpdate message with the rectangles to
+// the client. The pixel data of the rectangles must use the pixel format of
+// the client, and encodings the client supports.
+func (s *ServerConn) FramebufferUpdate(rects []Rectangle) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerConn.%s", logging.FnNameWithArgs("%d rects", len(rects)))
+	}
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+	data, err := newFramebufferUpdate(rects).Marshal()
+	if err != nil {
+		return err
+	}
+	return s.send(data)
+}
+
 // EncodableFunc describes the function for encoding a Rectangle.
 type EncodableFunc func(enc encodings.Encoding) (Encoding, bool)
 
@@ -242,6 +266,68 @@ func (r *Rectangle) Unmarshal(data []byte) error {
 	switch msg.E {
 	case encodings.Raw:
 		r.Enc = &RawEncoding{}
+	case encodings.CopyRect:
+		var e CopyRectEncoding
+		if err := buf.Read(&e); err != nil {
+			return err
+		}
+		r.Enc = &e
+	case encodings.RRE:
+		r.Enc = &RREEncoding{}
+	case encodings.CoRRE:
+		r.Enc = &CoRREEncoding{}
+	case encodings.Hextile:
+		r.Enc = &HextileEncoding{width: r.Width, height: r.Height}
+	case encodings.Zlib:
+		r.Enc = &ZlibEncoding{width: r.Width, height: r.Height}
+	case encodings.ZlibHex:
+		r.Enc = &ZlibHexEncoding{width: r.Width, height: r.Height}
+	case encodings.Tight:
+		r.Enc = &TightEncoding{width: r.Width, height: r.Height}
+	case encodings.TightPNG:
+		r.Enc = &TightPNGEncoding{width: r.Width, height: r.Height}
+	case encodings.TRLE:
+		r.Enc = &TRLEEncoding{width: r.Width, height: r.Height}
+	case encodings.ZRLE:
+		r.Enc = &ZRLEEncoding{width: r.Width, height: r.Height}
+	case encodings.CursorPseudo:
+		r.Enc = &CursorPseudoEncoding{hotspot: image.Pt(int(r.X), int(r.Y)), width: r.Width, height: r.Height}
+	case encodings.XCursorPseudo:
+		r.Enc = &XCursorPseudoEncoding{hotspot: image.Pt(int(r.X), int(r.Y)), width: r.Width, height: r.Height}
+	case encodings.DesktopSizePseudo:
+		r.Enc = &DesktopSizePseudoEncoding{}
+	case encodings.QEMUExtendedKeyEventPseudo:
+		r.Enc = &QEMUExtendedKeyEventPseudoEncoding{}
+	case encodings.QEMUAudioPseudo:
+		r.Enc = &QEMUAudioPseudoEncoding{}
+	case encodings.LastRectPseudo:
+		r.Enc = &LastRectPseudoEncoding{}
+	case encodings.PointerPosPseudo:
+		r.Enc = &PointerPosPseudoEncoding{}
+	case encodings.DesktopNamePseudo:
+		var length uint32
+		if err := buf.Read(&length); err != nil {
+			return err
+		}
+		name := make([]byte, length)
+		if err := buf.Read(name); err != nil {
+			return err
+		}
+		r.Enc = &DesktopNamePseudoEncoding{string(name)}
+	case encodings.ExtendedDesktopSizePseudo:
+		var n [4]uint8
+		if err := buf.Read(&n); err != nil {
+			return err
+		}
+		e := &ExtendedDesktopSizePseudoEncoding{
+			Reason:  DesktopSizeReason(r.X),
+			Status:  DesktopSizeStatus(r.Y),
+			Screens: make([]Screen, n[0]),
+		}
+		if err := buf.Read(e.Screens); err != nil {
+			return err
+		}
+		r.Enc = e
 	default:
 		return fmt.Errorf("unable to unmarshal encoding %v", msg.E)
 	}
@@ -393,13 +479,30 @@ func (c *Color) Unmarshal(data []byte) error {
 		c.G = uint16((pixel >> c.pf.GreenShift) & uint32(c.pf.GreenMax))
 		c.B = uint16((pixel >> c.pf.BlueShift) & uint32(c.pf.BlueMax))
 	} else {
+		pf, cm := c.pf, c.cm
 		*c = c.cm[pixel]
-		c.cmIndex = pixel
+		c.pf, c.cm, c.cmIndex = pf, cm, pixel
 	}
 
 	return nil
 }
 
+// rgb8 returns the red, green and blue intensities of the color, scaled to
+// 8 bits.
+func (c *Color) rgb8() (r, g, b uint8) {
+	if !rfbflags.IsTrueColor(c.pf.TrueColor) {
+		// Color map intensities are 16 bits.
+		return uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8)
+	}
+	scale := func(v, max uint16) uint8 {
+		if max == 0 {
+			return 0
+		}
+		return uint8((uint32(v)*255 + uint32(max)/2) / uint32(max))
+	}
+	return scale(c.R, c.pf.RedMax), scale(c.G, c.pf.GreenMax), scale(c.B, c.pf.BlueMax)
+}
+
 //lint:ignore U1000 helper for potential future image conversions; currently unused
 func colorsToImage(x, y, width, height uint16, colors []Color) *image.RGBA64 {
 	rect := image.Rect(int(x), int(y), int(x+width), int(y+height))
@@ -441,6 +544,14 @@ func (*Bell) Read(c *ClientConn) (ServerMessage, error) {
 	return &Bell{}, nil
 }
 
+// Bell sends a Bell message to the client.
+func (s *ServerConn) Bell() error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerConn.%s", logging.FnName())
+	}
+	return s.send(messages.Bell)
+}
+
 //-----------------------------------------------------------------------------
 // ServerCutText indicates the server has new text in the cut buffer.
 //
@@ -449,8 +560,12 @@ func (*Bell) Read(c *ClientConn) (ServerMessage, error) {
 
 // ServerCutText represents the wire format message, sans message-type and
 // padding.
+//
+// Messages of the ExtendedClipboard extension are returned in Extended, and
+// leave Text empty.
 type ServerCutText struct {
-	Text string
+	Text     string
+	Extended *ExtendedClipboard
 }
 
 // Verify that interfaces are honored.
@@ -466,20 +581,259 @@ func (*ServerCutText) Read(c *ClientConn) (ServerMessage, error) {
 	}
 
 	// Read off the padding
-	var padding [1]byte
+	var padding [3]byte
 	if err := c.receive(&padding); err != nil {
 		return nil, err
 	}
 
-	var textLength uint32
+	var textLength int32
 	if err := c.receive(&textLength); err != nil {
 		return nil, err
 	}
+	if textLength < 0 {
+		// The length of math.MinInt32 can not be negated.
+		if textLength == math.MinInt32 {
+			return nil, fmt.Errorf("extended clipboard message of %d bytes is too long", uint32(textLength))
+		}
+		msg, err := c.readExtendedClipboard(uint32(-textLength))
+		if err != nil {
+			return nil, err
+		}
+		if err := c.handleExtendedClipboard(msg); err != nil {
+			return nil, err
+		}
+		return &ServerCutText{Extended: msg}, nil
+	}
 
+	if textLength > clipboardMaxSize {
+		return nil, fmt.Errorf("cut text of %d bytes is too long", textLength)
+	}
 	textBytes := make([]uint8, textLength)
 	if err := c.receive(&textBytes); err != nil {
 		return nil, err
 	}
 
-	return &ServerCutText{string(textBytes)}, nil
+	// The text is Latin-1, which maps directly onto the first 256 runes.
+	text := make([]rune, len(textBytes))
+	for i, b := range textBytes {
+		text[i] = rune(b)
+	}
+	return &ServerCutText{Text: string(text)}, nil
+}
+
+// ServerCutText sends text to the client, as the new text of the cut buffer
+// of the server. The text must only contain Latin-1 characters.
+func (s *ServerConn) ServerCutText(text string) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerConn.%s", logging.FnNameWithArgs("%s", text))
+	}
+
+	// Strip carriage-return (0x0d) chars, as for ClientCutText.
+	text = strings.ReplaceAll(text, "\r", "")
+
+	latin1 := make([]byte, 0, len(text))
+	for _, char := range text {
+		if char > unicode.MaxLatin1 {
+			return NewVNCError(fmt.Sprintf("Character %q is not valid Latin-1", char))
+		}
+		latin1 = append(latin1, byte(char))
+	}
+
+	buf := NewBuffer(nil)
+	msg := struct {
+		Msg    messages.ServerMessage // message-type
+		_      [3]byte                // padding
+		Length uint32                 // length
+	}{Msg: messages.ServerCutText, Length: uint32(len(latin1))}
+	if err := buf.Write(msg); err != nil {
+		return err
+	}
+	if err := buf.Write(latin1); err != nil {
+		return err
+	}
+	return s.send(buf.Bytes())
+}
+
+//-----------------------------------------------------------------------------
+// EndOfContinuousUpdates indicates that the server has stopped sending
+// continuous updates, or that the server supports them.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#endofcontinuousupdates
+
+// EndOfContinuousUpdates represents the wire format message, sans
+// message-type.
+type EndOfContinuousUpdates struct{}
+
+// Verify that interfaces are honored.
+var _ ServerMessage = (*EndOfContinuousUpdates)(nil)
+
+// Type implements the ServerMessage interface.
+func (*EndOfContinuousUpdates) Type() messages.ServerMessage {
+	return messages.EndOfContinuousUpdates
+}
+
+// Read implements the ServerMessage interface.
+func (*EndOfContinuousUpdates) Read(c *ClientConn) (ServerMessage, error) {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("EndOfContinuousUpdates.%s", logging.FnName())
+	}
+	return &EndOfContinuousUpdates{}, nil
+}
+
+//-----------------------------------------------------------------------------
+// ServerFence synchronizes the message streams between server and client.
+// Fence requests are answered automatically, as messages are processed in
+// order, which satisfies FenceBlockBefore and FenceBlockAfter. FenceSyncNext
+// is not supported, and is cleared in the response.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#serverfence
+
+// ServerFence represents the wire format message, sans message-type and
+// padding.
+type ServerFence struct {
+	Flags   FenceFlags
+	Payload []byte
+}
+
+// Verify that interfaces are honored.
+var _ ServerMessage = (*ServerFence)(nil)
+
+// Type implements the ServerMessage interface.
+func (*ServerFence) Type() messages.ServerMessage { return messages.ServerFence }
+
+// Read implements the ServerMessage interface.
+func (*ServerFence) Read(c *ClientConn) (ServerMessage, error) {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerFence.%s", logging.FnName())
+	}
+
+	var msg struct {
+		_      [3]byte    // padding
+		Flags  FenceFlags // flags
+		Length uint8      // length
+	}
+	if err := c.receive(&msg); err != nil {
+		return nil, err
+	}
+	if msg.Length > fenceMaxPayload {
+		return nil, fmt.Errorf("fence payload of %d bytes is too long", msg.Length)
+	}
+	payload := make([]uint8, msg.Length)
+	if err := c.receive(payload); err != nil {
+		return nil, err
+	}
+
+	if msg.Flags&FenceRequest != 0 {
+		if err := c.Fence(msg.Flags&(FenceBlockBefore|FenceBlockAfter), payload); err != nil {
+			return nil, err
+		}
+	}
+	return &ServerFence{msg.Flags, payload}, nil
+}
+
+//-----------------------------------------------------------------------------
+// ServerXVP announces that the server supports XVP, or that an XVP request
+// failed.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#xvp-server-message
+
+// ServerXVP represents the wire format message, sans message-type and
+// padding.
+type ServerXVP struct {
+	Version uint8   // xvp-extension-version
+	Code    XVPCode // xvp-message-code
+}
+
+// Verify that interfaces are honored.
+var _ ServerMessage = (*ServerXVP)(nil)
+
+// Type implements the ServerMessage interface.
+func (*ServerXVP) Type() messages.ServerMessage { return messages.ServerXVP }
+
+// Read implements the ServerMessage interface.
+func (*ServerXVP) Read(c *ClientConn) (ServerMessage, error) {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerXVP.%s", logging.FnName())
+	}
+
+	var msg struct {
+		_ [1]byte // padding
+		ServerXVP
+	}
+	if err := c.receive(&msg); err != nil {
+		return nil, err
+	}
+	return &msg.ServerXVP, nil
+}
+
+//-----------------------------------------------------------------------------
+// QEMUAudio carries the audio stream of the server, once enabled with
+// EnableQEMUAudio.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#qemu-audio-server-message
+
+// QEMUAudioOperation is the operation of a QEMUAudio message.
+type QEMUAudioOperation uint16
+
+// QEMUAudioOperation values.
+const (
+	QEMUAudioEnd   QEMUAudioOperation = iota // The stream has stopped.
+	QEMUAudioBegin                           // The stream has started.
+	QEMUAudioData                            // Audio data.
+)
+
+// qemuAudioMaxLength is the largest audio data accepted by ClientConn, in
+// bytes.
+const qemuAudioMaxLength = 1 << 20
+
+// QEMUAudio represents the wire format message, sans message-type and
+// submessage-type.
+type QEMUAudio struct {
+	Operation QEMUAudioOperation
+	// Data holds samples in the format set by SetQEMUAudioFormat, for
+	// QEMUAudioData operations.
+	Data []byte
+}
+
+// Verify that interfaces are honored.
+var _ ServerMessage = (*QEMUAudio)(nil)
+
+// Type implements the ServerMessage interface.
+func (*QEMUAudio) Type() messages.ServerMessage { return messages.ServerQEMU }
+
+// Read implements the ServerMessage interface.
+func (*QEMUAudio) Read(c *ClientConn) (ServerMessage, error) {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("QEMUAudio.%s", logging.FnName())
+	}
+
+	var msg struct {
+		SubType   uint8              // submessage-type
+		Operation QEMUAudioOperation // operation
+	}
+	if err := c.receive(&msg); err != nil {
+		return nil, err
+	}
+	if msg.SubType != qemuAudio {
+		return nil, fmt.Errorf("unsupported QEMU submessage-type %d", msg.SubType)
+	}
+
+	switch msg.Operation {
+	case QEMUAudioEnd, QEMUAudioBegin:
+		return &QEMUAudio{Operation: msg.Operation}, nil
+	case QEMUAudioData:
+		var length uint32
+		if err := c.receive(&length); err != nil {
+			return nil, err
+		}
+		if length > qemuAudioMaxLength {
+			return nil, fmt.Errorf("audio data of %d bytes is too long", length)
+		}
+		data := make([]uint8, length)
+		if err := c.receive(data); err != nil {
+			return nil, err
+		}
+		return &QEMUAudio{msg.Operation, data}, nil
+	}
+	return nil, fmt.Errorf("unsupported QEMU audio operation %d", msg.Operation)
 }
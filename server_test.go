@@ -2,6 +2,8 @@
 package vnc
 
 import (
+	"encoding/binary"
+	"image"
 	"testing"
 
 	"github.com/kward/go-vnc/encodings"
@@ -222,4 +224,194 @@ func TestSetColorMapEntries(t *testing.T) {}
 
 func TestBell(t *testing.T) {}
 
-func TestServerCutText(t *testing.T) {}
+func TestServerCutText(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		data []byte
+		text string
+	}{
+		{[]byte{0, 0, 0, 0, 0, 0, 3, 'a', 'b', 'c'}, "abc"},
+		{[]byte{0, 0, 0, 0, 0, 0, 4, 'c', 'a', 'f', 0xe9}, "café"},
+		{[]byte{0, 0, 0, 0, 0, 0, 0}, ""},
+	} {
+		mockConn.Reset()
+		if err := conn.send(tt.data); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := (&ServerCutText{}).Read(conn)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if got, want := msg.(*ServerCutText).Text, tt.text; got != want {
+			t.Errorf("incorrect text; got = %q, want = %q", got, want)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%d bytes unread", mockConn.b.Len())
+		}
+	}
+}
+
+func TestFramebufferUpdate_LastRect(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.encodings = Encodings{
+		&RawEncoding{},
+		&LastRectPseudoEncoding{},
+		&PointerPosPseudoEncoding{},
+		&DesktopNamePseudoEncoding{},
+	}
+
+	// An update of an unknown number of rectangles, ended by LastRect.
+	msg := newFramebufferUpdate([]Rectangle{
+		{10, 20, 0, 0, &PointerPosPseudoEncoding{}, conn.Encodable},
+		{0, 0, 0, 0, &DesktopNamePseudoEncoding{"répertoire"}, conn.Encodable},
+		{0, 0, 0, 0, &LastRectPseudoEncoding{}, conn.Encodable},
+	})
+	msg.NumRect = 0xffff
+	data, err := msg.Marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal; %s", err)
+	}
+	if err := conn.send(data[1:]); err != nil {
+		t.Fatalf("failed to send; %s", err)
+	}
+
+	parsedFU, err := (&FramebufferUpdate{}).Read(conn)
+	if err != nil {
+		t.Fatalf("failed to read; %s", err)
+	}
+	if got, want := len(parsedFU.(*FramebufferUpdate).Rects), 2; got != want {
+		t.Errorf("incorrect number-of-rectangles; got %d, want %d", got, want)
+	}
+	if got, want := mockConn.b.Len(), 0; got != want {
+		t.Errorf("%d bytes unread", got)
+	}
+	if got, want := conn.PointerPos(), image.Pt(10, 20); got != want {
+		t.Errorf("incorrect pointer position; got %v, want %v", got, want)
+	}
+	if got, want := conn.DesktopName(), "répertoire"; got != want {
+		t.Errorf("incorrect desktop name; got %q, want %q", got, want)
+	}
+}
+
+func TestServerFence(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		desc  string
+		flags FenceFlags
+		reply bool
+		want  FenceFlags
+	}{
+		{"response", FenceBlockBefore, false, 0},
+		{"request", FenceRequest | FenceBlockBefore | FenceBlockAfter, true, FenceBlockBefore | FenceBlockAfter},
+		{"sync next request", FenceRequest | FenceSyncNext, true, 0},
+	} {
+		mockConn.Reset()
+		data := []byte{0, 0, 0}
+		data = binary.BigEndian.AppendUint32(data, uint32(tt.flags))
+		data = append(data, 2, 0xab, 0xcd)
+		if err := conn.send(data); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := (&ServerFence{}).Read(conn)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		if got, want := msg.(*ServerFence).Flags, tt.flags; got != want {
+			t.Errorf("%s: incorrect flags; got = %#x, want = %#x", tt.desc, got, want)
+		}
+
+		if !tt.reply {
+			if got := mockConn.b.Len(); got != 0 {
+				t.Errorf("%s: unexpected reply of %d bytes", tt.desc, got)
+			}
+			continue
+		}
+		var reply ClientFenceMessage
+		if err := conn.receive(&reply); err != nil {
+			t.Fatalf("%s: %s", tt.desc, err)
+		}
+		if got, want := reply.Flags, tt.want; got != want {
+			t.Errorf("%s: incorrect reply flags; got = %#x, want = %#x", tt.desc, got, want)
+		}
+		payload := make([]byte, reply.Length)
+		if err := conn.receive(payload); err != nil {
+			t.Fatalf("%s: %s", tt.desc, err)
+		}
+		if got, want := payload, []byte{0xab, 0xcd}; !operators.EqualSlicesOfByte(got, want) {
+			t.Errorf("%s: incorrect reply payload; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestServerXVP(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, code := range []XVPCode{XVPInit, XVPFail} {
+		if err := conn.send([]byte{0, 1, uint8(code)}); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := (&ServerXVP{}).Read(conn)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got, want := *msg.(*ServerXVP), (ServerXVP{Version: 1, Code: code}); got != want {
+			t.Errorf("incorrect message; got = %v, want = %v", got, want)
+		}
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes unread", mockConn.b.Len())
+	}
+}
+
+func TestQEMUAudio_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		desc string
+		data []byte
+		ok   bool
+		want QEMUAudio
+	}{
+		{"begin", []byte{1, 0, 1}, true, QEMUAudio{Operation: QEMUAudioBegin}},
+		{"data", []byte{1, 0, 2, 0, 0, 0, 3, 0x10, 0x20, 0x30}, true, QEMUAudio{QEMUAudioData, []byte{0x10, 0x20, 0x30}}},
+		{"end", []byte{1, 0, 0}, true, QEMUAudio{Operation: QEMUAudioEnd}},
+		{"invalid submessage-type", []byte{0, 0, 0}, false, QEMUAudio{}},
+		{"invalid operation", []byte{1, 0, 3}, false, QEMUAudio{}},
+		{"data too long", []byte{1, 0, 2, 0xff, 0xff, 0xff, 0xff}, false, QEMUAudio{}},
+	} {
+		mockConn.Reset()
+		if err := conn.send(tt.data); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := (&QEMUAudio{}).Read(conn)
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+			continue
+		}
+		if err != nil {
+			if tt.ok {
+				t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			}
+			continue
+		}
+		got := msg.(*QEMUAudio)
+		if got, want := got.Operation, tt.want.Operation; got != want {
+			t.Errorf("%s: incorrect operation; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := got.Data, tt.want.Data; !operators.EqualSlicesOfByte(got, want) {
+			t.Errorf("%s: incorrect data; got = %v, want = %v", tt.desc, got, want)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes unread", tt.desc, mockConn.b.Len())
+		}
+	}
+}
@@ -0,0 +1,567 @@
+/*
+Implementation of the Tight and TightPNG encodings.
+
+The Tight encoding is not part of RFC 6143, and is documented by the community
+maintained RFB protocol specification.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#tight-encoding
+*/
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+//-----------------------------------------------------------------------------
+// Tight Encoding
+//
+// Tight encoding provides efficient compression for pixel data. Each rectangle
+// starts with a compression-control byte, which selects one of fill, JPEG or
+// basic compression, and which may request a reset of any of the four zlib
+// streams used by basic compression. Basic compression may additionally apply
+// a copy, palette or gradient filter to the pixel data before it is
+// compressed.
+//
+// The TightPNG encoding is a variant of Tight, which adds PNG compression.
+
+// Tight compression-control values.
+const (
+	tightFill           = 0x08
+	tightJPEG           = 0x09
+	tightPNG            = 0x0a
+	tightMaxSubtype     = 0x0a
+	tightExplicitFilter = 0x04 // Basic compression with an explicit filter-id.
+	tightNumStreams     = 4
+)
+
+// Tight filter types of basic compression.
+const (
+	tightFilterCopy     = 0
+	tightFilterPalette  = 1
+	tightFilterGradient = 2
+)
+
+// tightMaxPaletteSize is the maximum number of colors of the palette filter.
+const tightMaxPaletteSize = 256
+
+// tightMinToCompress is the size of data below which basic compression does not
+// use zlib.
+const tightMinToCompress = 12
+
+// tightMaxLength is the maximum value of a Tight compact length.
+const tightMaxLength = 1<<22 - 1
+
+// TightEncoding holds Tight encoded rectangle data. The pixel data is stored
+// decoded, in the same layout as RawEncoding.
+type TightEncoding struct {
+	Colors        []Color
+	width, height uint16
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*TightEncoding)(nil)
+
+// NewTightEncoding returns a TightEncoding for the colors of a rectangle of the
+// given width and height.
+func NewTightEncoding(colors []Color, width, height uint16) *TightEncoding {
+	return &TightEncoding{colors, width, height}
+}
+
+// Marshal implements the Encoding interface.
+//
+// Single colored rectangles use fill compression. Others use basic compression
+// with the palette filter if they have few enough colors, and the copy filter
+// otherwise. Basic compression resets zlib stream 0 so that the result can be
+// decoded independently of other rectangles.
+func (e *TightEncoding) Marshal() ([]byte, error) {
+	return marshalTight(e.Colors, e.width, e.height, false)
+}
+
+// Read implements the Encoding interface.
+func (*TightEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	colors, err := c.readTight(rect, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with tight encoding: %s", err)
+	}
+	return &TightEncoding{colors, rect.Width, rect.Height}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*TightEncoding) String() string { return "TightEncoding" }
+
+// Type implements the Encoding interface.
+func (*TightEncoding) Type() encodings.Encoding { return encodings.Tight }
+
+// TightPNGEncoding holds TightPNG encoded rectangle data. The pixel data is
+// stored decoded, in the same layout as RawEncoding.
+type TightPNGEncoding struct {
+	Colors        []Color
+	width, height uint16
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*TightPNGEncoding)(nil)
+
+// NewTightPNGEncoding returns a TightPNGEncoding for the colors of a rectangle
+// of the given width and height.
+func NewTightPNGEncoding(colors []Color, width, height uint16) *TightPNGEncoding {
+	return &TightPNGEncoding{colors, width, height}
+}
+
+// Marshal implements the Encoding interface.
+//
+// Single colored rectangles use fill compression, and others PNG compression.
+func (e *TightPNGEncoding) Marshal() ([]byte, error) {
+	return marshalTight(e.Colors, e.width, e.height, true)
+}
+
+// Read implements the Encoding interface.
+func (*TightPNGEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	colors, err := c.readTight(rect, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with tightpng encoding: %s", err)
+	}
+	return &TightPNGEncoding{colors, rect.Width, rect.Height}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*TightPNGEncoding) String() string { return "TightPNGEncoding" }
+
+// Type implements the Encoding interface.
+func (*TightPNGEncoding) Type() encodings.Encoding { return encodings.TightPNG }
+
+// readTight reads a Tight or TightPNG encoded rectangle.
+func (c *ClientConn) readTight(rect *Rectangle, allowPNG bool) ([]Color, error) {
+	var ctrl uint8
+	if err := c.receive(&ctrl); err != nil {
+		return nil, err
+	}
+	for i := 0; i < tightNumStreams; i++ {
+		if ctrl&(1<<i) != 0 {
+			c.tightStreams[i].reset()
+		}
+	}
+
+	switch subtype := ctrl >> 4; {
+	case subtype == tightFill:
+		color, err := c.readTPixels(c.reader(), 1)
+		if err != nil {
+			return nil, err
+		}
+		colors := make([]Color, rect.Area())
+		for i := range colors {
+			colors[i] = color[0]
+		}
+		return colors, nil
+
+	case subtype == tightJPEG, subtype == tightPNG && allowPNG:
+		length, err := c.readCompactLength()
+		if err != nil {
+			return nil, err
+		}
+		data := make([]uint8, length)
+		if err := c.receive(data); err != nil {
+			return nil, err
+		}
+		var img image.Image
+		if subtype == tightJPEG {
+			img, err = jpeg.Decode(bytes.NewReader(data))
+		} else {
+			img, err = png.Decode(bytes.NewReader(data))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if got, want := img.Bounds().Size(), image.Pt(int(rect.Width), int(rect.Height)); got != want {
+			return nil, fmt.Errorf("image size %v does not match rectangle size %v", got, want)
+		}
+		return c.imageColors(img)
+
+	case subtype > tightMaxSubtype, subtype == tightPNG:
+		return nil, fmt.Errorf("invalid compression-control %#x", ctrl)
+	}
+
+	// Basic compression.
+	filter := uint8(tightFilterCopy)
+	if ctrl&(tightExplicitFilter<<4) != 0 {
+		if err := c.receive(&filter); err != nil {
+			return nil, err
+		}
+	}
+	stream := &c.tightStreams[(ctrl>>4)&0x03]
+	w, h := int(rect.Width), int(rect.Height)
+	tpixelSize := c.pixelFormat.tpixelSize()
+
+	switch filter {
+	case tightFilterCopy, tightFilterGradient:
+		if filter == tightFilterGradient && !rfbflags.IsTrueColor(c.pixelFormat.TrueColor) {
+			return nil, fmt.Errorf("gradient filter requires a true color pixel format")
+		}
+		r, err := c.readTightData(stream, w*h*tpixelSize)
+		if err != nil {
+			return nil, err
+		}
+		colors, err := c.readTPixels(r, w*h)
+		if err != nil {
+			return nil, err
+		}
+		if filter == tightFilterGradient {
+			c.applyGradientFilter(colors, w)
+		}
+		return colors, nil
+
+	case tightFilterPalette:
+		var numColors uint8
+		if err := c.receive(&numColors); err != nil {
+			return nil, err
+		}
+		palette, err := c.readTPixels(c.reader(), int(numColors)+1)
+		if err != nil {
+			return nil, err
+		}
+		rowLen := w
+		if len(palette) == 2 {
+			rowLen = (w + 7) / 8
+		}
+		r, err := c.readTightData(stream, h*rowLen)
+		if err != nil {
+			return nil, err
+		}
+		row := make([]byte, rowLen)
+		colors := make([]Color, 0, w*h)
+		for y := 0; y < h; y++ {
+			if _, err := io.ReadFull(r, row); err != nil {
+				return nil, err
+			}
+			for x := 0; x < w; x++ {
+				var idx int
+				if len(palette) == 2 {
+					idx = int(row[x/8]>>(7-x%8)) & 1
+				} else {
+					idx = int(row[x])
+				}
+				if idx >= len(palette) {
+					return nil, fmt.Errorf("invalid palette index %d", idx)
+				}
+				colors = append(colors, palette[idx])
+			}
+		}
+		return colors, nil
+	}
+
+	return nil, fmt.Errorf("invalid filter-id %d", filter)
+}
+
+// readTightData returns a reader of n bytes of basic compression data. Data
+// shorter than tightMinToCompress is sent without zlib compression.
+func (c *ClientConn) readTightData(stream *zlibStream, n int) (io.Reader, error) {
+	if n < tightMinToCompress {
+		data := make([]uint8, n)
+		if err := c.receive(data); err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+	}
+
+	length, err := c.readCompactLength()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]uint8, length)
+	if err := c.receive(data); err != nil {
+		return nil, err
+	}
+	r, err := stream.decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	return io.LimitReader(r, int64(n)), nil
+}
+
+// readCompactLength reads a Tight compact length, which is represented in one
+// to three bytes. Each of the first two bytes holds 7 bits of the value, least
+// significant first, with the high bit set when another byte follows.
+func (c *ClientConn) readCompactLength() (int, error) {
+	length := 0
+	for i := 0; i < 3; i++ {
+		var b uint8
+		if err := c.receive(&b); err != nil {
+			return 0, err
+		}
+		if i == 2 {
+			return length | int(b)<<14, nil
+		}
+		length |= int(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return length, nil
+}
+
+// readTPixels reads n Tight pixel values (TPIXEL) from r.
+func (c *ClientConn) readTPixels(r io.Reader, n int) ([]Color, error) {
+	size := c.pixelFormat.tpixelSize()
+	data := make([]uint8, n*size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	if size != 3 {
+		return c.unmarshalColors(data, size, 0)
+	}
+
+	colors := make([]Color, n)
+	for i := range colors {
+		color := NewColor(&c.pixelFormat, &c.colorMap)
+		color.R, color.G, color.B = uint16(data[3*i]), uint16(data[3*i+1]), uint16(data[3*i+2])
+		colors[i] = *color
+	}
+	return colors, nil
+}
+
+// applyGradientFilter reverses the gradient filter on the colors of a
+// rectangle of the given width. Each color component was sent as the
+// difference to a prediction based on the left, upper and upper-left pixels.
+func (c *ClientConn) applyGradientFilter(colors []Color, width int) {
+	pf := &c.pixelFormat
+	maxes := [3]int{int(pf.RedMax), int(pf.GreenMax), int(pf.BlueMax)}
+	if pf.tpixelSize() == 3 {
+		maxes = [3]int{255, 255, 255}
+	}
+	component := func(i, j int) int {
+		if i < 0 {
+			return 0
+		}
+		return int([3]uint16{colors[i].R, colors[i].G, colors[i].B}[j])
+	}
+
+	for i := range colors {
+		x, y := i%width, i/width
+		left, above, aboveLeft := -1, -1, -1
+		if x > 0 {
+			left = i - 1
+		}
+		if y > 0 {
+			above = i - width
+			if x > 0 {
+				aboveLeft = i - width - 1
+			}
+		}
+		var values [3]uint16
+		for j, maxValue := range maxes {
+			prediction := component(left, j) + component(above, j) - component(aboveLeft, j)
+			prediction = max(0, min(maxValue, prediction))
+			values[j] = uint16((prediction + component(i, j)) % (maxValue + 1))
+		}
+		colors[i].R, colors[i].G, colors[i].B = values[0], values[1], values[2]
+	}
+}
+
+// imageColors returns the colors of the pixels of img, converted to the pixel
+// format of the connection.
+func (c *ClientConn) imageColors(img image.Image) ([]Color, error) {
+	return imageToColors(img, &c.pixelFormat, &c.colorMap)
+}
+
+// imageToColors returns the colors of the pixels of img, in row order,
+// converted to the true color pixel format pf.
+func imageToColors(img image.Image, pf *PixelFormat, cm *ColorMap) ([]Color, error) {
+	if !rfbflags.IsTrueColor(pf.TrueColor) {
+		return nil, fmt.Errorf("image data requires a true color pixel format")
+	}
+
+	scale := func(v uint32, max uint16) uint16 {
+		return uint16((v*uint32(max) + 0x7fff) / 0xffff)
+	}
+	b := img.Bounds()
+	colors := make([]Color, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			color := NewColor(pf, cm)
+			color.R = scale(r, pf.RedMax)
+			color.G = scale(g, pf.GreenMax)
+			color.B = scale(bl, pf.BlueMax)
+			colors = append(colors, *color)
+		}
+	}
+	return colors, nil
+}
+
+// marshalTight returns the Tight encoding of the colors of a rectangle of the
+// given width and height. Rectangles with more than one color use PNG
+// compression if usePNG is set, and basic compression otherwise.
+func marshalTight(colors []Color, width, height uint16, usePNG bool) ([]byte, error) {
+	if len(colors) != int(width)*int(height) {
+		return nil, fmt.Errorf("encoding has %d colors for a %dx%d rectangle", len(colors), width, height)
+	}
+	if len(colors) == 0 {
+		return nil, fmt.Errorf("unable to encode an empty rectangle")
+	}
+
+	buf := NewBuffer(nil)
+	pixels, solid, err := tightPixels(colors)
+	if err != nil {
+		return nil, err
+	}
+	if solid {
+		if err := buf.WriteByte(tightFill << 4); err != nil {
+			return nil, err
+		}
+		if err := buf.Write(pixels[:len(pixels)/len(colors)]); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	var data []byte
+	if usePNG {
+		if err := buf.WriteByte(tightPNG << 4); err != nil {
+			return nil, err
+		}
+		var b bytes.Buffer
+		if err := png.Encode(&b, colorsToNRGBA(colors, int(width), int(height))); err != nil {
+			return nil, err
+		}
+		data = b.Bytes()
+	} else {
+		filtered, err := writeTightFilter(buf, pixels, len(pixels)/len(colors), int(width))
+		if err != nil {
+			return nil, err
+		}
+		if len(filtered) < tightMinToCompress {
+			if err := buf.Write(filtered); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		}
+		var b bytes.Buffer
+		zw := zlib.NewWriter(&b)
+		if _, err := zw.Write(filtered); err != nil {
+			return nil, err
+		}
+		if err := zw.Flush(); err != nil {
+			return nil, err
+		}
+		data = b.Bytes()
+	}
+
+	if err := writeCompactLength(buf, len(data)); err != nil {
+		return nil, err
+	}
+	if err := buf.Write(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeTightFilter writes the compression-control and filter of basic
+// compression, resetting and using zlib stream 0, to buf. It returns the
+// filtered TPIXEL values of a rectangle of the given width. The palette filter
+// is used when it is smaller than the copy filter.
+func writeTightFilter(buf *Buffer, pixels []byte, tpixelSize, width int) ([]byte, error) {
+	indices := map[string]int{}
+	var palette []byte
+	for i := 0; i < len(pixels) && len(indices) <= tightMaxPaletteSize; i += tpixelSize {
+		p := pixels[i : i+tpixelSize]
+		if _, ok := indices[string(p)]; !ok {
+			indices[string(p)] = len(indices)
+			palette = append(palette, p...)
+		}
+	}
+	if tpixelSize == 1 && len(indices) != 2 || len(indices) > tightMaxPaletteSize {
+		if err := buf.WriteByte(0x01); err != nil {
+			return nil, err
+		}
+		return pixels, nil
+	}
+
+	if err := buf.Write([3]uint8{tightExplicitFilter<<4 | 0x01, tightFilterPalette, uint8(len(indices) - 1)}); err != nil {
+		return nil, err
+	}
+	if err := buf.Write(palette); err != nil {
+		return nil, err
+	}
+	rowLen := width
+	if len(indices) == 2 {
+		rowLen = (width + 7) / 8
+	}
+	n := len(pixels) / tpixelSize
+	data := make([]byte, n/width*rowLen)
+	for i := 0; i < n; i++ {
+		idx := indices[string(pixels[i*tpixelSize:(i+1)*tpixelSize])]
+		x, y := i%width, i/width
+		if len(indices) == 2 {
+			data[y*rowLen+x/8] |= uint8(idx) << (7 - x%8)
+		} else {
+			data[y*rowLen+x] = uint8(idx)
+		}
+	}
+	return data, nil
+}
+
+// tightPixels returns the TPIXEL values of colors, and whether all of them
+// are identical.
+func tightPixels(colors []Color) ([]byte, bool, error) {
+	var (
+		pixels []byte
+		first  []byte
+		solid  = true
+	)
+	tpixelSize := colors[0].pf.tpixelSize()
+	for _, color := range colors {
+		var p []byte
+		if tpixelSize == 3 {
+			p = []byte{uint8(color.R), uint8(color.G), uint8(color.B)}
+		} else {
+			var err error
+			if p, err = color.Marshal(); err != nil {
+				return nil, false, err
+			}
+		}
+		if first == nil {
+			first = p
+		} else if solid && !bytes.Equal(p, first) {
+			solid = false
+		}
+		pixels = append(pixels, p...)
+	}
+	return pixels, solid, nil
+}
+
+// writeCompactLength writes a Tight compact length to buf.
+func writeCompactLength(buf *Buffer, n int) error {
+	if n > tightMaxLength {
+		return fmt.Errorf("length %d too large for tight encoding", n)
+	}
+	for i := 0; i < 2; i++ {
+		b := uint8(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return buf.WriteByte(b)
+		}
+		if err := buf.WriteByte(b | 0x80); err != nil {
+			return err
+		}
+	}
+	return buf.WriteByte(uint8(n))
+}
+
+// colorsToNRGBA returns an image of the colors of a rectangle of the given
+// width and height.
+func colorsToNRGBA(colors []Color, width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i, color := range colors {
+		r, g, b := color.rgb8()
+		copy(img.Pix[4*i:], []uint8{r, g, b, 0xff})
+	}
+	return img
+}
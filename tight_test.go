@@ -0,0 +1,272 @@
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// pixelFormat565 is a 16 bpp big-endian format with 5, 6 and 5 bit red, green
+// and blue intensities.
+var pixelFormat565 = PixelFormat{16, 16, rfbflags.RFBTrue, rfbflags.RFBTrue, 31, 63, 31, 11, 5, 0, [3]byte{}}
+
+func TestTightEncoding_Type(t *testing.T) {
+	if got, want := (&TightEncoding{}).Type(), encodings.Tight; got != want {
+		t.Errorf("incorrect encoding; got = %v, want = %v", got, want)
+	}
+	if got, want := (&TightPNGEncoding{}).Type(), encodings.TightPNG; got != want {
+		t.Errorf("incorrect encoding; got = %v, want = %v", got, want)
+	}
+}
+
+func TestCompactLength(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		n    int
+		data []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{10000, []byte{0x90, 0x4e}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{tightMaxLength, []byte{0xff, 0xff, 0xff}},
+	} {
+		buf := NewBuffer(nil)
+		if err := writeCompactLength(buf, tt.n); err != nil {
+			t.Errorf("writeCompactLength(%d): unexpected error: %s", tt.n, err)
+			continue
+		}
+		if got, want := buf.Bytes(), tt.data; !bytes.Equal(got, want) {
+			t.Errorf("writeCompactLength(%d): got = %v, want = %v", tt.n, got, want)
+		}
+
+		mockConn.Reset()
+		if err := conn.send(tt.data); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		n, err := conn.readCompactLength()
+		if err != nil {
+			t.Errorf("readCompactLength(%v): unexpected error: %s", tt.data, err)
+			continue
+		}
+		if got, want := n, tt.n; got != want {
+			t.Errorf("readCompactLength(%v): got = %d, want = %d", tt.data, got, want)
+		}
+	}
+
+	if err := writeCompactLength(NewBuffer(nil), tightMaxLength+1); err == nil {
+		t.Error("expected error for a length that is too large")
+	}
+}
+
+func TestTightEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	compress := func(data []byte) []byte {
+		var b bytes.Buffer
+		zw := zlib.NewWriter(&b)
+		zw.Write(data)
+		zw.Flush()
+		return b.Bytes()
+	}
+	withLength := func(data []byte) []byte {
+		buf := NewBuffer(nil)
+		writeCompactLength(buf, len(data))
+		buf.Write(data)
+		return buf.Bytes()
+	}
+	var jpegData bytes.Buffer
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	if err := jpeg.Encode(&jpegData, img, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, tt := range []struct {
+		desc          string
+		pf            PixelFormat
+		width, height uint16
+		data          []byte
+		reds          []uint16
+	}{
+		{"fill", pixelFormat24bit, 3, 1,
+			[]byte{tightFill << 4, 7, 8, 9},
+			[]uint16{7, 7, 7}},
+		{"fill 16bpp", pixelFormat565, 2, 1,
+			[]byte{tightFill << 4, 0xf8, 0x00},
+			[]uint16{31, 31}},
+		{"copy uncompressed", pixelFormat24bit, 2, 1,
+			[]byte{0x00, 1, 0, 0, 2, 0, 0},
+			[]uint16{1, 2}},
+		{"copy compressed", pixelFormat24bit, 4, 1,
+			append([]byte{0x10}, withLength(compress([]byte{1, 0, 0, 2, 0, 0, 3, 0, 0, 4, 0, 0}))...),
+			[]uint16{1, 2, 3, 4}},
+		{"two color palette", pixelFormat24bit, 10, 1,
+			[]byte{0x40, tightFilterPalette, 1, 5, 0, 0, 6, 0, 0, 0xa0, 0xc0},
+			[]uint16{6, 5, 6, 5, 5, 5, 5, 5, 6, 6}},
+		{"palette", pixelFormat565, 3, 1,
+			[]byte{0x40, tightFilterPalette, 2, 0x08, 0x00, 0x10, 0x00, 0x18, 0x00, 2, 0, 1},
+			[]uint16{3, 1, 2}},
+		{"gradient", pixelFormat24bit, 2, 2,
+			append([]byte{0x40, tightFilterGradient}, withLength(compress([]byte{10, 0, 0, 5, 0, 0, 20, 0, 0, 0xfb, 0, 0}))...),
+			[]uint16{10, 15, 30, 30}},
+		{"jpeg", pixelFormat24bit, 8, 8,
+			append([]byte{tightJPEG << 4}, withLength(jpegData.Bytes())...),
+			bytes16(0x80, 64)},
+	} {
+		mockConn.Reset()
+		conn.pixelFormat = tt.pf
+		if err := conn.send(tt.data); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		enc, err := (&TightEncoding{}).Read(conn, &Rectangle{Width: tt.width, Height: tt.height})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		reds := []uint16{}
+		for _, c := range enc.(*TightEncoding).Colors {
+			reds = append(reds, c.R)
+		}
+		if !reflect.DeepEqual(reds, tt.reds) {
+			t.Errorf("%s: incorrect colors; got = %v, want = %v", tt.desc, reds, tt.reds)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes unread", tt.desc, mockConn.b.Len())
+		}
+	}
+
+	// PNG compression is only valid with TightPNG.
+	mockConn.Reset()
+	conn.send([]byte{tightPNG << 4, 0})
+	if _, err := (&TightEncoding{}).Read(conn, &Rectangle{Width: 1, Height: 1}); err == nil {
+		t.Error("expected error for png compression")
+	}
+}
+
+func TestTightEncoding_ReadStreams(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = pixelFormat24bit
+
+	// Two rectangles continue a single zlib stream, and a third resets it.
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	var data []byte
+	for i, ctrl := range []uint8{0x20, 0x20, 0x24} {
+		if ctrl&0x04 != 0 {
+			b.Reset()
+			zw = zlib.NewWriter(&b)
+		}
+		zw.Write(bytes.Repeat([]byte{uint8(i + 1), 0, 0}, 4))
+		zw.Flush()
+		buf := NewBuffer(nil)
+		buf.WriteByte(ctrl)
+		writeCompactLength(buf, b.Len())
+		buf.Write(b.Bytes())
+		data = append(data, buf.Bytes()...)
+		b.Reset()
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		enc, err := (&TightEncoding{}).Read(conn, &Rectangle{Width: 4, Height: 1})
+		if err != nil {
+			t.Fatalf("rectangle %d: unexpected error: %s", i, err)
+		}
+		for _, c := range enc.(*TightEncoding).Colors {
+			if got, want := c.R, uint16(i+1); got != want {
+				t.Errorf("rectangle %d: incorrect color; got = %d, want = %d", i, got, want)
+			}
+		}
+	}
+}
+
+func TestTightEncoding_Marshal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		desc          string
+		png           bool
+		pf            PixelFormat
+		width, height uint16
+		numColors     int
+	}{
+		{"solid", false, pixelFormat24bit, 40, 20, 1},
+		{"small", false, pixelFormat24bit, 2, 1, 2},
+		{"copy", false, pixelFormat24bit, 33, 17, 300},
+		{"copy 16bpp", false, PixelFormat16bit, 33, 17, 300},
+		{"palette mono", false, pixelFormat24bit, 13, 5, 2},
+		{"palette", false, pixelFormat565, 30, 10, 40},
+		{"png solid", true, PixelFormat32bit, 5, 5, 1},
+		{"png", true, pixelFormat24bit, 20, 30, 100},
+		{"png 16bpp", true, pixelFormat565, 20, 30, 100},
+	} {
+		mockConn.Reset()
+		conn.pixelFormat = tt.pf
+		colors := randomColors(r, &conn.pixelFormat, int(tt.width)*int(tt.height), tt.numColors)
+
+		var enc Encoding = NewTightEncoding(colors, tt.width, tt.height)
+		if tt.png {
+			enc = NewTightPNGEncoding(colors, tt.width, tt.height)
+		}
+		data, err := enc.Marshal()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if err := conn.send(data); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		got, err := enc.Read(conn, &Rectangle{Width: tt.width, Height: tt.height})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		var gotColors []Color
+		switch e := got.(type) {
+		case *TightEncoding:
+			gotColors = e.Colors
+		case *TightPNGEncoding:
+			gotColors = e.Colors
+		}
+		if !equalColors(gotColors, colors) {
+			t.Errorf("%s: colors did not round-trip", tt.desc)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes unread", tt.desc, mockConn.b.Len())
+		}
+	}
+}
+
+func TestColorsToNRGBA(t *testing.T) {
+	pf := pixelFormat565
+	colors := []Color{
+		{pf: &pf, R: 31, G: 63, B: 31},
+		{pf: &pf, R: 0, G: 0, B: 0},
+	}
+	img := colorsToNRGBA(colors, 2, 1)
+	if got, want := img.At(0, 0), (color.NRGBA{0xff, 0xff, 0xff, 0xff}); got != want {
+		t.Errorf("incorrect color; got = %v, want = %v", got, want)
+	}
+	if got, want := img.At(1, 0), (color.NRGBA{0, 0, 0, 0xff}); got != want {
+		t.Errorf("incorrect color; got = %v, want = %v", got, want)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Implementation of the Tight security type.
+
+The Tight security type of TightVNC is not part of RFC 6143, and is documented
+by the community maintained RFB protocol specification.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#tight-security-type
+*/
+package vnc
+
+import (
+	"fmt"
+
+	"github.com/kward/go-vnc/logging"
+)
+
+// TightCapability identifies a tunnel, authentication, message or encoding
+// supported by a server using the Tight security type.
+type TightCapability struct {
+	Code      uint32
+	Vendor    [4]byte
+	Signature [8]byte
+}
+
+// String implements the fmt.Stringer interface.
+func (c TightCapability) String() string {
+	return fmt.Sprintf("%d:%s:%s", c.Code, c.Vendor[:], c.Signature[:])
+}
+
+// Codes of the tunnel and authentication capabilities.
+const (
+	tightNoTunnel = uint32(0)
+	tightAuthNone = uint32(1)
+	tightAuthVNC  = uint32(2)
+)
+
+// tightMaxCapabilities is the maximum number of capabilities in a list.
+const tightMaxCapabilities = 1 << 16
+
+// TightCapabilities are the messages and encodings supported by a server
+// using the Tight security type, sent after ServerInit.
+type TightCapabilities struct {
+	ServerMessages []TightCapability
+	ClientMessages []TightCapability
+	Encodings      []TightCapability
+}
+
+// ClientAuthTight is the Tight authentication. It uses no tunnel, and the
+// None or VNC authentication of the server, with the Password of the
+// ClientConfig. The server then sends the TightCapabilities after ServerInit.
+type ClientAuthTight struct{}
+
+// Verify that interfaces are honored.
+var _ ClientAuth = (*ClientAuthTight)(nil)
+
+func (*ClientAuthTight) SecurityType() uint8 {
+	return secTypeTight
+}
+
+func (*ClientAuthTight) Handshake(conn *ClientConn) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientAuthTight.%s", logging.FnName())
+	}
+
+	// Choose no tunnel.
+	tunnels, err := conn.receiveTightCapabilities()
+	if err != nil {
+		return err
+	}
+	if logging.V(logging.ResultLevel) {
+		logging.Infof("tunnels: %v", tunnels)
+	}
+	if len(tunnels) > 0 {
+		if !hasTightCapability(tunnels, tightNoTunnel) {
+			return NewVNCError(fmt.Sprintf("Security Handshake failed; no suitable Tight tunnels found; server supports: %v", tunnels))
+		}
+		if err := conn.send(tightNoTunnel); err != nil {
+			return err
+		}
+	}
+
+	// Choose the first supported authentication. If the server lists none,
+	// then there is no authentication.
+	auths, err := conn.receiveTightCapabilities()
+	if err != nil {
+		return err
+	}
+	if logging.V(logging.ResultLevel) {
+		logging.Infof("auths: %v", auths)
+	}
+	if len(auths) == 0 {
+		return nil
+	}
+	var auth ClientAuth
+	for _, a := range auths {
+		switch a.Code {
+		case tightAuthNone:
+			auth = &ClientAuthNone{}
+		case tightAuthVNC:
+			auth = &ClientAuthVNC{conn.config.Password}
+		default:
+			continue
+		}
+		if err := conn.send(a.Code); err != nil {
+			return err
+		}
+		break
+	}
+	if auth == nil {
+		return NewVNCError(fmt.Sprintf("Security Handshake failed; no suitable Tight auth schemes found; server supports: %v", auths))
+	}
+	return auth.Handshake(conn)
+}
+
+// hasTightCapability returns whether the capabilities include code.
+func hasTightCapability(caps []TightCapability, code uint32) bool {
+	for _, c := range caps {
+		if c.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// receiveTightCapabilities receives a list of capabilities, preceded by their
+// number.
+func (c *ClientConn) receiveTightCapabilities() ([]TightCapability, error) {
+	var n uint32
+	if err := c.receive(&n); err != nil {
+		return nil, err
+	}
+	return c.receiveTightCapabilitiesN(int(n))
+}
+
+// receiveTightCapabilitiesN receives a list of n capabilities.
+func (c *ClientConn) receiveTightCapabilitiesN(n int) ([]TightCapability, error) {
+	if n > tightMaxCapabilities {
+		return nil, NewVNCError(fmt.Sprintf("too many Tight capabilities: %d", n))
+	}
+	caps := make([]TightCapability, n)
+	if err := c.receive(&caps); err != nil {
+		return nil, err
+	}
+	return caps, nil
+}
+
+// tightInit receives the capabilities sent by the server after ServerInit,
+// when the Tight security type is in use.
+func (c *ClientConn) tightInit() error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("%s", logging.FnName())
+	}
+
+	var counts struct {
+		ServerMessages, ClientMessages, Encodings uint16
+		_                                         uint16 // Padding.
+	}
+	if err := c.receive(&counts); err != nil {
+		return err
+	}
+	caps := &TightCapabilities{}
+	var err error
+	if caps.ServerMessages, err = c.receiveTightCapabilitiesN(int(counts.ServerMessages)); err != nil {
+		return err
+	}
+	if caps.ClientMessages, err = c.receiveTightCapabilitiesN(int(counts.ClientMessages)); err != nil {
+		return err
+	}
+	if caps.Encodings, err = c.receiveTightCapabilitiesN(int(counts.Encodings)); err != nil {
+		return err
+	}
+	if logging.V(logging.ResultLevel) {
+		logging.Infof("Tight capabilities: %v", caps)
+	}
+	c.tightCaps = caps
+	return nil
+}
+
+// TightCapabilities returns the server provided Tight capabilities, or nil if
+// the Tight security type is not in use.
+func (c *ClientConn) TightCapabilities() *TightCapabilities {
+	return c.tightCaps
+}
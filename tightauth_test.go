@@ -0,0 +1,117 @@
+package vnc
+
+import (
+	"reflect"
+	"testing"
+)
+
+// tightCapability returns a capability for tests.
+func tightCapability(code uint32, vendor, signature string) TightCapability {
+	c := TightCapability{Code: code}
+	copy(c.Vendor[:], vendor)
+	copy(c.Signature[:], signature)
+	return c
+}
+
+func TestClientAuthTight_Handshake(t *testing.T) {
+	noTunnel := tightCapability(0, "TGHT", "NOTUNNEL")
+	otherTunnel := tightCapability(1, "SICR", "SCHANNEL")
+	none := tightCapability(1, "STDV", "NOAUTH__")
+	vnc := tightCapability(2, "STDV", "VNCAUTH_")
+	external := tightCapability(130, "TGHT", "XTRNAUTH")
+
+	for _, tt := range []struct {
+		desc           string
+		tunnels, auths []TightCapability
+		want           []uint32 // Tunnel and auth codes sent.
+		ok             bool
+	}{
+		{"vnc auth", nil, []TightCapability{vnc}, []uint32{2}, true},
+		{"no tunnel", []TightCapability{otherTunnel, noTunnel}, []TightCapability{external, none, vnc}, []uint32{0, 1}, true},
+		{"no auth", []TightCapability{noTunnel}, nil, []uint32{0}, true},
+		{"unsupported tunnel", []TightCapability{otherTunnel}, nil, nil, false},
+		{"unsupported auth", nil, []TightCapability{external}, nil, false},
+	} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{Password: "12345678"})
+		for _, caps := range [][]TightCapability{tt.tunnels, tt.auths} {
+			conn.send(uint32(len(caps)))
+			conn.send(caps)
+		}
+		vncAuth := len(tt.want) > 0 && tt.want[len(tt.want)-1] == tightAuthVNC
+		if vncAuth {
+			conn.send(wiresharkToChallenge(clientAuthVNCTests[1].ch))
+		}
+
+		err := (&ClientAuthTight{}).Handshake(conn)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		codes := make([]uint32, len(tt.want))
+		if err := conn.receive(&codes); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := codes, tt.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: incorrect codes; got = %v, want = %v", tt.desc, got, want)
+		}
+		if vncAuth {
+			var res vncAuthChallenge
+			if err := conn.receive(&res); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := res, wiresharkToChallenge(clientAuthVNCTests[1].res); got != want {
+				t.Errorf("%s: incorrect response; got = %v, want = %v", tt.desc, got, want)
+			}
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes unread", tt.desc, mockConn.b.Len())
+		}
+	}
+}
+
+func TestClientConn_TightInit(t *testing.T) {
+	want := &TightCapabilities{
+		ServerMessages: []TightCapability{tightCapability(0xfc000101, "TGHT", "FTS_LSDA")},
+		ClientMessages: []TightCapability{tightCapability(0xfc000100, "TGHT", "FTC_LSRQ"), tightCapability(0xfc000102, "TGHT", "FTC_UPRQ")},
+		Encodings:      []TightCapability{tightCapability(7, "TGHT", "TIGHT___"), tightCapability(16, "TRDV", "ZRLE____")},
+	}
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{secType: secTypeTight})
+	conn.send(ServerInit{FBWidth: 100, FBHeight: 200, PixelFormat: NewPixelFormat(16), NameLength: 3})
+	conn.send([]byte("foo"))
+	conn.send([4]uint16{1, 2, 2, 0})
+	conn.send(want.ServerMessages)
+	conn.send(want.ClientMessages)
+	conn.send(want.Encodings)
+
+	if err := conn.serverInit(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := conn.TightCapabilities(); !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect capabilities; got = %v, want = %v", got, want)
+	}
+	if got, want := conn.DesktopName(), "foo"; got != want {
+		t.Errorf("incorrect desktop name; got = %q, want = %q", got, want)
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes unread", mockConn.b.Len())
+	}
+
+	// Without the Tight security type, no capabilities are read.
+	conn = NewClientConn(mockConn, &ClientConfig{})
+	conn.send(ServerInit{FBWidth: 100, FBHeight: 200, PixelFormat: NewPixelFormat(16)})
+	if err := conn.serverInit(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := conn.TightCapabilities(); got != nil {
+		t.Errorf("unexpected capabilities %v", got)
+	}
+}
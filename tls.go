@@ -0,0 +1,92 @@
+/*
+Implementation of the TLS security type.
+
+The TLS security type is not part of RFC 6143. It is used by TightVNC, GNOME
+Vino and VMware, and is documented by the community maintained RFB protocol
+specification.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#tls
+*/
+package vnc
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/kward/go-vnc/logging"
+)
+
+// ClientAuthTLS is the TLS authentication, which wraps the connection in TLS
+// with anonymous Diffie-Hellman key exchange. Inside the TLS session, the
+// server offers its security types again, and the first one matching the
+// ClientConfig Auth list is used, as for the security handshake.
+//
+// Anonymous key exchange does not authenticate the server, so the session is
+// only protected from passive eavesdroppers.
+type ClientAuthTLS struct{}
+
+// Verify that interfaces are honored.
+var _ ClientAuth = (*ClientAuthTLS)(nil)
+
+func (*ClientAuthTLS) SecurityType() uint8 {
+	return secTypeTLS
+}
+
+func (*ClientAuthTLS) Handshake(conn *ClientConn) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientAuthTLS.%s", logging.FnName())
+	}
+
+	if err := conn.startAnonTLS(rand.Reader); err != nil {
+		return err
+	}
+	return conn.negotiateSecurityType(true)
+}
+
+// tlsConfig returns the configuration of a TLS client for the connection,
+// based on cfg.
+func (c *ClientConn) tlsConfig(cfg *tls.Config) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if addr := c.c.RemoteAddr(); cfg.ServerName == "" && addr != nil {
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			cfg.ServerName = host
+		}
+	}
+	return cfg
+}
+
+// startTLS wraps the connection in TLS, so that all later messages are
+// encrypted. The server must present a certificate.
+func (c *ClientConn) startTLS(cfg *tls.Config) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientConn.%s", logging.FnName())
+	}
+
+	tlsConn := tls.Client(c.c, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		return NewVNCError(fmt.Sprintf("Security Handshake failed; TLS handshake failed: %v", err))
+	}
+	c.c = tlsConn
+	return nil
+}
+
+// startAnonTLS wraps the connection in TLS with anonymous Diffie-Hellman key
+// exchange, so that all later messages are encrypted.
+func (c *ClientConn) startAnonTLS(rand io.Reader) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientConn.%s", logging.FnName())
+	}
+
+	tlsConn := newAnonTLSConn(c.c, rand)
+	if err := tlsConn.clientHandshake(); err != nil {
+		return NewVNCError(fmt.Sprintf("Security Handshake failed; TLS handshake failed: %v", err))
+	}
+	c.c = tlsConn
+	return nil
+}
@@ -0,0 +1,86 @@
+package vnc
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// serveTLS performs the server side of the TLS security type over c, offering
+// the security types inside the TLS session. It returns the security type
+// chosen by the client.
+func serveTLS(c net.Conn, offered []uint8) (uint8, error) {
+	tlsConn, err := serveAnonTLS(c, tlsVersion12, 0x006c)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range []interface{}{uint8(len(offered)), offered} {
+		if err := binary.Write(tlsConn, binary.BigEndian, m); err != nil {
+			return 0, err
+		}
+	}
+	var secType uint8
+	if err := binary.Read(tlsConn, binary.BigEndian, &secType); err != nil {
+		return 0, err
+	}
+	if secType == secTypeVNCAuth {
+		if err := NewServerAuthVNC("secret").Handshake(NewServerConn(tlsConn, &ServerConfig{})); err != nil {
+			return 0, err
+		}
+	}
+	return secType, nil
+}
+
+func TestClientAuthTLS_Handshake(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		auth    []ClientAuth
+		offered []uint8
+		secType uint8 // Zero if the handshake fails.
+	}{
+		{"vnc", []ClientAuth{&ClientAuthTLS{}, &ClientAuthVNC{"secret"}}, []uint8{secTypeTLS, secTypeVNCAuth}, secTypeVNCAuth},
+		{"none", []ClientAuth{&ClientAuthNone{}}, []uint8{secTypeNone}, secTypeNone},
+		{"no match", []ClientAuth{&ClientAuthTLS{}}, []uint8{secTypeTLS}, 0},
+	} {
+		sc, cc := tcpConnPair(t)
+		type result struct {
+			secType uint8
+			err     error
+		}
+		served := make(chan result, 1)
+		go func() {
+			secType, err := serveTLS(sc, tt.offered)
+			served <- result{secType, err}
+		}()
+
+		conn := NewClientConn(cc, &ClientConfig{Auth: tt.auth})
+		err := (&ClientAuthTLS{}).Handshake(conn)
+		conn.Close()
+		res := <-served
+		sc.Close()
+
+		if tt.secType == 0 {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if res.err != nil {
+			t.Errorf("%s: unexpected server error: %s", tt.desc, res.err)
+			continue
+		}
+		if got, want := res.secType, tt.secType; got != want {
+			t.Errorf("%s: incorrect security type; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := conn.config.secType, tt.secType; got != want {
+			t.Errorf("%s: incorrect client security type; got = %v, want = %v", tt.desc, got, want)
+		}
+		if _, ok := conn.c.(*anonTLSConn); !ok {
+			t.Errorf("%s: incorrect transport %T", tt.desc, conn.c)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Implementation of the VeNCrypt security type.
+
+VeNCrypt is not part of RFC 6143, and is documented by the community maintained
+RFB protocol specification.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#vencrypt
+*/
+package vnc
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/kward/go-vnc/logging"
+)
+
+// VeNCryptSubType is a sub-type of the VeNCrypt security type, which combines
+// a transport with an authentication method.
+type VeNCryptSubType uint32
+
+// VeNCryptSubType values. The TLS sub-types use TLS with anonymous
+// Diffie-Hellman, which does not authenticate the server, and the X509
+// sub-types verify the certificate of the server. Plain sends the username and
+// password without encryption.
+const (
+	VeNCryptPlain     VeNCryptSubType = 256
+	VeNCryptTLSNone   VeNCryptSubType = 257
+	VeNCryptTLSVnc    VeNCryptSubType = 258
+	VeNCryptTLSPlain  VeNCryptSubType = 259
+	VeNCryptX509None  VeNCryptSubType = 260
+	VeNCryptX509Vnc   VeNCryptSubType = 261
+	VeNCryptX509Plain VeNCryptSubType = 262
+)
+
+// String implements the fmt.Stringer interface.
+func (t VeNCryptSubType) String() string {
+	switch t {
+	case VeNCryptPlain:
+		return "Plain"
+	case VeNCryptTLSNone:
+		return "TLSNone"
+	case VeNCryptTLSVnc:
+		return "TLSVnc"
+	case VeNCryptTLSPlain:
+		return "TLSPlain"
+	case VeNCryptX509None:
+		return "X509None"
+	case VeNCryptX509Vnc:
+		return "X509Vnc"
+	case VeNCryptX509Plain:
+		return "X509Plain"
+	}
+	return fmt.Sprintf("unknown sub-type %d", uint32(t))
+}
+
+// transport returns whether the sub-type uses TLS, and whether the server
+// presents a certificate, which is verified.
+func (t VeNCryptSubType) transport() (useTLS, x509 bool) {
+	switch t {
+	case VeNCryptTLSNone, VeNCryptTLSVnc, VeNCryptTLSPlain:
+		return true, false
+	case VeNCryptX509None, VeNCryptX509Vnc, VeNCryptX509Plain:
+		return true, true
+	}
+	return false, false
+}
+
+// defaultVeNCryptSubTypes are the sub-types accepted by a ClientAuthVeNCrypt
+// that does not list any, in order of preference.
+var defaultVeNCryptSubTypes = []VeNCryptSubType{
+	VeNCryptX509Plain, VeNCryptX509Vnc, VeNCryptX509None,
+	VeNCryptTLSPlain, VeNCryptTLSVnc, VeNCryptTLSNone,
+}
+
+// ClientAuthVeNCrypt is the VeNCrypt authentication, which wraps the
+// connection in TLS before authenticating with the server.
+type ClientAuthVeNCrypt struct {
+	// Sub-types accepted by the client, in order of preference. If this is not
+	// set, then all sub-types except Plain are accepted, preferring the X509
+	// ones.
+	SubTypes []VeNCryptSubType
+
+	// Configuration of the TLS client of the X509 sub-types. If ServerName is
+	// not set, then the host of the server address is used. The TLS sub-types
+	// use anonymous Diffie-Hellman, and ignore it.
+	TLSConfig *tls.Config
+
+	// Username and password of the Plain sub-types. The password is also
+	// used by the Vnc sub-types. If Password is not set, then that of the
+	// ClientConfig is used.
+	Username, Password string
+}
+
+// Verify that interfaces are honored.
+var _ ClientAuth = (*ClientAuthVeNCrypt)(nil)
+
+func (*ClientAuthVeNCrypt) SecurityType() uint8 {
+	return secTypeVeNCrypt
+}
+
+func (auth *ClientAuthVeNCrypt) Handshake(conn *ClientConn) error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ClientAuthVeNCrypt.%s", logging.FnName())
+	}
+
+	// Negotiate version 0.2, the only one using 32-bit sub-types.
+	var version [2]uint8
+	if err := conn.receive(&version); err != nil {
+		return err
+	}
+	if version[0] == 0 && version[1] < 2 {
+		return NewVNCError(fmt.Sprintf("Security Handshake failed; unsupported VeNCrypt version %d.%d", version[0], version[1]))
+	}
+	if err := conn.send([2]uint8{0, 2}); err != nil {
+		return err
+	}
+	var status uint8
+	if err := conn.receive(&status); err != nil {
+		return err
+	}
+	if status != 0 {
+		return NewVNCError("Security Handshake failed; server refused VeNCrypt version 0.2")
+	}
+
+	// Choose a sub-type.
+	var numSubTypes uint8
+	if err := conn.receive(&numSubTypes); err != nil {
+		return err
+	}
+	subTypes := make([]VeNCryptSubType, numSubTypes)
+	if err := conn.receive(&subTypes); err != nil {
+		return err
+	}
+	if logging.V(logging.ResultLevel) {
+		logging.Infof("subTypes: %v", subTypes)
+	}
+	subType, ok := auth.chooseSubType(subTypes)
+	if !ok {
+		return NewVNCError(fmt.Sprintf("Security Handshake failed; no suitable VeNCrypt sub-types found; server supports: %v", subTypes))
+	}
+	if err := conn.send(subType); err != nil {
+		return err
+	}
+
+	if useTLS, x509 := subType.transport(); useTLS {
+		var accepted uint8
+		if err := conn.receive(&accepted); err != nil {
+			return err
+		}
+		if accepted != 1 {
+			return NewVNCError(fmt.Sprintf("Security Handshake failed; server refused VeNCrypt sub-type %v", subType))
+		}
+		var err error
+		if x509 {
+			err = conn.startTLS(conn.tlsConfig(auth.TLSConfig))
+		} else {
+			err = conn.startAnonTLS(rand.Reader)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	password := auth.Password
+	if password == "" {
+		password = conn.config.Password
+	}
+	switch subType {
+	case VeNCryptTLSVnc, VeNCryptX509Vnc:
+		return (&ClientAuthVNC{password}).Handshake(conn)
+	case VeNCryptPlain, VeNCryptTLSPlain, VeNCryptX509Plain:
+		return sendPlainAuth(conn, auth.Username, password)
+	}
+	return nil
+}
+
+// chooseSubType returns the first of the accepted sub-types that the server
+// supports.
+func (auth *ClientAuthVeNCrypt) chooseSubType(supported []VeNCryptSubType) (VeNCryptSubType, bool) {
+	accepted := auth.SubTypes
+	if accepted == nil {
+		accepted = defaultVeNCryptSubTypes
+	}
+	for _, a := range accepted {
+		for _, s := range supported {
+			if a == s {
+				return a, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// sendPlainAuth sends the username and password of the Plain authentication.
+func sendPlainAuth(conn *ClientConn, username, password string) error {
+	buf := NewBuffer(nil)
+	if err := buf.Write([2]uint32{uint32(len(username)), uint32(len(password))}); err != nil {
+		return err
+	}
+	if err := buf.Write([]byte(username + password)); err != nil {
+		return err
+	}
+	return conn.send(buf.Bytes())
+}
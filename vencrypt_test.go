@@ -0,0 +1,212 @@
+package vnc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate for host, and a pool
+// holding it.
+func testCertificate(t *testing.T, host string) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// tcpConnPair returns both ends of a loopback TCP connection.
+func tcpConnPair(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := <-accepted
+	if s == nil {
+		t.Fatal("error accepting connection")
+	}
+	return s, c
+}
+
+// serveVeNCrypt performs the server side of the VeNCrypt handshake over c,
+// offering the sub-types. It returns the sub-type chosen by the client, and
+// the credentials it authenticated with.
+func serveVeNCrypt(c net.Conn, cert tls.Certificate, offered []VeNCryptSubType) (VeNCryptSubType, string, error) {
+	if err := binary.Write(c, binary.BigEndian, [2]uint8{0, 2}); err != nil {
+		return 0, "", err
+	}
+	var version [2]uint8
+	if err := binary.Read(c, binary.BigEndian, &version); err != nil {
+		return 0, "", err
+	}
+	msg := []interface{}{uint8(0), uint8(len(offered)), offered}
+	for _, m := range msg {
+		if err := binary.Write(c, binary.BigEndian, m); err != nil {
+			return 0, "", err
+		}
+	}
+	var subType VeNCryptSubType
+	if err := binary.Read(c, binary.BigEndian, &subType); err != nil {
+		return 0, "", err
+	}
+
+	if useTLS, x509 := subType.transport(); useTLS {
+		if err := binary.Write(c, binary.BigEndian, uint8(1)); err != nil {
+			return 0, "", err
+		}
+		if x509 {
+			tlsConn := tls.Server(c, &tls.Config{Certificates: []tls.Certificate{cert}})
+			if err := tlsConn.Handshake(); err != nil {
+				return 0, "", err
+			}
+			c = tlsConn
+		} else {
+			tlsConn, err := serveAnonTLS(c, tlsVersion12, 0x006c)
+			if err != nil {
+				return 0, "", err
+			}
+			c = tlsConn
+		}
+	}
+
+	switch subType {
+	case VeNCryptTLSVnc, VeNCryptX509Vnc:
+		if err := NewServerAuthVNC("secret").Handshake(NewServerConn(c, &ServerConfig{})); err != nil {
+			return 0, "", err
+		}
+		return subType, "secret", nil
+	case VeNCryptPlain, VeNCryptTLSPlain, VeNCryptX509Plain:
+		var lengths [2]uint32
+		if err := binary.Read(c, binary.BigEndian, &lengths); err != nil {
+			return 0, "", err
+		}
+		creds := make([]byte, lengths[0]+lengths[1])
+		if err := binary.Read(c, binary.BigEndian, creds); err != nil {
+			return 0, "", err
+		}
+		return subType, fmt.Sprintf("%s:%s", creds[:lengths[0]], creds[lengths[0]:]), nil
+	}
+	return subType, "", nil
+}
+
+func TestClientAuthVeNCrypt_Handshake(t *testing.T) {
+	cert, pool := testCertificate(t, "vnc.test")
+	verified := &tls.Config{RootCAs: pool, ServerName: "vnc.test"}
+
+	for _, tt := range []struct {
+		desc    string
+		auth    *ClientAuthVeNCrypt
+		offered []VeNCryptSubType
+		subType VeNCryptSubType // Zero if the handshake fails.
+		creds   string
+	}{
+		{"x509 plain", &ClientAuthVeNCrypt{TLSConfig: verified, Username: "user", Password: "pass"},
+			[]VeNCryptSubType{VeNCryptPlain, VeNCryptTLSNone, VeNCryptX509Plain}, VeNCryptX509Plain, "user:pass"},
+		{"x509 vnc", &ClientAuthVeNCrypt{TLSConfig: verified, Password: "secret"},
+			[]VeNCryptSubType{VeNCryptX509Vnc}, VeNCryptX509Vnc, "secret"},
+		{"tls none", &ClientAuthVeNCrypt{},
+			[]VeNCryptSubType{VeNCryptPlain, VeNCryptTLSNone}, VeNCryptTLSNone, ""},
+		{"tls vnc", &ClientAuthVeNCrypt{Password: "secret"},
+			[]VeNCryptSubType{VeNCryptTLSVnc}, VeNCryptTLSVnc, "secret"},
+		{"tls plain", &ClientAuthVeNCrypt{SubTypes: []VeNCryptSubType{VeNCryptTLSPlain}, Username: "user", Password: "pass"},
+			[]VeNCryptSubType{VeNCryptTLSPlain}, VeNCryptTLSPlain, "user:pass"},
+		{"plain", &ClientAuthVeNCrypt{SubTypes: []VeNCryptSubType{VeNCryptPlain}, Username: "user", Password: "pass"},
+			[]VeNCryptSubType{VeNCryptTLSNone, VeNCryptPlain}, VeNCryptPlain, "user:pass"},
+		{"x509 unverified", &ClientAuthVeNCrypt{TLSConfig: &tls.Config{ServerName: "vnc.test"}},
+			[]VeNCryptSubType{VeNCryptX509None}, 0, ""},
+		{"no sub-type", &ClientAuthVeNCrypt{},
+			[]VeNCryptSubType{VeNCryptPlain}, 0, ""},
+	} {
+		sc, cc := tcpConnPair(t)
+		type result struct {
+			subType VeNCryptSubType
+			creds   string
+			err     error
+		}
+		served := make(chan result, 1)
+		go func() {
+			subType, creds, err := serveVeNCrypt(sc, cert, tt.offered)
+			served <- result{subType, creds, err}
+		}()
+
+		conn := NewClientConn(cc, &ClientConfig{})
+		err := tt.auth.Handshake(conn)
+		conn.Close()
+		res := <-served
+		sc.Close()
+
+		if tt.subType == 0 {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if res.err != nil {
+			t.Errorf("%s: unexpected server error: %s", tt.desc, res.err)
+			continue
+		}
+		if got, want := res.subType, tt.subType; got != want {
+			t.Errorf("%s: incorrect sub-type; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := res.creds, tt.creds; got != want {
+			t.Errorf("%s: incorrect credentials; got = %q, want = %q", tt.desc, got, want)
+		}
+		var ok bool
+		switch useTLS, x509 := tt.subType.transport(); {
+		case x509:
+			_, ok = conn.c.(*tls.Conn)
+		case useTLS:
+			_, ok = conn.c.(*anonTLSConn)
+		default:
+			ok = conn.c == cc
+		}
+		if !ok {
+			t.Errorf("%s: incorrect transport %T", tt.desc, conn.c)
+		}
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"image"
+	"io"
 	"log"
 	"net"
 	"reflect"
+	"sync"
 
 	"context"
 
@@ -70,6 +73,10 @@ type ClientConfig struct {
 	// suitable by the server will be used to authenticate.
 	Auth []ClientAuth
 
+	// Username for servers that require authentication with one, such as
+	// Apple Remote Desktop.
+	Username string
+
 	// Password for servers that require authentication.
 	Password string
 
@@ -104,6 +111,10 @@ func NewClientConfig(p string) *ClientConfig {
 			&SetColorMapEntries{},
 			&Bell{},
 			&ServerCutText{},
+			&EndOfContinuousUpdates{},
+			&ServerFence{},
+			&ServerXVP{},
+			&QEMUAudio{},
 		},
 	}
 }
@@ -120,9 +131,32 @@ type ClientConn struct {
 	// Definition in §5 - Representation of Pixel Data.
 	colorMap ColorMap
 
-	// Name associated with the desktop, sent from the server.
+	// Serializes the messages sent to the server, which are sent both by the
+	// caller and by the goroutine handling server messages.
+	sendMu sync.Mutex
+
+	// Guards the state shared with the goroutine handling server messages.
+	mu sync.Mutex
+
+	// Clipboard of the client, by format, offered to the server when the
+	// ExtendedClipboard extension is in use. Guarded by mu.
+	clipboard map[ClipboardFlags][]byte
+
+	// Clipboard capabilities, sent from the server when the client supports
+	// the ExtendedClipboard pseudo-encoding. Guarded by mu.
+	clipboardCaps *ExtendedClipboard
+
+	// Shape of the cursor, sent from the server when the client supports a
+	// cursor pseudo-encoding. Guarded by mu.
+	cursor *Cursor
+
+	// Name associated with the desktop, sent from the server. Guarded by mu.
 	desktopName string
 
+	// Capabilities of the server, sent after ServerInit when the Tight
+	// security type is in use.
+	tightCaps *TightCapabilities
+
 	// Encodings supported by the client. This should not be modified
 	// directly. Instead, SetEncodings() should be used.
 	encodings Encodings
@@ -133,6 +167,15 @@ type ClientConn struct {
 	// Width of the frame buffer in pixels, sent from the server.
 	fbWidth uint16
 
+	// Position of the pointer, sent from the server when the client supports
+	// the PointerPos pseudo-encoding. Guarded by mu.
+	pointerPos image.Point
+
+	// Layout of the screens of the frame buffer, sent from the server when
+	// the client supports the ExtendedDesktopSize pseudo-encoding. Guarded by
+	// mu.
+	screens []Screen
+
 	// The pixel format associated with the connection. This shouldn't
 	// be modified. If you wish to set a new pixel format, use the
 	// SetPixelFormat method.
@@ -140,6 +183,21 @@ type ClientConn struct {
 
 	// Track metrics on system performance.
 	metrics map[string]metrics.Metric
+
+	// The zlib stream of the Zlib encoding, and the raw and hextile zlib
+	// streams of the ZlibHex encoding, which persist for the lifetime of the
+	// connection.
+	zlibStream       zlibStream
+	zlibHexRawStream zlibStream
+	zlibHexStream    zlibStream
+
+	// The zlib stream of the ZRLE encoding, which persists for the lifetime
+	// of the connection.
+	zrleStream zlibStream
+
+	// The zlib streams of the Tight encoding, which persist for the lifetime
+	// of the connection unless reset by the server.
+	tightStreams [tightNumStreams]zlibStream
 }
 
 func NewClientConn(c net.Conn, cfg *ClientConfig) *ClientConn {
@@ -161,8 +219,29 @@ func (c *ClientConn) Close() error {
 	return c.c.Close()
 }
 
+// Cursor returns the server provided cursor shape, or nil if the server has
+// not sent one. The cursor is only sent when CursorPseudoEncoding or
+// XCursorPseudoEncoding is enabled with SetEncodings.
+func (c *ClientConn) Cursor() *Cursor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cursor
+}
+
+// setCursor stores the server provided cursor shape.
+func (c *ClientConn) setCursor(cursor *Cursor) {
+	if logging.V(logging.ResultLevel) {
+		logging.Infof("cursor: %v hotspot: %v", cursor.Image.Bounds().Size(), cursor.Hotspot)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cursor = cursor
+}
+
 // DesktopName returns the server provided desktop name.
 func (c *ClientConn) DesktopName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.desktopName
 }
 
@@ -171,6 +250,8 @@ func (c *ClientConn) setDesktopName(name string) {
 	if logging.V(logging.ResultLevel) {
 		logging.Infof("desktopName: %s", name)
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.desktopName = name
 }
 
@@ -205,6 +286,44 @@ func (c *ClientConn) setFramebufferWidth(width uint16) {
 	c.fbWidth = width
 }
 
+// PointerPos returns the server provided position of the pointer. The
+// position is only sent when PointerPosPseudoEncoding is enabled with
+// SetEncodings.
+func (c *ClientConn) PointerPos() image.Point {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pointerPos
+}
+
+// setPointerPos stores the server provided position of the pointer.
+func (c *ClientConn) setPointerPos(pos image.Point) {
+	if logging.V(logging.ResultLevel) {
+		logging.Infof("pointerPos: %v", pos)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pointerPos = pos
+}
+
+// Screens returns the server provided layout of the screens of the frame
+// buffer, or nil if the server has not sent one. The layout is only sent when
+// ExtendedDesktopSizePseudoEncoding is enabled with SetEncodings.
+func (c *ClientConn) Screens() []Screen {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.screens
+}
+
+// setScreens stores the server provided screen layout.
+func (c *ClientConn) setScreens(screens []Screen) {
+	if logging.V(logging.ResultLevel) {
+		logging.Infof("screens: %v", screens)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.screens = screens
+}
+
 // ListenAndHandle listens to a VNC server and handles server messages.
 func (c *ClientConn) ListenAndHandle() error {
 	if logging.V(logging.FnDeclLevel) {
@@ -263,6 +382,23 @@ func (c *ClientConn) receive(data interface{}) error {
 	return nil
 }
 
+// reader returns an io.Reader of the network.
+func (c *ClientConn) reader() io.Reader {
+	return &connReader{c}
+}
+
+// connReader implements the io.Reader interface for a ClientConn.
+type connReader struct {
+	c *ClientConn
+}
+
+// Read implements the io.Reader interface.
+func (r *connReader) Read(p []byte) (int, error) {
+	n, err := r.c.c.Read(p)
+	r.c.metrics["bytes-received"].Adjust(int64(n))
+	return n, err
+}
+
 // receiveN receives N packets from the network.
 func (c *ClientConn) receiveN(data interface{}, n int) error {
 	if logging.V(logging.FnDeclLevel) {
@@ -309,6 +445,8 @@ func (c *ClientConn) send(data interface{}) error {
 	if logging.V(logging.SpamLevel) {
 		logging.Infof("ClientConn.%s", logging.FnNameWithArgs("%v", data))
 	}
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
 	if err := binary.Write(c.c, binary.BigEndian, data); err != nil {
 		return err
 	}
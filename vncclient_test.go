@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"image"
 	"log"
 	"net"
 	"reflect"
@@ -86,6 +87,104 @@ func TestClientConn(t *testing.T) {
 	}
 }
 
+// TestClientConn_ServerStateConcurrent checks, with the race detector, that
+// the state sent from the server can be read while it is written by the
+// goroutine handling server messages.
+func TestClientConn_ServerStateConcurrent(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	for _, tt := range []struct {
+		desc string
+		set  func()
+		get  func()
+	}{
+		{"cursor",
+			func() { conn.setCursor(&Cursor{Image: image.NewNRGBA(image.Rect(0, 0, 1, 1))}) },
+			func() { conn.Cursor() }},
+		{"screens",
+			func() { conn.setScreens([]Screen{{ID: 1, Width: 1, Height: 1}}) },
+			func() { conn.Screens() }},
+		{"desktop name",
+			func() { conn.setDesktopName("desktop") },
+			func() { conn.DesktopName() }},
+		{"pointer position",
+			func() { conn.setPointerPos(image.Pt(1, 2)) },
+			func() { conn.PointerPos() }},
+	} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				tt.set()
+			}
+		}()
+		for i := 0; i < 100; i++ {
+			tt.get()
+		}
+		<-done
+	}
+}
+
+func TestFramebufferUpdate_DesktopSizeResults(t *testing.T) {
+	screens := []Screen{{ID: 7, X: 0, Y: 0, Width: 640, Height: 480, Flags: 0}}
+	for _, tt := range []struct {
+		desc   string
+		reason DesktopSizeReason
+		status DesktopSizeStatus
+		want   []*DesktopSizeResult
+	}{
+		{"server", DesktopSizeServer, DesktopSizeOK, nil},
+		{"client", DesktopSizeClient, DesktopSizeOK,
+			[]*DesktopSizeResult{{DesktopSizeOK, 640, 480, screens}}},
+		{"prohibited", DesktopSizeClient, DesktopSizeProhibited,
+			[]*DesktopSizeResult{{DesktopSizeProhibited, 640, 480, screens}}},
+	} {
+		mockConn := &MockConn{}
+		cfg := NewClientConfig("")
+		cfg.ServerMessageCh = make(chan ServerMessage, 10)
+		conn := NewClientConn(mockConn, cfg)
+		conn.encodings = []Encoding{&ExtendedDesktopSizePseudoEncoding{}}
+
+		rect := &Rectangle{
+			X:      uint16(tt.reason),
+			Y:      uint16(tt.status),
+			Width:  640,
+			Height: 480,
+			Enc:    &ExtendedDesktopSizePseudoEncoding{Screens: screens},
+		}
+		data, err := rect.Marshal()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		mockConn.b.Write([]byte{0, 0, 0, 1}) // message-type, padding, number-of-rectangles
+		mockConn.b.Write(data)
+		if err := conn.ListenAndHandle(); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		close(cfg.ServerMessageCh)
+
+		var msgs []ServerMessage
+		for msg := range cfg.ServerMessageCh {
+			msgs = append(msgs, msg)
+		}
+		if len(msgs) != 1 {
+			t.Fatalf("%s: incorrect number of messages; got = %d, want = 1", tt.desc, len(msgs))
+		}
+		fu, ok := msgs[0].(*FramebufferUpdate)
+		if !ok {
+			t.Fatalf("%s: incorrect message %T", tt.desc, msgs[0])
+		}
+		results := fu.DesktopSizeResults()
+		if !reflect.DeepEqual(results, tt.want) {
+			t.Errorf("%s: incorrect results; got = %v, want = %v", tt.desc, results, tt.want)
+		}
+		for _, result := range results {
+			if got, want := result.Err() != nil, tt.status != DesktopSizeOK; got != want {
+				t.Errorf("%s: incorrect error; got = %v, want error = %v", tt.desc, result.Err(), want)
+			}
+		}
+	}
+}
+
 func TestReceiveN(t *testing.T) {
 	tests := []struct {
 		data interface{}
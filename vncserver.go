@@ -0,0 +1,451 @@
+// VNC server implementation.
+
+package vnc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/logging"
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// Serve accepts connections from VNC clients on the listener l, and serves
+// each of them in a new goroutine. Serve returns when ctx is done, or when l
+// fails to accept a connection.
+func Serve(ctx context.Context, l net.Listener, cfg *ServerConfig) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		go func() {
+			conn, err := Accept(ctx, c, cfg)
+			if err != nil {
+				log.Printf("error negotiating connection with %v; %v", c.RemoteAddr(), err)
+				return
+			}
+			if err := conn.ListenAndHandle(); err != nil {
+				log.Printf("error handling connection with %v; %v", c.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Accept negotiates a connection with a VNC client.
+func Accept(ctx context.Context, c net.Conn, cfg *ServerConfig) (*ServerConn, error) {
+	conn := NewServerConn(c, cfg)
+
+	if err := conn.protocolVersionHandshake(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.securityHandshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.clientInit(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.serverInit(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if h, ok := cfg.Handler.(AcceptHandler); ok {
+		if err := h.Accept(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// A ServerConfig structure is used to configure a ServerConn. It may be shared
+// by many connections, and must not be modified once one has been passed to
+// initialize a connection.
+type ServerConfig struct {
+	// Width and height of the framebuffer in pixels. These are taken from
+	// the bounds of Framebuffer, when it is set.
+	Width, Height uint16
+
+	// Framebuffer is the source of the pixel data of the server. If it is set,
+	// then FramebufferUpdateRequest messages are answered with the regions
+	// of it that changed, in the pixel format of each client. Otherwise the
+	// handler must answer them.
+	Framebuffer FramebufferSource
+
+	// The pixel format of the server, sent to clients in ServerInit. Clients
+	// may change it for their connection with SetPixelFormat. If this is not
+	// set, then a 32 bpp true color format with a depth of 24 is used.
+	PixelFormat PixelFormat
+
+	// Authentication methods offered to clients. Protocol version 3.8 clients
+	// choose one of them; for version 3.3 clients the first of None and
+	// VNCAuth is used. If this is not set, then only None is offered.
+	Auth []ServerAuth
+
+	// Name associated with the desktop.
+	DesktopName string
+
+	// Encoders of pixel data. Each rectangle is encoded with the first of the
+	// client's preferred encodings whose encoder accepts it, or Raw if none
+	// does. If this is not set, then DefaultEncoders are used.
+	Encoders []Encoder
+
+	// Handler of client messages. The handler may implement any of
+	// AcceptHandler, SetPixelFormatHandler, SetEncodingsHandler,
+	// FramebufferUpdateRequestHandler, KeyEventHandler, PointerEventHandler and
+	// ClientCutTextHandler. Messages without a handler are discarded, once
+	// they have been applied to the state of the ServerConn.
+	Handler interface{}
+}
+
+// defaultServerPixelFormat is the pixel format of a server whose config does
+// not set one.
+var defaultServerPixelFormat = PixelFormat{32, 24, rfbflags.RFBFalse, rfbflags.RFBTrue, 255, 255, 255, 16, 8, 0, [3]byte{}}
+
+// AcceptHandler is called once the handshakes with a client are complete,
+// before any client messages are handled. Returning an error closes the
+// connection.
+type AcceptHandler interface {
+	Accept(c *ServerConn) error
+}
+
+// SetPixelFormatHandler handles SetPixelFormat messages. The pixel format has
+// already been stored on the connection.
+type SetPixelFormatHandler interface {
+	SetPixelFormat(c *ServerConn, pf PixelFormat) error
+}
+
+// SetEncodingsHandler handles SetEncodings messages. The encodings have
+// already been stored on the connection.
+type SetEncodingsHandler interface {
+	SetEncodings(c *ServerConn, encs []encodings.Encoding) error
+}
+
+// FramebufferUpdateRequestHandler handles FramebufferUpdateRequest messages.
+// The handler is expected to reply with a FramebufferUpdate, at some point.
+type FramebufferUpdateRequestHandler interface {
+	FramebufferUpdateRequest(c *ServerConn, inc rfbflags.RFBFlag, x, y, w, h uint16) error
+}
+
+// KeyEventHandler handles KeyEvent messages.
+type KeyEventHandler interface {
+	KeyEvent(c *ServerConn, key keys.Key, down bool) error
+}
+
+// PointerEventHandler handles PointerEvent messages.
+type PointerEventHandler interface {
+	PointerEvent(c *ServerConn, button buttons.Button, x, y uint16) error
+}
+
+// ClientCutTextHandler handles ClientCutText messages.
+type ClientCutTextHandler interface {
+	ClientCutText(c *ServerConn, text string) error
+}
+
+// The ServerConn type holds server connection information.
+type ServerConn struct {
+	c               net.Conn
+	config          *ServerConfig
+	protocolVersion string
+
+	// Guards the state that is shared with the framebuffer goroutine.
+	mu sync.Mutex
+
+	// Serializes FramebufferUpdate messages, as encodings may continue the
+	// zlib streams of the connection.
+	updateMu sync.Mutex
+
+	// Encoders of the server, keyed by encoding.
+	encoders map[encodings.Encoding]Encoder
+
+	// Encodings supported by the client, in order of preference, as sent
+	// with SetEncodings.
+	encodings []encodings.Encoding
+
+	// Height and width of the frame buffer in pixels.
+	fbHeight, fbWidth uint16
+
+	// Generation of the framebuffer source whose damage was last collected
+	// for the client, and the damaged regions not sent to the client yet.
+	fbGen     uint64
+	fbPending []image.Rectangle
+
+	// The pixel format of the client, which should be used for the pixel data
+	// of FramebufferUpdate messages.
+	pixelFormat PixelFormat
+
+	// Whether the client is willing to share the server with other clients,
+	// as sent with ClientInit.
+	shared bool
+
+	// The pending FramebufferUpdateRequest, when the server has a framebuffer
+	// source.
+	updateRequests chan *FramebufferUpdateRequestMessage
+
+	// The zlib streams of the Zlib and ZRLE encodings.
+	zlibStream, zrleStream zlibWriter
+}
+
+// NewServerConn returns a ServerConn for the connection c with a client.
+func NewServerConn(c net.Conn, cfg *ServerConfig) *ServerConn {
+	pf := cfg.PixelFormat
+	if pf.BPP == 0 {
+		pf = defaultServerPixelFormat
+	}
+	width, height := cfg.Width, cfg.Height
+	if cfg.Framebuffer != nil {
+		size := cfg.Framebuffer.Bounds().Size()
+		width, height = uint16(size.X), uint16(size.Y)
+	}
+	encs := cfg.Encoders
+	if encs == nil {
+		encs = DefaultEncoders()
+	}
+	encoders := make(map[encodings.Encoding]Encoder)
+	for _, e := range encs {
+		encoders[e.Type()] = e
+	}
+	return &ServerConn{
+		c:              c,
+		config:         cfg,
+		encoders:       encoders,
+		encodings:      []encodings.Encoding{encodings.Raw},
+		fbHeight:       height,
+		fbWidth:        width,
+		pixelFormat:    pf,
+		updateRequests: make(chan *FramebufferUpdateRequestMessage, 1),
+	}
+}
+
+// Close a connection to a VNC client.
+func (s *ServerConn) Close() error {
+	return s.c.Close()
+}
+
+// Encodings returns the client provided encodings, in order of preference.
+func (s *ServerConn) Encodings() []encodings.Encoding {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encodings
+}
+
+// setEncodings stores the client provided encodings.
+func (s *ServerConn) setEncodings(encs []encodings.Encoding) {
+	if logging.V(logging.ResultLevel) {
+		logging.Infof("encodings: %v", encs)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encodings = encs
+}
+
+// Encode returns the encoding of a rectangle of the given size, with colors in
+// the pixel format of the client. The first of the client's preferred
+// encodings whose encoder accepts the colors is used, or Raw if none does.
+func (s *ServerConn) Encode(colors []Color, width, height uint16) (Encoding, error) {
+	for _, e := range s.Encodings() {
+		enc, ok := s.encoders[e]
+		if !ok {
+			continue
+		}
+		encoded, err := enc.Encode(s, colors, width, height)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding rectangle with %v; %v", e, err)
+		}
+		if encoded != nil {
+			return encoded, nil
+		}
+	}
+	return &RawEncoding{colors}, nil
+}
+
+// supports returns whether both the client and the server support enc.
+func (s *ServerConn) supports(enc encodings.Encoding) bool {
+	if _, ok := s.encoders[enc]; !ok {
+		return false
+	}
+	for _, e := range s.Encodings() {
+		if e == enc {
+			return true
+		}
+	}
+	return false
+}
+
+// FramebufferHeight returns the framebuffer height.
+func (s *ServerConn) FramebufferHeight() uint16 {
+	return s.fbHeight
+}
+
+// FramebufferWidth returns the framebuffer width.
+func (s *ServerConn) FramebufferWidth() uint16 {
+	return s.fbWidth
+}
+
+// PixelFormat returns the client provided pixel format.
+func (s *ServerConn) PixelFormat() PixelFormat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pixelFormat
+}
+
+// setPixelFormat stores the client provided pixel format.
+func (s *ServerConn) setPixelFormat(pf PixelFormat) {
+	if logging.V(logging.ResultLevel) {
+		logging.Infof("pixelFormat: %v", pf)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pixelFormat = pf
+}
+
+// Shared returns whether the client is willing to share the server with other
+// clients.
+func (s *ServerConn) Shared() bool {
+	return s.shared
+}
+
+// ListenAndHandle listens to a VNC client and handles client messages, until
+// the client disconnects or a message fails.
+func (s *ServerConn) ListenAndHandle() error {
+	if logging.V(logging.FnDeclLevel) {
+		logging.Infof("ServerConn.%s", logging.FnName())
+	}
+	defer s.Close()
+
+	if s.config.Framebuffer != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go s.serveFramebuffer(done)
+	}
+
+	for {
+		var messageType messages.ClientMessage
+		if err := s.receive(&messageType); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if logging.V(logging.ResultLevel) {
+			logging.Infof("message-type: %s", messageType)
+		}
+
+		if err := s.handle(messageType); err != nil {
+			return fmt.Errorf("error handling %v message; %v", messageType, err)
+		}
+	}
+}
+
+// handle reads a client message of the given type, and dispatches it to the
+// handler.
+func (s *ServerConn) handle(messageType messages.ClientMessage) error {
+	h := s.config.Handler
+	switch messageType {
+	case messages.SetPixelFormat:
+		pf, ok, err := s.readSetPixelFormat()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// The client keeps receiving pixel data in the current format.
+			log.Print("warning: ignoring SetPixelFormat; color map pixel formats are unsupported")
+			return nil
+		}
+		s.setPixelFormat(pf)
+		if h, ok := h.(SetPixelFormatHandler); ok {
+			return h.SetPixelFormat(s, pf)
+		}
+	case messages.SetEncodings:
+		encs, err := s.readSetEncodings()
+		if err != nil {
+			return err
+		}
+		s.setEncodings(encs)
+		if h, ok := h.(SetEncodingsHandler); ok {
+			return h.SetEncodings(s, encs)
+		}
+	case messages.FramebufferUpdateRequest:
+		msg, err := s.readFramebufferUpdateRequest()
+		if err != nil {
+			return err
+		}
+		if s.config.Framebuffer != nil {
+			s.requestUpdate(msg)
+		}
+		if h, ok := h.(FramebufferUpdateRequestHandler); ok {
+			return h.FramebufferUpdateRequest(s, msg.Inc, msg.X, msg.Y, msg.Width, msg.Height)
+		}
+	case messages.KeyEvent:
+		msg, err := s.readKeyEvent()
+		if err != nil {
+			return err
+		}
+		if h, ok := h.(KeyEventHandler); ok {
+			return h.KeyEvent(s, msg.Key, rfbflags.ToBool(msg.DownFlag))
+		}
+	case messages.PointerEvent:
+		msg, err := s.readPointerEvent()
+		if err != nil {
+			return err
+		}
+		if h, ok := h.(PointerEventHandler); ok {
+			return h.PointerEvent(s, buttons.Button(msg.Mask), msg.X, msg.Y)
+		}
+	case messages.ClientCutText:
+		text, ok, err := s.readClientCutText()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if h, ok := h.(ClientCutTextHandler); ok {
+			return h.ClientCutText(s, text)
+		}
+	default:
+		return fmt.Errorf("unsupported message-type %v", messageType)
+	}
+	return nil
+}
+
+// receive a packet from the network.
+func (s *ServerConn) receive(data interface{}) error {
+	return binary.Read(s.c, binary.BigEndian, data)
+}
+
+// send a packet to the network.
+func (s *ServerConn) send(data interface{}) error {
+	if logging.V(logging.SpamLevel) {
+		logging.Infof("ServerConn.%s", logging.FnNameWithArgs("%v", data))
+	}
+	return binary.Write(s.c, binary.BigEndian, data)
+}
@@ -0,0 +1,332 @@
+package vnc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// recordingHandler records the client messages of a ServerConn, and answers
+// framebuffer update requests with a solid red update.
+type recordingHandler struct {
+	msgs chan string
+}
+
+func (h *recordingHandler) SetEncodings(c *ServerConn, encs []encodings.Encoding) error {
+	h.msgs <- fmt.Sprintf("SetEncodings %v", encs)
+	return nil
+}
+
+func (h *recordingHandler) FramebufferUpdateRequest(c *ServerConn, inc rfbflags.RFBFlag, x, y, w, hh uint16) error {
+	h.msgs <- fmt.Sprintf("FramebufferUpdateRequest %v %d %d %d %d", inc, x, y, w, hh)
+	pf := c.PixelFormat()
+	colors := make([]Color, int(w)*int(hh))
+	for i := range colors {
+		colors[i] = Color{pf: &pf, R: pf.RedMax}
+	}
+	return c.FramebufferUpdate([]Rectangle{{X: x, Y: y, Width: w, Height: hh, Enc: &RawEncoding{colors}}})
+}
+
+func (h *recordingHandler) KeyEvent(c *ServerConn, key keys.Key, down bool) error {
+	h.msgs <- fmt.Sprintf("KeyEvent %v %t", key, down)
+	return nil
+}
+
+func (h *recordingHandler) PointerEvent(c *ServerConn, button buttons.Button, x, y uint16) error {
+	h.msgs <- fmt.Sprintf("PointerEvent %v %d %d", button, x, y)
+	return nil
+}
+
+func (h *recordingHandler) ClientCutText(c *ServerConn, text string) error {
+	h.msgs <- fmt.Sprintf("ClientCutText %q", text)
+	return c.Bell()
+}
+
+func TestServe(t *testing.T) {
+	for _, version := range []string{"3.8", "3.3"} {
+		h := &recordingHandler{make(chan string, 10)}
+		cfg := &ServerConfig{Width: 64, Height: 48, DesktopName: "fake console", Handler: h}
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("error listening: %s", err)
+		}
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), "vnc_max_proto_version", version))
+		served := make(chan error, 1)
+		go func() { served <- Serve(ctx, ln, cfg) }()
+
+		nc, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("%s: error connecting to server: %s", version, err)
+		}
+		ccfg := NewClientConfig("")
+		ccfg.ServerMessageCh = make(chan ServerMessage, 10)
+		vc, err := Connect(context.Background(), nc, ccfg)
+		if err != nil {
+			t.Fatalf("%s: error negotiating connection: %s", version, err)
+		}
+		if got, want := vc.DesktopName(), "fake console"; got != want {
+			t.Errorf("%s: incorrect desktop name; got = %q, want = %q", version, got, want)
+		}
+		if got, want := [2]uint16{vc.FramebufferWidth(), vc.FramebufferHeight()}, [2]uint16{64, 48}; got != want {
+			t.Errorf("%s: incorrect framebuffer size; got = %v, want = %v", version, got, want)
+		}
+		go vc.ListenAndHandle()
+
+		SetSettle(0) // Disable UI settling for tests.
+		vc.KeyEvent(keys.A, PressKey)
+		vc.PointerEvent(buttons.Left, 10, 20)
+		vc.FramebufferUpdateRequest(rfbflags.RFBFalse, 1, 2, 3, 1)
+		vc.ClientCutText("café")
+
+		for _, want := range []string{
+			"SetEncodings [Raw]",
+			"KeyEvent A true",
+			"PointerEvent Left 10 20",
+			"FramebufferUpdateRequest RFBFalse 1 2 3 1",
+			`ClientCutText "café"`,
+		} {
+			select {
+			case got := <-h.msgs:
+				if got != want {
+					t.Errorf("%s: incorrect message; got = %s, want = %s", version, got, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: timed out waiting for %s", version, want)
+			}
+		}
+
+		for _, want := range []string{"*vnc.FramebufferUpdate", "*vnc.Bell"} {
+			select {
+			case msg := <-ccfg.ServerMessageCh:
+				if got := fmt.Sprintf("%T", msg); got != want {
+					t.Errorf("%s: incorrect server message; got = %s, want = %s", version, got, want)
+				}
+				if fu, ok := msg.(*FramebufferUpdate); ok {
+					rect := fu.Rects[0]
+					if got, want := [4]uint16{rect.X, rect.Y, rect.Width, rect.Height}, [4]uint16{1, 2, 3, 1}; got != want {
+						t.Errorf("%s: incorrect rectangle; got = %v, want = %v", version, got, want)
+					}
+					for _, c := range rect.Enc.(*RawEncoding).Colors {
+						if got, want := c.R, vc.pixelFormat.RedMax; got != want {
+							t.Errorf("%s: incorrect color; got = %d, want = %d", version, got, want)
+						}
+					}
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: timed out waiting for %s", version, want)
+			}
+		}
+
+		vc.Close()
+		cancel()
+		if err := <-served; err != context.Canceled {
+			t.Errorf("%s: unexpected Serve() error: %v", version, err)
+		}
+	}
+}
+
+func TestServerConn_ProtocolVersion(t *testing.T) {
+	for _, tt := range []struct {
+		version string
+		ok      bool
+		want    string
+	}{
+		{PROTO_VERS_3_8, true, PROTO_VERS_3_8},
+		{"RFB 003.889\n", true, PROTO_VERS_3_8},
+		{PROTO_VERS_3_3, true, PROTO_VERS_3_3},
+		{"RFB 003.005\n", true, PROTO_VERS_3_3},
+		{"RFB 003.007\n", true, PROTO_VERS_3_3},
+		{"RFB 004.000\n", false, ""},
+	} {
+		mockConn := &MockConn{}
+		conn := NewServerConn(mockConn, &ServerConfig{})
+		mockConn.b.WriteString(tt.version)
+
+		err := conn.protocolVersionHandshake(context.Background())
+		if err == nil && !tt.ok {
+			t.Errorf("%q: expected error", tt.version)
+		}
+		if err != nil && tt.ok {
+			t.Errorf("%q: unexpected error: %s", tt.version, err)
+		}
+		if got, want := conn.protocolVersion, tt.want; got != want {
+			t.Errorf("%q: incorrect protocol version; got = %q, want = %q", tt.version, got, want)
+		}
+		if got, want := mockConn.b.String(), PROTO_VERS_3_8; got != want {
+			t.Errorf("%q: incorrect server version; got = %q, want = %q", tt.version, got, want)
+		}
+	}
+}
+
+func TestServerConn_SecurityHandshake(t *testing.T) {
+	for _, tt := range []struct {
+		secType uint8
+		ok      bool
+	}{
+		{secTypeNone, true},
+		{secTypeVNCAuth, false},
+	} {
+		mockConn := &MockConn{}
+		conn := NewServerConn(mockConn, &ServerConfig{})
+		conn.protocolVersion = PROTO_VERS_3_8
+		mockConn.b.WriteByte(tt.secType)
+
+		err := conn.securityHandshake()
+		if err == nil && !tt.ok {
+			t.Errorf("%d: expected error", tt.secType)
+		}
+		if err != nil && tt.ok {
+			t.Errorf("%d: unexpected error: %s", tt.secType, err)
+		}
+
+		var msg struct {
+			SecTypes [2]uint8
+			Result   uint32
+		}
+		if err := conn.receive(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := msg.SecTypes, [2]uint8{1, secTypeNone}; got != want {
+			t.Errorf("%d: incorrect security types; got = %v, want = %v", tt.secType, got, want)
+		}
+		if got, want := msg.Result == 0, tt.ok; got != want {
+			t.Errorf("%d: incorrect security result %d", tt.secType, msg.Result)
+		}
+		if !tt.ok {
+			var reasonLen uint32
+			if err := conn.receive(&reasonLen); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := mockConn.b.Len(), int(reasonLen); got != want {
+				t.Errorf("%d: incorrect reason length; got = %d, want = %d", tt.secType, got, want)
+			}
+		}
+	}
+}
+
+func TestServerConn_SecurityHandshakeAuth(t *testing.T) {
+	for _, tt := range []struct {
+		version, password string
+		auth              []ServerAuth
+		reason            string
+	}{
+		{"3.8", "secret", []ServerAuth{NewServerAuthVNC("secret")}, ""},
+		{"3.8", "wrong", []ServerAuth{NewServerAuthVNC("secret")}, "Authentication failed"},
+		{"3.8", "", []ServerAuth{}, "no security types configured"},
+		{"3.8", "secret", []ServerAuth{&ServerAuthVNC{func(*ServerConn) (string, error) {
+			return "", errors.New("password database unavailable")
+		}}}, "SecurityResult handshake failed: Authentication failed"},
+		{"3.8", "secret", []ServerAuth{NewServerAuthVNC("")}, "SecurityResult handshake failed: Authentication failed"},
+		{"3.3", "secret", []ServerAuth{NewServerAuthVNC("secret")}, ""},
+		{"3.3", "wrong", []ServerAuth{NewServerAuthVNC("secret")}, "SecurityResult handshake failed: "},
+		{"3.3", "", nil, ""},
+	} {
+		desc := fmt.Sprintf("%s %q", tt.version, tt.password)
+		sc, cc := net.Pipe()
+		cfg := &ServerConfig{Width: 1, Height: 1, Auth: tt.auth}
+		ctx := context.WithValue(context.Background(), "vnc_max_proto_version", tt.version)
+		served := make(chan error, 1)
+		go func() {
+			conn, err := Accept(ctx, sc, cfg)
+			if err == nil {
+				err = conn.ListenAndHandle()
+			}
+			served <- err
+		}()
+
+		vc, err := Connect(context.Background(), cc, NewClientConfig(tt.password))
+		if tt.reason == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", desc, err)
+				continue
+			}
+			vc.Close()
+			if err := <-served; err != nil {
+				t.Errorf("%s: unexpected server error: %s", desc, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected error", desc)
+			vc.Close()
+			continue
+		}
+		if got, want := err.Error(), tt.reason; !strings.Contains(got, want) {
+			t.Errorf("%s: incorrect error; got = %q, want = %q", desc, got, want)
+		}
+		if err := <-served; err == nil {
+			t.Errorf("%s: expected server error", desc)
+		}
+	}
+}
+
+func TestServerConn_SetPixelFormat(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewServerConn(mockConn, &ServerConfig{})
+
+	// A color map pixel format is ignored, and a true color one is used.
+	colorMap := PixelFormat8bit
+	colorMap.TrueColor = rfbflags.RFBFalse
+	for _, tt := range []struct {
+		pf   PixelFormat
+		want PixelFormat
+	}{
+		{colorMap, defaultServerPixelFormat},
+		{PixelFormat16bit, PixelFormat16bit},
+	} {
+		mockConn.b.Write([]byte{0, 0, 0})
+		if err := conn.send(tt.pf); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.handle(messages.SetPixelFormat); err != nil {
+			t.Fatalf("%v: unexpected error: %s", tt.pf, err)
+		}
+		if got, want := conn.PixelFormat(), tt.want; got != want {
+			t.Errorf("%v: incorrect pixel format; got = %v, want = %v", tt.pf, got, want)
+		}
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes unread", mockConn.b.Len())
+	}
+}
+
+func TestServerConn_ReadClientCutText(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewServerConn(mockConn, &ServerConfig{})
+
+	// An extended message is discarded, and a Latin-1 message read.
+	mockConn.b.Write([]byte{0, 0, 0, 0xff, 0xff, 0xff, 0xfb, 1, 2, 3, 4, 5})
+	mockConn.b.Write([]byte{0, 0, 0, 0, 0, 0, 2, 'o', 0xe9})
+	for _, tt := range []struct {
+		text string
+		ok   bool
+	}{
+		{"", false},
+		{"oé", true},
+	} {
+		text, ok, err := conn.readClientCutText()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got, want := ok, tt.ok; got != want {
+			t.Errorf("incorrect ok; got = %t, want = %t", got, want)
+		}
+		if got, want := text, tt.text; got != want {
+			t.Errorf("incorrect text; got = %q, want = %q", got, want)
+		}
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes unread", mockConn.b.Len())
+	}
+}
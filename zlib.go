@@ -0,0 +1,179 @@
+/*
+Implementation of the Zlib and ZlibHex encodings.
+
+The Zlib encodings are not part of RFC 6143, and are documented by the
+community maintained RFB protocol specification.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#zlib-encoding
+*/
+package vnc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/kward/go-vnc/encodings"
+)
+
+//-----------------------------------------------------------------------------
+// Zlib Encoding
+//
+// Zlib encoding compresses raw pixel data with zlib. A single zlib stream
+// object is used for a given RFB protocol connection, so that Zlib rectangles
+// must be encoded and decoded strictly in order.
+
+// ZlibEncoding holds Zlib encoded rectangle data. The pixel data is stored
+// decoded, in the same layout as RawEncoding.
+type ZlibEncoding struct {
+	Colors        []Color
+	width, height uint16
+	stream        *zlibWriter // The zlib stream of the connection, if known.
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ZlibEncoding)(nil)
+
+// NewZlibEncoding returns a ZlibEncoding for the colors of a rectangle of the
+// given width and height, which continues the zlib stream of the connection.
+func (s *ServerConn) NewZlibEncoding(colors []Color, width, height uint16) *ZlibEncoding {
+	return &ZlibEncoding{colors, width, height, &s.zlibStream}
+}
+
+// Marshal implements the Encoding interface.
+//
+// Encodings created by a ServerConn continue the zlib stream of the
+// connection, and must be marshaled in the order they are sent. Encodings
+// read by a ClientConn start a new zlib stream, so the result is only
+// decodable as the first Zlib rectangle sent over a connection.
+func (e *ZlibEncoding) Marshal() ([]byte, error) {
+	if len(e.Colors) != int(e.width)*int(e.height) {
+		return nil, fmt.Errorf("encoding has %d colors for a %dx%d rectangle", len(e.Colors), e.width, e.height)
+	}
+
+	var pixels bytes.Buffer
+	for _, c := range e.Colors {
+		bytes, err := c.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		pixels.Write(bytes)
+	}
+
+	stream := e.stream
+	if stream == nil {
+		stream = &zlibWriter{}
+	}
+	data, err := stream.compress(pixels.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	buf := NewBuffer(nil)
+	if err := buf.Write(uint32(len(data))); err != nil {
+		return nil, err
+	}
+	if err := buf.Write(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Read implements the Encoding interface.
+func (*ZlibEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	var length uint32
+	if err := c.receive(&length); err != nil {
+		return nil, err
+	}
+	if max := zlibMaxLength(int64(rect.Area()) * int64(c.pixelFormat.BPP/8)); int64(length) > max {
+		return nil, fmt.Errorf("zlib data of %d bytes is too long for a %dx%d rectangle", length, rect.Width, rect.Height)
+	}
+	data := make([]uint8, length)
+	if err := c.receive(data); err != nil {
+		return nil, err
+	}
+
+	r, err := c.zlibStream.decompress(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with zlib encoding: %s", err)
+	}
+	colors, err := c.readPixels(r, rect.Area())
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with zlib encoding: %s", err)
+	}
+	return &ZlibEncoding{colors, rect.Width, rect.Height, nil}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*ZlibEncoding) String() string { return "ZlibEncoding" }
+
+// Type implements the Encoding interface.
+func (*ZlibEncoding) Type() encodings.Encoding { return encodings.Zlib }
+
+//-----------------------------------------------------------------------------
+// ZlibHex Encoding
+//
+// ZlibHex encoding extends Hextile with two tile subencodings. A ZlibRaw tile
+// holds raw pixel data compressed with zlib, and a ZlibHex tile holds the rest
+// of a Hextile tile compressed with zlib. The compressed data of either is
+// preceded by its length. Each subencoding uses its own zlib stream object,
+// which lives for the lifetime of the connection.
+
+// ZlibHex tile subencoding masks.
+const (
+	zlibHexRaw = 1 << 5
+	zlibHexHex = 1 << 6
+)
+
+// ZlibHexEncoding holds ZlibHex encoded rectangle data. The pixel data is
+// stored decoded, in the same layout as RawEncoding.
+type ZlibHexEncoding struct {
+	Colors        []Color
+	width, height uint16
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ZlibHexEncoding)(nil)
+
+// NewZlibHexEncoding returns a ZlibHexEncoding for the colors of a rectangle
+// of the given width and height.
+func NewZlibHexEncoding(colors []Color, width, height uint16) *ZlibHexEncoding {
+	return &ZlibHexEncoding{colors, width, height}
+}
+
+// Marshal implements the Encoding interface.
+//
+// Tiles are encoded with the Hextile subencodings only, which leaves the zlib
+// streams untouched, so that the result is decodable at any point of a
+// connection.
+func (e *ZlibHexEncoding) Marshal() ([]byte, error) {
+	return NewHextileEncoding(e.Colors, e.width, e.height).Marshal()
+}
+
+// Read implements the Encoding interface.
+func (*ZlibHexEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	colors, err := c.readHextile(rect, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with zlibhex encoding: %s", err)
+	}
+	return &ZlibHexEncoding{colors, rect.Width, rect.Height}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*ZlibHexEncoding) String() string { return "ZlibHexEncoding" }
+
+// Type implements the Encoding interface.
+func (*ZlibHexEncoding) Type() encodings.Encoding { return encodings.ZlibHex }
+
+// readZlibHexData reads the compressed data of a ZlibHex tile, and returns a
+// reader of the uncompressed data.
+func (c *ClientConn) readZlibHexData(stream *zlibStream) (io.Reader, error) {
+	var length uint16
+	if err := c.receive(&length); err != nil {
+		return nil, err
+	}
+	data := make([]uint8, length)
+	if err := c.receive(data); err != nil {
+		return nil, err
+	}
+	return stream.decompress(data)
+}
@@ -0,0 +1,193 @@
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+)
+
+// flushWriter compresses data into a zlib stream that spans multiple writes.
+type flushWriter struct {
+	b  bytes.Buffer
+	zw *zlib.Writer
+}
+
+func newFlushWriter() *flushWriter {
+	w := &flushWriter{}
+	w.zw = zlib.NewWriter(&w.b)
+	return w
+}
+
+// compress returns the compressed data of p, flushed to a byte boundary.
+func (w *flushWriter) compress(t *testing.T, p []byte) []byte {
+	if _, err := w.zw.Write(p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := w.zw.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data := append([]byte{}, w.b.Bytes()...)
+	w.b.Reset()
+	return data
+}
+
+func TestZlibEncoding_Type(t *testing.T) {
+	if got, want := (&ZlibEncoding{}).Type(), encodings.Zlib; got != want {
+		t.Errorf("incorrect encoding; got = %v, want = %v", got, want)
+	}
+	if got, want := (&ZlibHexEncoding{}).Type(), encodings.ZlibHex; got != want {
+		t.Errorf("incorrect encoding; got = %v, want = %v", got, want)
+	}
+}
+
+func TestZlibEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = pixelFormat24bit
+
+	// Two rectangles share a single zlib stream.
+	w := newFlushWriter()
+	for i := 1; i <= 2; i++ {
+		data := w.compress(t, bytes.Repeat([]byte{0, 0, uint8(i), 0}, 6))
+		buf := NewBuffer(nil)
+		buf.Write(uint32(len(data)))
+		buf.Write(data)
+		if err := conn.send(buf.Bytes()); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	for i := 1; i <= 2; i++ {
+		enc, err := (&ZlibEncoding{}).Read(conn, &Rectangle{Width: 3, Height: 2})
+		if err != nil {
+			t.Fatalf("rectangle %d: unexpected error: %s", i, err)
+		}
+		for _, c := range enc.(*ZlibEncoding).Colors {
+			if got, want := c.R, uint16(i); got != want {
+				t.Errorf("rectangle %d: incorrect color; got = %d, want = %d", i, got, want)
+			}
+		}
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes unread", mockConn.b.Len())
+	}
+}
+
+func TestZlibEncoding_ReadTooLong(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat32bit
+
+	if err := conn.send(uint32(0xffffffff)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&ZlibEncoding{}).Read(conn, &Rectangle{Width: 64, Height: 64}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestZlibEncoding_Marshal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat16bit
+
+	colors := randomColors(r, &conn.pixelFormat, 20*10, 50)
+	data, err := NewServerConn(&MockConn{}, &ServerConfig{}).NewZlibEncoding(colors, 20, 10).Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	enc, err := (&ZlibEncoding{}).Read(conn, &Rectangle{Width: 20, Height: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := enc.(*ZlibEncoding).Colors, colors; !equalColors(got, want) {
+		t.Errorf("colors did not round-trip")
+	}
+}
+
+func TestZlibHexEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = pixelFormat24bit
+
+	raw, hex := newFlushWriter(), newFlushWriter()
+	tile := func(subenc uint8, w *flushWriter, body []byte) []byte {
+		buf := NewBuffer(nil)
+		buf.WriteByte(subenc)
+		if w == nil {
+			buf.Write(body)
+			return buf.Bytes()
+		}
+		data := w.compress(t, body)
+		buf.Write(uint16(len(data)))
+		buf.Write(data)
+		return buf.Bytes()
+	}
+	pixel := func(red uint8) []byte { return []byte{0, 0, red, 0} }
+
+	// A 64x1 rectangle of four tiles, followed by a second rectangle which
+	// continues the raw zlib stream.
+	var data []byte
+	data = append(data, tile(zlibHexRaw, raw, bytes.Repeat(pixel(1), 16))...)
+	data = append(data, tile(zlibHexHex|hextileBackgroundSpecified|hextileForegroundSpecified|hextileAnySubrects, hex,
+		bytes.Join([][]byte{pixel(2), pixel(3), {1, 0x20, 0x10}}, nil))...)
+	data = append(data, tile(zlibHexHex|hextileBackgroundSpecified, hex, pixel(4))...)
+	data = append(data, tile(hextileBackgroundSpecified, nil, pixel(5))...)
+	data = append(data, tile(zlibHexRaw, raw, bytes.Repeat(pixel(6), 2))...)
+	if err := conn.send(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, tt := range []struct {
+		width uint16
+		reds  []uint16
+	}{
+		{64, append(append(append(append(bytes16(1, 16), 2, 2, 3, 3), bytes16(2, 12)...), bytes16(4, 16)...), bytes16(5, 16)...)},
+		{2, []uint16{6, 6}},
+	} {
+		enc, err := (&ZlibHexEncoding{}).Read(conn, &Rectangle{Width: tt.width, Height: 1})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		reds := []uint16{}
+		for _, c := range enc.(*ZlibHexEncoding).Colors {
+			reds = append(reds, c.R)
+		}
+		if !reflect.DeepEqual(reds, tt.reds) {
+			t.Errorf("incorrect colors; got = %v, want = %v", reds, tt.reds)
+		}
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes unread", mockConn.b.Len())
+	}
+}
+
+func TestZlibHexEncoding_Marshal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat32bit
+
+	colors := randomColors(r, &conn.pixelFormat, 40*20, 4)
+	data, err := NewZlibHexEncoding(colors, 40, 20).Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	enc, err := (&ZlibHexEncoding{}).Read(conn, &Rectangle{Width: 40, Height: 20})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := enc.(*ZlibHexEncoding).Colors, colors; !equalColors(got, want) {
+		t.Errorf("colors did not round-trip")
+	}
+}